@@ -2,20 +2,184 @@ package main
 
 import (
 	"upfcc/internal/aggregator"
+	"upfcc/internal/config"
 	"upfcc/internal/handler"
+	"upfcc/internal/ratelimit"
 	"upfcc/internal/server"
 	"upfcc/internal/sseclient"
+	"upfcc/internal/store"
+	"upfcc/internal/webhook"
 
-	"log"
-	"net/http"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	sseClient := sseclient.New("https://stream.upfluence.co/stream")
-	aggregator := aggregator.New(sseClient)
-	handler := handler.New(sseClient, aggregator)
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+
+	// Every package logs through slog, so that the log level applies to all of them.
+	level, _ := cfg.SlogLevel()
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	// run returns before exiting, so that its deferred calls flush the recording
+	// and the history. The error is logged at the error level, the highest one,
+	// so that it is shown whatever the configured log level.
+	if err := run(cfg); err != nil {
+		slog.Error("Server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run serves the analyses with the given configuration until a SIGTERM or a
+// SIGINT, then shuts the server down gracefully.
+//
+// Parameters:
+//   - cfg: The validated configuration of the server.
+//
+// Returns:
+//   - nil once the server has shut down, or the error that prevented it from running.
+func run(cfg config.Config) error {
+	// SIGTERM (sent by Kubernetes on rollouts) and SIGINT start a graceful shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	sseClient, closeClient, err := newSSEClient(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create the SSE client: %w", err)
+	}
+	defer closeClient()
+
+	// The configuration was validated, so the dimensions and proxies it declares are valid.
+	dimensions, _ := cfg.DimensionRegistry()
+	trustedProxies, _ := cfg.TrustedProxyPrefixes()
+
+	var aggregatorOpts []aggregator.Option
+	handlerOpts := []handler.Option{
+		handler.WithMinDuration(time.Duration(cfg.MinDuration)),
+		handler.WithMaxDuration(time.Duration(cfg.MaxDuration)),
+		handler.WithReadinessWindow(time.Duration(cfg.ReadinessWindow)),
+		handler.WithDimensions(dimensions),
+		handler.WithJobs(cfg.MaxJobs, time.Duration(cfg.JobRetention)),
+	}
+	if cfg.RateLimit > 0 || cfg.MaxAnalyses > 0 {
+		handlerOpts = append(handlerOpts, handler.WithRateLimit(ratelimit.New(ratelimit.Options{
+			Rate:          cfg.RateLimit,
+			Burst:         cfg.RateLimitBurst,
+			MaxConcurrent: cfg.MaxAnalyses,
+		})), handler.WithAPIKeys(cfg.APIKeys), handler.WithTrustedProxies(trustedProxies))
+	}
+	var callbacks *webhook.Dispatcher
+	if cfg.CallbackSecret != "" {
+		callbacks = webhook.NewDispatcher(webhook.Options{
+			Secret:       cfg.CallbackSecret,
+			MaxAttempts:  cfg.CallbackMaxAttempts,
+			Backoff:      time.Duration(cfg.CallbackBackoff),
+			AllowedHosts: cfg.CallbackHosts,
+		})
+		handlerOpts = append(handlerOpts, handler.WithCallbacks(callbacks))
+	}
+	// A replay is read from its start by every analysis, so its posts are not
+	// ingested into the history, where they would be stored again each time.
+	if cfg.HistoryRetention > 0 && cfg.ReplayFile == "" {
+		history, err := store.Open(store.Options{
+			MaxAge:   time.Duration(cfg.HistoryRetention),
+			MaxPosts: cfg.HistoryMaxPosts,
+			Path:     cfg.HistoryFile,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to open the history store: %w", err)
+		}
+		defer history.Close()
+		go history.Ingest(ctx, sseClient)
+
+		aggregatorOpts = append(aggregatorOpts, aggregator.WithHistory(history))
+		handlerOpts = append(handlerOpts, handler.WithHistory(time.Duration(cfg.HistoryRetention)))
+	}
+
+	aggregator := aggregator.New(sseClient, aggregatorOpts...)
+	handler := handler.New(sseClient, aggregator, handlerOpts...)
+
+	// Analysis jobs are not tied to a request, so they are given the grace period
+	// of the in-flight requests on their own, from the start of the shutdown.
+	jobsStopped := make(chan struct{})
+	go func() {
+		defer close(jobsStopped)
+		<-ctx.Done()
+		graceCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownGracePeriod))
+		defer cancel()
+		if err := handler.ShutdownJobs(graceCtx); err != nil {
+			slog.Warn("Grace period is over, truncated the running analysis jobs")
+		}
+	}()
 
 	srv := server.New(handler)
-	log.Println("Starting server on :8080")
-	log.Fatal(http.ListenAndServe(":8080", srv))
+	slog.Info("Starting server", "addr", cfg.ListenAddr)
+	if err := srv.Run(ctx, server.ListenConfig{
+		Addr:              cfg.ListenAddr,
+		GracePeriod:       time.Duration(cfg.ShutdownGracePeriod),
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		TLSCertFile:       cfg.TLS.CertFile,
+		TLSKeyFile:        cfg.TLS.KeyFile,
+		TLSMinVersion:     cfg.TLS.Version(),
+	}); err != nil {
+		return err
+	}
+	<-jobsStopped
+
+	// The results of the jobs truncated above are still to be delivered, so the
+	// pending deliveries are given a grace period of their own.
+	if callbacks != nil {
+		graceCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownGracePeriod))
+		defer cancel()
+		if err := callbacks.Shutdown(graceCtx); err != nil {
+			slog.Warn("Failed to deliver every callback", "error", err)
+		}
+	}
+	slog.Info("Server stopped")
+	return nil
+}
+
+// newSSEClient creates the client the posts are read from: a ReplayClient when a
+// recording is to be replayed, and the upstream SSE stream otherwise, recorded
+// if a record file is configured. The returned function releases the client.
+func newSSEClient(ctx context.Context, cfg config.Config) (handler.SSEClientInterface, func(), error) {
+	if cfg.ReplayFile != "" {
+		slog.Info("Replaying a recording", "file", cfg.ReplayFile, "speed", cfg.ReplaySpeed)
+		client, err := sseclient.NewReplayClient(cfg.ReplayFile, cfg.ReplaySpeed)
+		return client, func() {}, err
+	}
+
+	client := sseclient.New(cfg.UpstreamURLs[0], cfg.UpstreamURLs[1:]...)
+	closeClient := func() {}
+	if cfg.RecordFile != "" {
+		recorder, err := sseclient.OpenRecorder(cfg.RecordFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		slog.Info("Recording the upstream stream", "file", cfg.RecordFile)
+		client.RecordTo(recorder)
+		closeClient = func() { recorder.Close() }
+	}
+
+	// The upstream connection is kept open between analyses, so that /readyz
+	// reflects whether the stream can be read.
+	client.KeepConnected(ctx)
+	return client, closeClient, nil
 }
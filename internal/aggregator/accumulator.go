@@ -0,0 +1,193 @@
+package aggregator
+
+import (
+	"upfcc/internal/dimension"
+	"upfcc/internal/sseclient"
+	"upfcc/internal/stats"
+	"upfcc/internal/types"
+
+	"time"
+)
+
+// accumulator incrementally builds an AnalysisResult from a sequence of posts.
+// Its memory usage does not depend on the number of posts added, only on the
+// number of dimensions, groups and buckets of the query.
+type accumulator struct {
+	query   Query
+	result  AnalysisResult
+	values  []*valueAccumulator     // values holds the accumulator of each dimension of the query, in order.
+	groups  map[string]*accumulator // groups is nil unless the query groups results.
+	buckets *bucketSeries           // buckets is nil unless the query has an interval.
+}
+
+// valueAccumulator accumulates the values of a single dimension.
+type valueAccumulator struct {
+	dimension dimension.Dimension
+	moments   stats.Moments
+	digest    *stats.TDigest // digest is nil unless a quantile statistic is requested.
+	top       *topPosts      // top is nil unless the query asks for the top posts.
+}
+
+// newAccumulator creates an accumulator for the given query.
+func newAccumulator(query Query) *accumulator {
+	acc := &accumulator{query: query}
+	if query.GroupBy != "" {
+		acc.groups = make(map[string]*accumulator)
+	}
+	if query.Interval > 0 {
+		acc.buckets = newBucketSeries(query)
+	}
+
+	needsDigest := false
+	for _, statistic := range query.Stats {
+		if _, ok := statistic.Quantile(); ok {
+			needsDigest = true
+			break
+		}
+	}
+	for _, d := range query.Dimensions {
+		values := &valueAccumulator{dimension: d}
+		if needsDigest {
+			values.digest = stats.NewTDigest(stats.DefaultCompression)
+		}
+		if query.Top > 0 {
+			values.top = newTopPosts(query.Top, query.Dimensions)
+		}
+		acc.values = append(acc.values, values)
+	}
+	return acc
+}
+
+// setWindow sets the time window of the analysis, covered by the buckets
+// aligned to the wall clock.
+func (acc *accumulator) setWindow(from, to time.Time) {
+	if acc.buckets != nil {
+		acc.buckets.from, acc.buckets.to = from, to
+	}
+}
+
+// add adds a post received at the given time to the accumulator.
+func (acc *accumulator) add(post sseclient.Post, at time.Time) {
+	if acc.result.TotalPosts == 0 {
+		acc.result.MinTimestamp = post.Data.Timestamp
+	}
+	acc.result.MaxTimestamp = post.Data.Timestamp
+	acc.result.TotalPosts++
+
+	for _, values := range acc.values {
+		values.add(post)
+	}
+
+	if acc.groups != nil {
+		key := groupKey(acc.query.GroupBy, post)
+		group, ok := acc.groups[key]
+		if !ok {
+			groupQuery := acc.query
+			groupQuery.GroupBy = ""
+			groupQuery.Interval = 0
+			group = newAccumulator(groupQuery)
+			acc.groups[key] = group
+		}
+		group.add(post, at)
+	}
+
+	if acc.buckets != nil {
+		acc.buckets.add(post, at)
+	}
+}
+
+// groupKey returns the key of the group a post belongs to.
+func groupKey(groupBy types.GroupBy, post sseclient.Post) string {
+	switch groupBy {
+	case types.GroupByType:
+		return post.Type
+	default:
+		return ""
+	}
+}
+
+// analysisResult returns the result for the posts added so far. A query with a
+// single dimension reports its average and statistics at the top level, while
+// a query with several dimensions reports them per dimension. A query with an
+// interval also reports the series of its buckets, up to the current time, and
+// is marked as truncated if posts were left out of the series.
+func (acc *accumulator) analysisResult() AnalysisResult {
+	result := acc.result
+
+	if len(acc.query.Dimensions) == 1 {
+		dimensionResult := acc.values[0].dimensionResult(acc.query.Stats)
+		result.AvgValue = dimensionResult.AvgValue
+		result.Stats = dimensionResult.Stats
+		result.Top = dimensionResult.Top
+	} else {
+		result.Dimensions = make(map[types.Dimension]DimensionResult, len(acc.query.Dimensions))
+		for _, values := range acc.values {
+			result.Dimensions[values.dimension.Name] = values.dimensionResult(acc.query.Stats)
+		}
+	}
+
+	if acc.groups != nil {
+		result.Groups = make(map[string]AnalysisResult, len(acc.groups))
+		for key, group := range acc.groups {
+			result.Groups[key] = group.analysisResult()
+		}
+	}
+
+	if acc.buckets != nil {
+		result.Buckets = acc.buckets.results(time.Now())
+		result.Truncated = acc.buckets.truncated
+	}
+	return result
+}
+
+// add adds the value of a post to the accumulator, unless the type of the post
+// does not support the dimension or its value is undefined. Such posts are only
+// counted in the total posts of the result, not in the average of the dimension.
+func (v *valueAccumulator) add(post sseclient.Post) {
+	value, ok := v.dimension.Extract(post)
+	if !ok {
+		return
+	}
+	v.moments.Add(value)
+	if v.digest != nil {
+		v.digest.Add(value)
+	}
+	if v.top != nil {
+		v.top.add(value, post)
+	}
+}
+
+// dimensionResult returns the average, the requested statistics and the top
+// posts, if requested, of the values added so far.
+func (v *valueAccumulator) dimensionResult(statistics []types.Statistic) DimensionResult {
+	result := DimensionResult{AvgValue: v.moments.Mean()}
+	if v.top != nil {
+		result.Top = v.top.result()
+	}
+	if len(statistics) > 0 {
+		result.Stats = make(map[types.Statistic]float64, len(statistics))
+		for _, statistic := range statistics {
+			result.Stats[statistic] = v.statistic(statistic)
+		}
+	}
+	return result
+}
+
+// statistic returns the value of the given statistic for the values added so far.
+func (v *valueAccumulator) statistic(statistic types.Statistic) float64 {
+	if q, ok := statistic.Quantile(); ok {
+		return v.digest.Quantile(q)
+	}
+	switch statistic {
+	case types.Min:
+		return v.moments.Min()
+	case types.Max:
+		return v.moments.Max()
+	case types.Variance:
+		return v.moments.Variance()
+	case types.StdDev:
+		return v.moments.StdDev()
+	default:
+		return 0
+	}
+}
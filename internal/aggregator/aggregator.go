@@ -5,68 +5,249 @@
 package aggregator
 
 import (
+	"upfcc/internal/dimension"
+	"upfcc/internal/filter"
 	"upfcc/internal/sseclient"
+	"upfcc/internal/store"
 	"upfcc/internal/types"
 
+	"context"
+	"encoding/json"
 	"time"
 )
 
 type SSEClientInterface interface {
-	ReadStream(duration time.Duration) chan sseclient.Post
+	ReadStream(ctx context.Context, duration time.Duration) chan sseclient.Post
+}
+
+// dropReporter is implemented by the SSE clients that drop the posts of the
+// subscribers falling behind the stream, and report how many were dropped.
+type dropReporter interface {
+	Subscribe(ctx context.Context, duration time.Duration) (chan sseclient.Post, func() int)
+}
+
+// History is the interface of a store of past posts, used by historical analyses.
+type History interface {
+	Range(from, to time.Time) []store.Entry
 }
 
 // Aggregator is responsible for aggregating data from an SSE client.
 type Aggregator struct {
 	sseClient SSEClientInterface
+	history   History // history answers historical analyses, nil if they are not supported.
+}
+
+// Option configures optional behavior of an Aggregator.
+type Option func(*Aggregator)
+
+// WithHistory sets the store of past posts used to answer queries with a time
+// range, instead of reading the stream.
+func WithHistory(history History) Option {
+	return func(a *Aggregator) {
+		a.history = history
+	}
 }
 
 // New creates a new Aggregator with the provided SSE client.
 //
 // Parameters:
 //   - sseClient: An instance of SSEClientInterface to read the stream of posts.
+//   - opts: Options configuring optional behavior, such as WithHistory.
 //
 // Returns:
 //   - A pointer to the newly created Aggregator.
-func New(sseClient SSEClientInterface) *Aggregator {
-	return &Aggregator{sseClient: sseClient}
+func New(sseClient SSEClientInterface, opts ...Option) *Aggregator {
+	a := &Aggregator{sseClient: sseClient}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Query describes an analysis to be performed by AggregateData.
+type Query struct {
+	Duration   time.Duration         // Duration for which to read and aggregate posts.
+	Dimensions []dimension.Dimension // Dimensions for which to calculate the average value (e.g., likes, comments).
+	Stats      []types.Statistic     // Stats lists the additional statistics to calculate for each dimension, if any.
+	GroupBy    types.GroupBy         // GroupBy breaks the result down into groups, if set.
+	Filter     filter.Predicate      // Filter selects the posts to aggregate, all of them if nil.
+	Top        int                   // Top is the number of posts with the largest values to report for each dimension, if any.
+
+	// Interval, if set, also breaks the result down into a series of buckets of
+	// that duration, aligned as set by Alignment.
+	Interval  time.Duration
+	Alignment types.Alignment
+
+	// From and To, when From is set, select the posts that arrived in [From, To)
+	// from the history instead of reading the stream for Duration.
+	From time.Time
+	To   time.Time
+
+	// Snapshots, if not nil, receives a snapshot of the running result every
+	// SnapshotInterval while the aggregation runs. Snapshots are dropped when
+	// the receiver is not ready, so a slow consumer never delays the aggregation.
+	Snapshots        chan<- AnalysisResult
+	SnapshotInterval time.Duration
 }
 
 // AnalysisResult holds the results of the aggregation process.
+//
+// When a single dimension is analyzed, its average value and statistics are
+// reported in AvgValue and Stats. When several dimensions are analyzed, they
+// are reported per dimension in Dimensions instead. TotalPosts counts every
+// post analyzed, while the average value and statistics of a dimension are
+// computed over the posts whose type supports it and whose value is defined.
 type AnalysisResult struct {
-	TotalPosts   int     `json:"total_posts"`       // Total number of posts analyzed
-	MinTimestamp int64   `json:"minimum_timestamp"` // The timestamp of the first post analyzed
-	MaxTimestamp int64   `json:"maximum_timestamp"` // The timestamp of the last post analyzed
-	AvgValue     float64 `json:"avg_value"`         // Average value of the specified dimension
+	TotalPosts   int                                 `json:"total_posts"`          // Total number of posts analyzed
+	MinTimestamp int64                               `json:"minimum_timestamp"`    // The timestamp of the first post analyzed
+	MaxTimestamp int64                               `json:"maximum_timestamp"`    // The timestamp of the last post analyzed
+	AvgValue     float64                             `json:"avg_value"`            // Average value of the specified dimension
+	Stats        map[types.Statistic]float64         `json:"stats,omitempty"`      // Requested statistics of the specified dimension
+	Top          []TopPost                           `json:"top,omitempty"`        // Posts with the largest values of the specified dimension, largest first
+	Dimensions   map[types.Dimension]DimensionResult `json:"dimensions,omitempty"` // Results of each dimension, when several are analyzed
+	Groups       map[string]AnalysisResult           `json:"groups,omitempty"`     // Results of each group, keyed by group (e.g., post type)
+	Buckets      []Bucket                            `json:"buckets,omitempty"`    // Results of each time bucket, in chronological order, when an interval is set
+	Truncated    bool                                `json:"truncated,omitempty"`  // Whether the analysis stopped before the end of its duration, missed posts because it fell behind the stream, or its buckets were capped at MaxBuckets
+}
+
+// DimensionResult holds the results of a single dimension in a multi-dimension
+// analysis. Like the top level of a single-dimension result, it only covers the
+// posts whose type supports the dimension and whose value is defined.
+type DimensionResult struct {
+	AvgValue float64                     `json:"avg_value"`       // Average value of the dimension
+	Stats    map[types.Statistic]float64 `json:"stats,omitempty"` // Requested statistics of the dimension
+	Top      []TopPost                   `json:"top,omitempty"`   // Posts with the largest values of the dimension, largest first
+}
+
+// matches reports whether a post is selected by the filter of the query.
+func (q Query) matches(post sseclient.Post) bool {
+	return q.Filter == nil || q.Filter(post)
+}
+
+// MarshalJSON encodes the result as JSON. The avg_value field is omitted from
+// multi-dimension results, whose values are keyed per dimension.
+func (r AnalysisResult) MarshalJSON() ([]byte, error) {
+	type result AnalysisResult // result does not have the MarshalJSON method, avoiding a recursion.
+	if len(r.Dimensions) == 0 {
+		return json.Marshal(result(r))
+	}
+	return json.Marshal(struct {
+		result
+		AvgValue *float64 `json:"avg_value,omitempty"` // AvgValue shadows the embedded field.
+	}{result: result(r)})
 }
 
 // AggregateData reads social media posts for a specified duration and calculates
 // the total number of posts, minimum timestamp, maximum timestamp, and average value
-// for the specified dimension.
+// for each specified dimension, along with any additional statistics requested.
+// All the dimensions are computed in a single pass over the same posts.
+// When the query groups results, the overall result also holds one sub-result
+// per group, each with its own totals, timestamps and statistics.
+// When the query has a filter, the posts it does not select are ignored.
+//
+// When the query has an interval, the overall result also holds the series of
+// its time buckets. Buckets are aligned to multiples of the interval, either of
+// the time the posts were received, covering the whole window elapsed so far,
+// or of the Timestamp of the posts, covering the posts received. Buckets
+// without posts are reported with zero values, so that the series has no gaps.
+//
+// Quantiles are estimated with a t-digest and the other statistics are computed
+// with running moments, so memory usage does not grow with the number of posts.
+// The top posts of each dimension are kept in a heap bounded by query.Top.
+//
+// If the query asks for snapshots, the running totals are also sent to
+// query.Snapshots at regular intervals before the final result.
+//
+// When the query has a time range, the posts are read from the history and the
+// result is sent as soon as they have been aggregated. If the aggregator has no
+// history, the result is empty.
+//
+// The aggregation stops as soon as the context is done, in which case the result
+// of the posts read so far is sent, marked as truncated. Exactly one result is always sent, so the
+// caller should give resultChan a buffer of one if it may stop receiving early.
 //
 // Parameters:
-//   - duration: The duration for which to read and aggregate posts.
-//   - dimension: The dimension for which to calculate the average value (e.g., likes, comments).
+//   - ctx: A context that stops the aggregation when done (e.g., client disconnect).
+//   - query: The duration, dimensions, statistics and grouping of the analysis.
 //   - resultChan: A channel to send the result of the aggregation.
-func (a *Aggregator) AggregateData(duration time.Duration, dimension types.Dimension, resultChan chan AnalysisResult) {
-	var analysisResult AnalysisResult
-	totalValue := 0
-	postChan := a.sseClient.ReadStream(duration) // ReadStream will close the channel after the duration has elapsed
-
-	for post := range postChan {
-		if analysisResult.TotalPosts == 0 {
-			analysisResult.MinTimestamp = post.Data.Timestamp
+func (a *Aggregator) AggregateData(ctx context.Context, query Query, resultChan chan AnalysisResult) {
+	if !query.From.IsZero() {
+		resultChan <- a.aggregateHistory(ctx, query)
+		return
+	}
+
+	acc := newAccumulator(query)
+	start := time.Now()
+	acc.setWindow(start, start.Add(query.Duration))
+	postChan, dropped := a.readStream(ctx, query.Duration)
+
+	var ticks <-chan time.Time
+	if query.Snapshots != nil && query.SnapshotInterval > 0 {
+		ticker := time.NewTicker(query.SnapshotInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case post, ok := <-postChan:
+			if !ok {
+				// Send the result after the postChan is closed
+				result := acc.analysisResult()
+				result.Truncated = result.Truncated || ctx.Err() != nil || dropped() > 0
+				resultChan <- result
+				return
+			}
+			if query.matches(post) {
+				acc.add(post, time.Now())
+			}
+		case <-ctx.Done():
+			result := acc.analysisResult()
+			result.Truncated = true
+			resultChan <- result
+			return
+		case <-ticks:
+			select {
+			case query.Snapshots <- acc.analysisResult():
+			default:
+			}
 		}
-		analysisResult.MaxTimestamp = post.Data.Timestamp
-		totalValue += post.Data.GetValue(dimension)
-		analysisResult.TotalPosts++
 	}
+}
+
+// readStream subscribes to the stream of posts for the given duration. The
+// returned function reports the number of posts dropped because the analysis fell
+// behind, which is always 0 if the SSE client does not drop posts.
+func (a *Aggregator) readStream(ctx context.Context, duration time.Duration) (chan sseclient.Post, func() int) {
+	if r, ok := a.sseClient.(dropReporter); ok {
+		return r.Subscribe(ctx, duration)
+	}
+	// ReadStream will close the channel after the duration has elapsed
+	return a.sseClient.ReadStream(ctx, duration), func() int { return 0 }
+}
+
+// historyCheckInterval is the number of posts aggregated from the history
+// between two checks of the context.
+const historyCheckInterval = 4096
 
-	// Calculate and send the result after the postChan is closed
-	if analysisResult.TotalPosts > 0 {
-		analysisResult.AvgValue = float64(totalValue) / float64(analysisResult.TotalPosts)
-	} else {
-		analysisResult.AvgValue = 0
+// aggregateHistory aggregates the posts of the history that arrived in the
+// time range of the query.
+func (a *Aggregator) aggregateHistory(ctx context.Context, query Query) AnalysisResult {
+	acc := newAccumulator(query)
+	acc.setWindow(query.From, query.To)
+	if a.history == nil {
+		return acc.analysisResult()
 	}
 
-	resultChan <- analysisResult
+	for i, entry := range a.history.Range(query.From, query.To) {
+		if i%historyCheckInterval == 0 && ctx.Err() != nil {
+			result := acc.analysisResult()
+			result.Truncated = true
+			return result
+		}
+		if query.matches(entry.Post) {
+			acc.add(entry.Post, entry.At)
+		}
+	}
+	return acc.analysisResult()
 }
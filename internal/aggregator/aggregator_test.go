@@ -1,10 +1,19 @@
 package aggregator
 
 import (
+	"upfcc/internal/dimension"
 	"upfcc/internal/sseclient"
+	"upfcc/internal/store"
 	"upfcc/internal/testingTools"
 	"upfcc/internal/types"
 
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 )
@@ -14,7 +23,7 @@ func TestAggregateData(t *testing.T) {
 		name      string
 		posts     []sseclient.Post
 		duration  time.Duration
-		dimension types.Dimension
+		dimension dimension.Dimension
 		wantPosts int
 		wantAvg   float64
 		wantMinTs int64
@@ -24,7 +33,7 @@ func TestAggregateData(t *testing.T) {
 			name:      "NoPosts",
 			posts:     []sseclient.Post{},
 			duration:  5 * time.Second,
-			dimension: types.Likes,
+			dimension: dimension.Likes,
 			wantPosts: 0,
 			wantAvg:   0,
 			wantMinTs: 0,
@@ -42,7 +51,7 @@ func TestAggregateData(t *testing.T) {
 				},
 			},
 			duration:  5 * time.Second,
-			dimension: types.Dimension("comments"),
+			dimension: dimension.Comments,
 			wantPosts: 1,
 			wantAvg:   0,
 			wantMinTs: testingTools.FakeTimestamp,
@@ -60,7 +69,7 @@ func TestAggregateData(t *testing.T) {
 				},
 			},
 			duration:  5 * time.Second,
-			dimension: types.Likes,
+			dimension: dimension.Likes,
 			wantPosts: 1,
 			wantAvg:   10,
 			wantMinTs: testingTools.FakeTimestamp,
@@ -87,7 +96,7 @@ func TestAggregateData(t *testing.T) {
 				},
 			},
 			duration:  5 * time.Second,
-			dimension: types.Comments,
+			dimension: dimension.Comments,
 			wantPosts: 2,
 			wantAvg:   7.5,
 			wantMinTs: testingTools.FakeTimestamp,
@@ -101,7 +110,7 @@ func TestAggregateData(t *testing.T) {
 			aggregator := New(mockClient)
 			resultChan := make(chan AnalysisResult)
 
-			go aggregator.AggregateData(tt.duration, tt.dimension, resultChan)
+			go aggregator.AggregateData(context.Background(), Query{Duration: tt.duration, Dimensions: []dimension.Dimension{tt.dimension}}, resultChan)
 			result := <-resultChan
 
 			if result.TotalPosts != tt.wantPosts {
@@ -116,10 +125,618 @@ func TestAggregateData(t *testing.T) {
 			if result.MaxTimestamp != tt.wantMaxTS {
 				t.Errorf("Expected MaxTimestamp to be %d, got %d", tt.wantMaxTS, result.MaxTimestamp)
 			}
+			if result.Truncated {
+				t.Errorf("Expected the result not to be truncated")
+			}
+		})
+	}
+}
+
+func TestAggregateDataStats(t *testing.T) {
+	var posts []sseclient.Post
+	for _, likes := range []int{2, 4, 4, 4, 5, 5, 7, 9} {
+		posts = append(posts, sseclient.Post{
+			Type: "tweet",
+			Data: sseclient.SocialPost{Timestamp: testingTools.FakeTimestamp, Likes: likes},
+		})
+	}
+
+	tests := []struct {
+		name      string
+		posts     []sseclient.Post
+		stats     []types.Statistic
+		wantStats map[types.Statistic]float64
+	}{
+		{
+			name:      "NoStatsRequested",
+			posts:     posts,
+			wantStats: nil,
+		},
+		{
+			name:  "AllStats",
+			posts: posts,
+			stats: []types.Statistic{types.P50, types.P90, types.P99, types.Min, types.Max, types.Variance, types.StdDev},
+			wantStats: map[types.Statistic]float64{
+				types.P50:      4.5,
+				types.P90:      8.4,
+				types.P99:      9,
+				types.Min:      2,
+				types.Max:      9,
+				types.Variance: 4,
+				types.StdDev:   2,
+			},
+		},
+		{
+			name:  "NoPosts",
+			posts: []sseclient.Post{},
+			stats: []types.Statistic{types.P95, types.StdDev},
+			wantStats: map[types.Statistic]float64{
+				types.P95:    0,
+				types.StdDev: 0,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aggregator := New(&MockSSEClient{posts: tt.posts})
+			resultChan := make(chan AnalysisResult)
+
+			go aggregator.AggregateData(context.Background(), Query{Duration: time.Second, Dimensions: []dimension.Dimension{dimension.Likes}, Stats: tt.stats}, resultChan)
+			result := <-resultChan
+
+			if len(result.Stats) != len(tt.wantStats) {
+				t.Fatalf("Expected %d stats, got %v", len(tt.wantStats), result.Stats)
+			}
+			for statistic, want := range tt.wantStats {
+				if got, ok := result.Stats[statistic]; !ok || math.Abs(got-want) > 1e-9 {
+					t.Errorf("Expected %s to be %f, got %f", statistic, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregateDataGroupByType(t *testing.T) {
+	posts := []sseclient.Post{
+		{Type: "tweet", Data: sseclient.SocialPost{Timestamp: testingTools.FakeTimestamp, Likes: 10}},
+		{Type: "pin", Data: sseclient.SocialPost{Timestamp: testingTools.FakeTimestamp, Likes: 4}},
+		{Type: "tweet", Data: sseclient.SocialPost{Timestamp: testingTools.FakeTimestamp2, Likes: 20}},
+	}
+	aggregator := New(&MockSSEClient{posts: posts})
+	resultChan := make(chan AnalysisResult)
+
+	go aggregator.AggregateData(context.Background(), Query{Duration: time.Second, Dimensions: []dimension.Dimension{dimension.Likes}, Stats: []types.Statistic{types.Max}, GroupBy: types.GroupByType}, resultChan)
+	result := <-resultChan
+
+	if result.TotalPosts != 3 || result.AvgValue != 34.0/3 {
+		t.Errorf("Expected overall roll-up of 3 posts with average %f, got %d posts with average %f", 34.0/3, result.TotalPosts, result.AvgValue)
+	}
+
+	want := map[string]AnalysisResult{
+		"tweet": {TotalPosts: 2, MinTimestamp: testingTools.FakeTimestamp, MaxTimestamp: testingTools.FakeTimestamp2, AvgValue: 15, Stats: map[types.Statistic]float64{types.Max: 20}},
+		"pin":   {TotalPosts: 1, MinTimestamp: testingTools.FakeTimestamp, MaxTimestamp: testingTools.FakeTimestamp, AvgValue: 4, Stats: map[types.Statistic]float64{types.Max: 4}},
+	}
+	if len(result.Groups) != len(want) {
+		t.Fatalf("Expected %d groups, got %v", len(want), result.Groups)
+	}
+	for key, wantGroup := range want {
+		got := result.Groups[key]
+		if got.TotalPosts != wantGroup.TotalPosts || got.MinTimestamp != wantGroup.MinTimestamp || got.MaxTimestamp != wantGroup.MaxTimestamp || got.AvgValue != wantGroup.AvgValue || got.Stats[types.Max] != wantGroup.Stats[types.Max] {
+			t.Errorf("Expected group %q to be %+v, got %+v", key, wantGroup, got)
+		}
+		if got.Groups != nil {
+			t.Errorf("Expected group %q to have no sub-groups, got %v", key, got.Groups)
+		}
+	}
+}
+
+func TestAggregateDataMultipleDimensions(t *testing.T) {
+	posts := []sseclient.Post{
+		{Type: "tweet", Data: sseclient.SocialPost{Timestamp: testingTools.FakeTimestamp, Likes: 10, Comments: 1, Retweets: 4}},
+		{Type: "tweet", Data: sseclient.SocialPost{Timestamp: testingTools.FakeTimestamp2, Likes: 20, Comments: 3, Retweets: 0}},
+	}
+	aggregator := New(&MockSSEClient{posts: posts})
+	resultChan := make(chan AnalysisResult)
+
+	query := Query{
+		Duration:   time.Second,
+		Dimensions: []dimension.Dimension{dimension.Likes, dimension.Comments, dimension.Retweets},
+		Stats:      []types.Statistic{types.Max},
+	}
+	go aggregator.AggregateData(context.Background(), query, resultChan)
+	result := <-resultChan
+
+	if result.TotalPosts != 2 {
+		t.Errorf("Expected total posts to be 2, got %d", result.TotalPosts)
+	}
+	want := map[types.Dimension]DimensionResult{
+		types.Likes:    {AvgValue: 15, Stats: map[types.Statistic]float64{types.Max: 20}},
+		types.Comments: {AvgValue: 2, Stats: map[types.Statistic]float64{types.Max: 3}},
+		types.Retweets: {AvgValue: 2, Stats: map[types.Statistic]float64{types.Max: 4}},
+	}
+	if len(result.Dimensions) != len(want) {
+		t.Fatalf("Expected %d dimensions, got %v", len(want), result.Dimensions)
+	}
+	for dimension, wantResult := range want {
+		got := result.Dimensions[dimension]
+		if got.AvgValue != wantResult.AvgValue || got.Stats[types.Max] != wantResult.Stats[types.Max] {
+			t.Errorf("Expected dimension %s to be %+v, got %+v", dimension, wantResult, got)
+		}
+	}
+}
+
+func TestAggregateDataPostTypes(t *testing.T) {
+	views := sseclient.SocialPost{Timestamp: testingTools.FakeTimestamp, Extra: map[string]json.RawMessage{"views": json.RawMessage("1000")}}
+	views2 := sseclient.SocialPost{Timestamp: testingTools.FakeTimestamp2, Likes: 30, Extra: map[string]json.RawMessage{"views": json.RawMessage("3000")}}
+	posts := []sseclient.Post{
+		{Type: "tweet", Data: sseclient.SocialPost{Timestamp: testingTools.FakeTimestamp, Likes: 60}},
+		{Type: "youtube_video", Data: views},
+		{Type: "youtube_video", Data: views2},
+	}
+	aggregator := New(&MockSSEClient{posts: posts})
+	resultChan := make(chan AnalysisResult)
+
+	query := Query{
+		Duration:   time.Second,
+		Dimensions: []dimension.Dimension{dimension.Likes, dimension.FromField("views", "views", "youtube_video")},
+	}
+	go aggregator.AggregateData(context.Background(), query, resultChan)
+	result := <-resultChan
+
+	if result.TotalPosts != 3 {
+		t.Errorf("Expected total posts to be 3, got %d", result.TotalPosts)
+	}
+	if got := result.Dimensions[types.Likes].AvgValue; got != 30 {
+		t.Errorf("Expected the likes of every post type to average 30, got %v", got)
+	}
+	if got := result.Dimensions["views"].AvgValue; got != 2000 {
+		t.Errorf("Expected the views of the videos only to average 2000, got %v", got)
+	}
+}
+
+func TestAnalysisResult_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   AnalysisResult
+		expected string
+	}{
+		{
+			name:     "SingleDimension",
+			result:   AnalysisResult{TotalPosts: 1, MinTimestamp: 1, MaxTimestamp: 2, AvgValue: 0},
+			expected: `{"total_posts":1,"minimum_timestamp":1,"maximum_timestamp":2,"avg_value":0}`,
+		},
+		{
+			name: "MultipleDimensions",
+			result: AnalysisResult{TotalPosts: 1, MinTimestamp: 1, MaxTimestamp: 2, Dimensions: map[types.Dimension]DimensionResult{
+				types.Likes: {AvgValue: 3},
+			}},
+			expected: `{"total_posts":1,"minimum_timestamp":1,"maximum_timestamp":2,"dimensions":{"likes":{"avg_value":3}}}`,
+		},
+		{
+			name: "Groups",
+			result: AnalysisResult{TotalPosts: 1, Groups: map[string]AnalysisResult{
+				"tweet": {TotalPosts: 1, Dimensions: map[types.Dimension]DimensionResult{types.Likes: {AvgValue: 3}}},
+			}, Dimensions: map[types.Dimension]DimensionResult{types.Likes: {AvgValue: 3}}},
+			expected: `{"total_posts":1,"minimum_timestamp":0,"maximum_timestamp":0,"dimensions":{"likes":{"avg_value":3}},"groups":{"tweet":{"total_posts":1,"minimum_timestamp":0,"maximum_timestamp":0,"dimensions":{"likes":{"avg_value":3}}}}}`,
+		},
+		{
+			name: "Buckets",
+			result: AnalysisResult{TotalPosts: 1, AvgValue: 3, Buckets: []Bucket{
+				{Start: time.Unix(0, 0).UTC(), End: time.Unix(10, 0).UTC(), TotalPosts: 1, AvgValue: 3},
+				{Start: time.Unix(10, 0).UTC(), End: time.Unix(20, 0).UTC(), Dimensions: map[types.Dimension]DimensionResult{types.Likes: {}}},
+			}},
+			expected: `{"total_posts":1,"minimum_timestamp":0,"maximum_timestamp":0,"avg_value":3,"buckets":[` +
+				`{"start":"1970-01-01T00:00:00Z","end":"1970-01-01T00:00:10Z","total_posts":1,"avg_value":3},` +
+				`{"start":"1970-01-01T00:00:10Z","end":"1970-01-01T00:00:20Z","total_posts":0,"dimensions":{"likes":{"avg_value":0}}}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.result)
+			if err != nil {
+				t.Fatalf("MarshalJSON() error = %v", err)
+			}
+			if string(got) != tt.expected {
+				t.Errorf("MarshalJSON() = %s, want %s", got, tt.expected)
+			}
 		})
 	}
 }
 
+func TestAggregateDataSnapshots(t *testing.T) {
+	posts := make(chan sseclient.Post)
+	aggregator := New(&ChannelSSEClient{postChan: posts})
+	resultChan := make(chan AnalysisResult)
+	snapshots := make(chan AnalysisResult)
+
+	query := Query{
+		Duration:         time.Minute,
+		Dimensions:       []dimension.Dimension{dimension.Likes},
+		Snapshots:        snapshots,
+		SnapshotInterval: time.Millisecond,
+	}
+	go aggregator.AggregateData(context.Background(), query, resultChan)
+
+	posts <- sseclient.Post{Type: "tweet", Data: sseclient.SocialPost{Timestamp: testingTools.FakeTimestamp, Likes: 10}}
+	for snapshot := range snapshots {
+		if snapshot.TotalPosts == 1 {
+			if snapshot.AvgValue != 10 {
+				t.Errorf("Expected snapshot average to be 10, got %f", snapshot.AvgValue)
+			}
+			break
+		}
+	}
+
+	posts <- sseclient.Post{Type: "tweet", Data: sseclient.SocialPost{Timestamp: testingTools.FakeTimestamp2, Likes: 20}}
+	close(posts)
+	result := <-resultChan
+	if result.TotalPosts != 2 || result.AvgValue != 15 {
+		t.Errorf("Expected final result with 2 posts and average 15, got %+v", result)
+	}
+}
+
+func TestAggregateDataDroppedPosts(t *testing.T) {
+	posts := make(chan sseclient.Post, 1)
+	posts <- sseclient.Post{Type: "tweet", Data: sseclient.SocialPost{Timestamp: testingTools.FakeTimestamp, Likes: 10}}
+	close(posts)
+	aggregator := New(&DroppingSSEClient{postChan: posts, dropped: 3})
+	resultChan := make(chan AnalysisResult, 1)
+
+	aggregator.AggregateData(context.Background(), Query{Duration: time.Minute, Dimensions: []dimension.Dimension{dimension.Likes}}, resultChan)
+	result := <-resultChan
+	if result.TotalPosts != 1 || !result.Truncated {
+		t.Errorf("Expected the truncated result of 1 post, got %+v", result)
+	}
+}
+
+func TestAggregateDataContextCancelled(t *testing.T) {
+	defer testingTools.CheckNoGoroutineLeak(t)()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"tweet\":{\"timestamp\":1609459200,\"likes\":10}}\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	defer http.DefaultClient.CloseIdleConnections()
+
+	aggregator := New(sseclient.New(server.URL))
+	ctx, cancel := context.WithCancel(context.Background())
+	resultChan := make(chan AnalysisResult, 1)
+
+	start := time.Now()
+	go aggregator.AggregateData(ctx, Query{Duration: time.Hour, Dimensions: []dimension.Dimension{dimension.Likes}}, resultChan)
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case result := <-resultChan:
+		if result.TotalPosts != 1 || result.AvgValue != 10 || !result.Truncated {
+			t.Errorf("Expected the truncated result of 1 post, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AggregateData did not stop when the context was cancelled")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("AggregateData took %v to stop", elapsed)
+	}
+}
+
+func TestAggregateDataReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.rec")
+	recorder, err := sseclient.OpenRecorder(path)
+	if err != nil {
+		t.Fatalf("OpenRecorder() error = %v", err)
+	}
+	start := time.Unix(1609459200, 0)
+	for i, data := range []string{
+		`{"tweet":{"timestamp":1609459200,"likes":10}}`,
+		`{"instagram_media":{"timestamp":1609459201,"likes":20}}`,
+		`{"tweet":{"timestamp":1609459205,"likes":60}}`,
+	} {
+		recorder.Record(start.Add(time.Duration(i)*2*time.Second), sseclient.Event{Type: "message", Data: data})
+	}
+	recorder.Close()
+
+	client, err := sseclient.NewReplayClient(path, sseclient.ReplayInstant)
+	if err != nil {
+		t.Fatalf("NewReplayClient() error = %v", err)
+	}
+	aggregator := New(client)
+
+	// The same query over the same recording always gives the same result.
+	for run := 0; run < 2; run++ {
+		resultChan := make(chan AnalysisResult, 1)
+		aggregator.AggregateData(context.Background(), Query{Duration: 3 * time.Second, Dimensions: []dimension.Dimension{dimension.Likes}}, resultChan)
+		result := <-resultChan
+
+		if result.TotalPosts != 2 || result.AvgValue != 15 || result.MinTimestamp != 1609459200 || result.MaxTimestamp != 1609459201 {
+			t.Errorf("run %d: expected the 2 posts of the first 3 seconds, got %+v", run+1, result)
+		}
+	}
+}
+
+func TestAggregateDataHistory(t *testing.T) {
+	history, _ := store.Open(store.Options{})
+	start := time.Unix(1700000000, 0)
+	for i, likes := range []int{10, 20, 30, 40} {
+		history.Add(start.Add(time.Duration(i)*time.Minute), sseclient.Post{
+			Type: "tweet",
+			Data: sseclient.SocialPost{Timestamp: int64(1609459200 + i), Likes: likes},
+		})
+	}
+
+	tests := []struct {
+		name       string
+		aggregator *Aggregator
+		query      Query
+		want       AnalysisResult
+	}{
+		{
+			name:       "Range",
+			aggregator: New(&BlockingSSEClient{}, WithHistory(history)),
+			query:      Query{From: start.Add(time.Minute), To: start.Add(3 * time.Minute), Dimensions: []dimension.Dimension{dimension.Likes}},
+			want:       AnalysisResult{TotalPosts: 2, MinTimestamp: 1609459201, MaxTimestamp: 1609459202, AvgValue: 25},
+		},
+		{
+			name:       "Filter",
+			aggregator: New(&BlockingSSEClient{}, WithHistory(history)),
+			query: Query{From: start, To: start.Add(time.Hour), Dimensions: []dimension.Dimension{dimension.Likes},
+				Filter: func(post sseclient.Post) bool { return post.Data.Likes >= 30 }},
+			want: AnalysisResult{TotalPosts: 2, MinTimestamp: 1609459202, MaxTimestamp: 1609459203, AvgValue: 35},
+		},
+		{
+			name:       "Empty Range",
+			aggregator: New(&BlockingSSEClient{}, WithHistory(history)),
+			query:      Query{From: start.Add(time.Hour), To: start.Add(2 * time.Hour), Dimensions: []dimension.Dimension{dimension.Likes}},
+			want:       AnalysisResult{},
+		},
+		{
+			name:       "No History",
+			aggregator: New(&BlockingSSEClient{}),
+			query:      Query{From: start, To: start.Add(time.Hour), Dimensions: []dimension.Dimension{dimension.Likes}},
+			want:       AnalysisResult{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resultChan := make(chan AnalysisResult, 1)
+			tt.aggregator.AggregateData(context.Background(), tt.query, resultChan)
+
+			if result := <-resultChan; result.TotalPosts != tt.want.TotalPosts || result.MinTimestamp != tt.want.MinTimestamp ||
+				result.MaxTimestamp != tt.want.MaxTimestamp || result.AvgValue != tt.want.AvgValue || result.Truncated {
+				t.Errorf("AggregateData() = %+v, want %+v", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateDataBuckets(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	history, _ := store.Open(store.Options{})
+	for _, post := range []struct {
+		at        time.Duration
+		timestamp int64
+		likes     int
+	}{
+		{0, 100, 10},
+		{5 * time.Second, 105, 20},
+		{25 * time.Second, 130, 60},
+		{29 * time.Second, 1000000000, 0},
+	} {
+		history.Add(start.Add(post.at), sseclient.Post{
+			Type: "tweet",
+			Data: sseclient.SocialPost{Timestamp: post.timestamp, Likes: post.likes},
+		})
+	}
+
+	tests := []struct {
+		name       string
+		query      Query
+		wantStarts []int64
+		wantPosts  []int
+		wantAvg    []float64
+	}{
+		{
+			name:       "Wall Clock",
+			query:      Query{From: start, To: start.Add(40 * time.Second), Interval: 10 * time.Second, Alignment: types.AlignWallClock},
+			wantStarts: []int64{1700000000, 1700000010, 1700000020, 1700000030},
+			wantPosts:  []int{2, 0, 2, 0},
+			wantAvg:    []float64{15, 0, 30, 0},
+		},
+		{
+			name:       "Wall Clock Unaligned Window",
+			query:      Query{From: start.Add(15 * time.Second), To: start.Add(35 * time.Second), Interval: 10 * time.Second, Alignment: types.AlignWallClock},
+			wantStarts: []int64{1700000010, 1700000020, 1700000030},
+			wantPosts:  []int{0, 2, 0},
+			wantAvg:    []float64{0, 30, 0},
+		},
+		{
+			name:       "Wall Clock Interval Not Dividing A Day",
+			query:      Query{From: start, To: start.Add(33 * time.Second), Interval: 11 * time.Second, Alignment: types.AlignWallClock},
+			wantStarts: []int64{1699999994, 1700000005, 1700000016, 1700000027},
+			wantPosts:  []int{1, 1, 1, 1},
+			wantAvg:    []float64{10, 20, 60, 0},
+		},
+		{
+			name:       "Timestamp",
+			query:      Query{From: start, To: start.Add(26 * time.Second), Interval: 10 * time.Second, Alignment: types.AlignTimestamp},
+			wantStarts: []int64{100, 110, 120, 130},
+			wantPosts:  []int{2, 0, 0, 1},
+			wantAvg:    []float64{15, 0, 0, 60},
+		},
+		{
+			name:       "Timestamp Beyond Max Buckets",
+			query:      Query{From: start, To: start.Add(time.Minute), Interval: time.Minute, Alignment: types.AlignTimestamp},
+			wantStarts: []int64{60, 120, 999999960},
+			wantPosts:  []int{2, 1, 1},
+			wantAvg:    []float64{15, 60, 0},
+		},
+		{
+			name:       "Timestamp Interval Not Dividing A Day",
+			query:      Query{From: start, To: start.Add(time.Minute), Interval: 7 * time.Minute, Alignment: types.AlignTimestamp},
+			wantStarts: []int64{0, 999999840},
+			wantPosts:  []int{3, 1},
+			wantAvg:    []float64{30, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Dimensions = []dimension.Dimension{dimension.Likes}
+			tt.query.Stats = []types.Statistic{types.P50}
+			resultChan := make(chan AnalysisResult, 1)
+			New(&BlockingSSEClient{}, WithHistory(history)).AggregateData(context.Background(), tt.query, resultChan)
+			result := <-resultChan
+
+			var starts []int64
+			var posts []int
+			var avgs []float64
+			for _, bucket := range result.Buckets {
+				if bucket.End.Sub(bucket.Start) != tt.query.Interval {
+					t.Errorf("bucket [%v, %v) does not last the %v interval", bucket.Start, bucket.End, tt.query.Interval)
+				}
+				if _, ok := bucket.Stats[types.P50]; !ok {
+					t.Errorf("bucket %v has no p50, want it reported even when empty", bucket.Start)
+				}
+				starts = append(starts, bucket.Start.Unix())
+				posts = append(posts, bucket.TotalPosts)
+				avgs = append(avgs, bucket.AvgValue)
+			}
+			if !slices.Equal(starts, tt.wantStarts) || !slices.Equal(posts, tt.wantPosts) || !slices.Equal(avgs, tt.wantAvg) {
+				t.Errorf("buckets start at %v with %v posts averaging %v, want %v with %v posts averaging %v",
+					starts, posts, avgs, tt.wantStarts, tt.wantPosts, tt.wantAvg)
+			}
+		})
+	}
+}
+
+func TestBucketSeriesBucketStart(t *testing.T) {
+	series := newBucketSeries(Query{Interval: 7 * time.Minute})
+	for _, tt := range []struct {
+		at   int64
+		want int64
+	}{
+		{0, 0},
+		{419, 0},
+		{420, 420},
+		{-1, -420},
+		{-420, -420},
+		{-421, -840},
+	} {
+		if got := series.bucketStart(time.Unix(tt.at, 0)); got != tt.want*int64(time.Second) {
+			t.Errorf("bucketStart(%d) = %d, want %d", tt.at, got, tt.want*int64(time.Second))
+		}
+	}
+}
+
+func TestAggregateDataBucketsCap(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	history, _ := store.Open(store.Options{})
+	// Every post has a timestamp of its own minute, so that each one needs a bucket.
+	for i := 0; i < MaxBuckets+5; i++ {
+		history.Add(start, sseclient.Post{Type: "tweet", Data: sseclient.SocialPost{Timestamp: int64(i) * 60, Likes: 1}})
+	}
+
+	query := Query{
+		Dimensions: []dimension.Dimension{dimension.Likes},
+		From:       start,
+		To:         start.Add(time.Second),
+		Interval:   time.Minute,
+		Alignment:  types.AlignTimestamp,
+	}
+	resultChan := make(chan AnalysisResult, 1)
+	New(&BlockingSSEClient{}, WithHistory(history)).AggregateData(context.Background(), query, resultChan)
+	result := <-resultChan
+
+	if len(result.Buckets) != MaxBuckets || !result.Truncated || result.TotalPosts != MaxBuckets+5 {
+		t.Errorf("result has %d buckets, %d posts and truncated %v, want %d buckets, %d posts and truncated",
+			len(result.Buckets), result.TotalPosts, result.Truncated, MaxBuckets, MaxBuckets+5)
+	}
+	if last := result.Buckets[len(result.Buckets)-1]; last.Start.Unix() != (MaxBuckets-1)*60 || last.TotalPosts != 1 {
+		t.Errorf("last bucket = %+v, want the bucket of the last post within the cap", last)
+	}
+}
+
+func TestTopPosts(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		values []int
+		want   []int // want lists the timestamps of the top posts, in order.
+	}{
+		{name: "Fewer Posts Than N", n: 5, values: []int{3, 1, 2}, want: []int{0, 2, 1}},
+		{name: "Keeps The Largest", n: 3, values: []int{5, 1, 9, 7, 3, 8}, want: []int{2, 5, 3}},
+		{name: "Ties Keep The First Posts", n: 2, values: []int{4, 4, 4, 1, 4}, want: []int{0, 1}},
+		{name: "Larger Post Replaces A Tie", n: 2, values: []int{4, 4, 6}, want: []int{2, 0}},
+		{name: "No Posts", n: 3, want: []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top := newTopPosts(tt.n, nil)
+			for i, value := range tt.values {
+				top.add(float64(value), sseclient.Post{Type: "tweet", Data: sseclient.SocialPost{Timestamp: int64(i), Likes: value}})
+			}
+
+			got := []int{}
+			for _, post := range top.result() {
+				got = append(got, int(post.Timestamp))
+				if post.Metrics[types.Likes] != float64(tt.values[post.Timestamp]) || post.Type != "tweet" {
+					t.Errorf("top post %+v does not describe the post added", post)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("top posts have timestamps %v, want %v", got, tt.want)
+			}
+			if len(top.entries) > tt.n {
+				t.Errorf("top posts hold %d entries, want at most %d", len(top.entries), tt.n)
+			}
+		})
+	}
+}
+
+func TestAggregateDataTop(t *testing.T) {
+	history, _ := store.Open(store.Options{})
+	start := time.Unix(1700000000, 0)
+	for i, post := range []sseclient.Post{
+		{Type: "tweet", Data: sseclient.SocialPost{Timestamp: 1, Likes: 10, Retweets: 7}},
+		{Type: "pin", Data: sseclient.SocialPost{Timestamp: 2, Likes: 30}},
+		{Type: "tweet", Data: sseclient.SocialPost{Timestamp: 3, Likes: 20, Retweets: 1}},
+	} {
+		history.Add(start.Add(time.Duration(i)*time.Second), post)
+	}
+
+	resultChan := make(chan AnalysisResult, 1)
+	query := Query{From: start, To: start.Add(time.Minute), Dimensions: []dimension.Dimension{dimension.Likes, dimension.Retweets}, Top: 2}
+	New(&BlockingSSEClient{}, WithHistory(history)).AggregateData(context.Background(), query, resultChan)
+	result := <-resultChan
+
+	tests := []struct {
+		dimension      types.Dimension
+		wantTimestamps []int64
+	}{
+		{dimension: types.Likes, wantTimestamps: []int64{2, 3}},
+		{dimension: types.Retweets, wantTimestamps: []int64{1, 3}},
+	}
+	for _, tt := range tests {
+		var got []int64
+		for _, post := range result.Dimensions[tt.dimension].Top {
+			got = append(got, post.Timestamp)
+		}
+		if !slices.Equal(got, tt.wantTimestamps) {
+			t.Errorf("top posts by %s have timestamps %v, want %v", tt.dimension, got, tt.wantTimestamps)
+		}
+	}
+	if top := result.Dimensions[types.Likes].Top[0]; top.Type != "pin" || top.Metrics[types.Likes] != 30 || top.Metrics[types.Retweets] != 0 {
+		t.Errorf("top post by likes = %+v, want the pin with all its metrics", top)
+	}
+}
+
 /////// Helpers
 
 // MockSSEClient simulates an SSE client for testing purposes.
@@ -128,7 +745,7 @@ type MockSSEClient struct {
 }
 
 // ReadStream simulates reading a stream of posts for the specified duration.
-func (m *MockSSEClient) ReadStream(duration time.Duration) chan sseclient.Post {
+func (m *MockSSEClient) ReadStream(ctx context.Context, duration time.Duration) chan sseclient.Post {
 	postChan := make(chan sseclient.Post)
 	go func() {
 		defer close(postChan)
@@ -138,3 +755,41 @@ func (m *MockSSEClient) ReadStream(duration time.Duration) chan sseclient.Post {
 	}()
 	return postChan
 }
+
+// ChannelSSEClient simulates an SSE client whose posts are sent by the test.
+type ChannelSSEClient struct {
+	postChan chan sseclient.Post
+}
+
+// ReadStream returns the channel of the test, which the test closes when done.
+func (m *ChannelSSEClient) ReadStream(ctx context.Context, duration time.Duration) chan sseclient.Post {
+	return m.postChan
+}
+
+// DroppingSSEClient simulates an SSE client that dropped posts because the analysis fell behind.
+type DroppingSSEClient struct {
+	postChan chan sseclient.Post
+	dropped  int
+}
+
+func (m *DroppingSSEClient) ReadStream(ctx context.Context, duration time.Duration) chan sseclient.Post {
+	postChan, _ := m.Subscribe(ctx, duration)
+	return postChan
+}
+
+// Subscribe returns the channel of the test and the number of posts it dropped.
+func (m *DroppingSSEClient) Subscribe(ctx context.Context, duration time.Duration) (chan sseclient.Post, func() int) {
+	return m.postChan, func() int { return m.dropped }
+}
+
+// BlockingSSEClient simulates an SSE client that sends no posts until the context is done.
+type BlockingSSEClient struct{}
+
+func (m *BlockingSSEClient) ReadStream(ctx context.Context, duration time.Duration) chan sseclient.Post {
+	postChan := make(chan sseclient.Post)
+	go func() {
+		<-ctx.Done()
+		close(postChan)
+	}()
+	return postChan
+}
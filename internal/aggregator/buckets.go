@@ -0,0 +1,174 @@
+package aggregator
+
+import (
+	"upfcc/internal/sseclient"
+	"upfcc/internal/types"
+
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// MaxBuckets is the maximum number of buckets of a time-bucketed analysis whose
+// gaps are filled with empty buckets. Beyond it, which can only happen when the
+// posts are aligned to timestamps spread over a long time, only the buckets
+// holding posts are reported. It also caps the buckets holding posts aligned to
+// timestamps: the posts of further buckets are only counted in the overall
+// result, which is marked as truncated.
+const MaxBuckets = 10000
+
+// Bucket holds the results of the posts of a single time bucket of a
+// time-bucketed analysis. Like AnalysisResult, it reports the average value and
+// statistics at the top level when a single dimension is analyzed, and per
+// dimension in Dimensions otherwise.
+type Bucket struct {
+	Start      time.Time                           `json:"start"`                // Start of the bucket, inclusive
+	End        time.Time                           `json:"end"`                  // End of the bucket, exclusive
+	TotalPosts int                                 `json:"total_posts"`          // Number of posts in the bucket
+	AvgValue   float64                             `json:"avg_value"`            // Average value of the specified dimension
+	Stats      map[types.Statistic]float64         `json:"stats,omitempty"`      // Requested statistics of the specified dimension
+	Dimensions map[types.Dimension]DimensionResult `json:"dimensions,omitempty"` // Results of each dimension, when several are analyzed
+}
+
+// MarshalJSON encodes the bucket as JSON. The avg_value field is omitted from
+// multi-dimension buckets, whose values are keyed per dimension.
+func (b Bucket) MarshalJSON() ([]byte, error) {
+	type bucket Bucket // bucket does not have the MarshalJSON method, avoiding a recursion.
+	if len(b.Dimensions) == 0 {
+		return json.Marshal(bucket(b))
+	}
+	return json.Marshal(struct {
+		bucket
+		AvgValue *float64 `json:"avg_value,omitempty"` // AvgValue shadows the embedded field.
+	}{bucket: bucket(b)})
+}
+
+// bucketSeries accumulates the posts of a time-bucketed analysis, one
+// accumulator per bucket holding posts.
+type bucketSeries struct {
+	interval  time.Duration
+	alignment types.Alignment
+	query     Query                  // query is the query of each bucket, without interval, grouping nor top posts.
+	buckets   map[int64]*accumulator // buckets holds the accumulator of each bucket, keyed by its start in Unix nanoseconds.
+	truncated bool                   // truncated is set once posts were left out of the series, beyond MaxBuckets buckets.
+
+	// from and to are the time window of the analysis. Buckets aligned to the
+	// wall clock cover it up to the current time, even when they hold no posts.
+	from time.Time
+	to   time.Time
+}
+
+// newBucketSeries creates a bucketSeries for the given query.
+func newBucketSeries(query Query) *bucketSeries {
+	bucketQuery := query
+	bucketQuery.Interval = 0
+	bucketQuery.Top = 0
+	bucketQuery.GroupBy = ""
+	return &bucketSeries{
+		interval:  query.Interval,
+		alignment: query.Alignment,
+		query:     bucketQuery,
+		buckets:   make(map[int64]*accumulator),
+	}
+}
+
+// add adds a post received at the given time to its bucket.
+func (s *bucketSeries) add(post sseclient.Post, at time.Time) {
+	if s.alignment == types.AlignTimestamp {
+		at = time.Unix(post.Data.Timestamp, 0)
+	}
+	key := s.bucketStart(at)
+	bucket, ok := s.buckets[key]
+	if !ok {
+		// Each bucket holds an accumulator, so timestamps spread over a long time
+		// must not create buckets without limit. Buckets aligned to the wall clock
+		// are already bounded by the window of the analysis.
+		if s.alignment == types.AlignTimestamp && len(s.buckets) >= MaxBuckets {
+			s.truncated = true
+			return
+		}
+		bucket = newAccumulator(s.query)
+		s.buckets[key] = bucket
+	}
+	bucket.add(post, at)
+}
+
+// bucketStart returns the start, in Unix nanoseconds, of the bucket holding the
+// given time. Buckets are aligned to the Unix epoch, so that their edges are the
+// ones clients compute from Unix timestamps, whatever the interval.
+func (s *bucketSeries) bucketStart(at time.Time) int64 {
+	ns, interval := at.UnixNano(), s.interval.Nanoseconds()
+	// The remainder is floored, so that times before 1970 fall in the bucket
+	// starting before them.
+	rem := ns % interval
+	if rem < 0 {
+		rem += interval
+	}
+	return ns - rem
+}
+
+// results returns the buckets in chronological order. The gaps between the
+// buckets holding posts, and for the wall clock alignment the part of the
+// window elapsed by now, are filled with empty buckets.
+func (s *bucketSeries) results(now time.Time) []Bucket {
+	keys := make([]int64, 0, len(s.buckets))
+	for key := range s.buckets {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	if s.alignment != types.AlignTimestamp && !s.from.IsZero() {
+		end := s.to
+		if now.Before(end) {
+			end = now
+		}
+		if end.After(s.from) {
+			first := s.bucketStart(s.from)
+			last := s.bucketStart(end.Add(-1))
+			if len(keys) == 0 || first < keys[0] {
+				keys = slices.Insert(keys, 0, first)
+			}
+			if last > keys[len(keys)-1] {
+				keys = append(keys, last)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return []Bucket{}
+	}
+
+	if first, last := keys[0], keys[len(keys)-1]; (last-first)/s.interval.Nanoseconds() < MaxBuckets {
+		keys = make([]int64, 0, (last-first)/s.interval.Nanoseconds()+1)
+		for key := first; key <= last; key += s.interval.Nanoseconds() {
+			keys = append(keys, key)
+		}
+	}
+
+	// Empty buckets share the result of an empty accumulator, so that filling
+	// the gaps does not allocate a quantile sketch per bucket.
+	var empty *AnalysisResult
+	buckets := make([]Bucket, 0, len(keys))
+	for _, key := range keys {
+		var result AnalysisResult
+		if bucket, ok := s.buckets[key]; ok {
+			result = bucket.analysisResult()
+		} else {
+			if empty == nil {
+				emptyResult := newAccumulator(s.query).analysisResult()
+				empty = &emptyResult
+			}
+			result = *empty
+		}
+
+		start := time.Unix(0, key).UTC()
+		buckets = append(buckets, Bucket{
+			Start:      start,
+			End:        start.Add(s.interval),
+			TotalPosts: result.TotalPosts,
+			AvgValue:   result.AvgValue,
+			Stats:      result.Stats,
+			Dimensions: result.Dimensions,
+		})
+	}
+	return buckets
+}
@@ -0,0 +1,127 @@
+package aggregator
+
+import (
+	"upfcc/internal/dimension"
+	"upfcc/internal/sseclient"
+	"upfcc/internal/types"
+
+	"container/heap"
+	"slices"
+)
+
+// TopPost is one of the posts with the largest values of a dimension.
+type TopPost struct {
+	Type         string                      `json:"type"`                    // Type of the post (e.g., tweet, pin)
+	ID           sseclient.Identifier        `json:"id,omitempty"`            // Identifier of the post, if sent by its network
+	Author       string                      `json:"author,omitempty"`        // Author of the post, if sent by its network
+	URL          string                      `json:"url,omitempty"`           // Address of the post, if sent by its network
+	Timestamp    int64                       `json:"timestamp"`               // Timestamp of the post
+	Metrics      map[types.Dimension]float64 `json:"metrics"`                 // Value of the built-in and analyzed dimensions of the post
+	ExtraMetrics map[string]float64          `json:"extra_metrics,omitempty"` // Value of the extra numeric fields sent by the network of the post
+}
+
+// newTopPost returns the TopPost describing a post, with the values of the
+// built-in dimensions and of the given ones.
+func newTopPost(post sseclient.Post, dimensions []dimension.Dimension) TopPost {
+	metrics := make(map[types.Dimension]float64, len(dimension.Builtins)+len(dimensions))
+	for _, d := range slices.Concat(dimension.Builtins, dimensions) {
+		if value, ok := d.Extract(post); ok {
+			metrics[d.Name] = value
+		}
+	}
+	url := post.Data.URL
+	if url == "" {
+		url = post.Data.Link
+	}
+	return TopPost{
+		Type:         post.Type,
+		ID:           post.Data.ID,
+		Author:       post.Data.Author,
+		URL:          url,
+		Timestamp:    post.Data.Timestamp,
+		Metrics:      metrics,
+		ExtraMetrics: post.Data.ExtraNumbers(),
+	}
+}
+
+// topPosts keeps the n posts with the largest values added so far, in a
+// min-heap whose root is the smallest of them, so that memory is bounded by n
+// however many posts are added. Among posts with the same value, the first
+// ones added are kept.
+type topPosts struct {
+	n          int
+	dimensions []dimension.Dimension // dimensions are reported for each post, along with the built-in ones.
+	added      int                   // added is the number of posts added so far, which orders posts with the same value.
+	entries    []topEntry
+}
+
+// topEntry is a post kept by topPosts.
+type topEntry struct {
+	value float64
+	seq   int // seq is the rank of the post among the posts added.
+	post  sseclient.Post
+}
+
+// newTopPosts creates a topPosts keeping the n posts with the largest values,
+// which reports the given dimensions of each post.
+func newTopPosts(n int, dimensions []dimension.Dimension) *topPosts {
+	return &topPosts{n: n, dimensions: dimensions, entries: make([]topEntry, 0, n)}
+}
+
+// add adds a post with the given value, replacing the smallest post kept if
+// the new one is larger and n posts are already kept.
+func (t *topPosts) add(value float64, post sseclient.Post) {
+	t.added++
+	entry := topEntry{value: value, seq: t.added, post: post}
+	if len(t.entries) < t.n {
+		heap.Push(t, entry)
+		return
+	}
+	if !t.less(t.entries[0], entry) {
+		return
+	}
+	t.entries[0] = entry
+	heap.Fix(t, 0)
+}
+
+// result returns the posts kept, from the largest value to the smallest.
+func (t *topPosts) result() []TopPost {
+	entries := slices.Clone(t.entries)
+	slices.SortFunc(entries, func(a, b topEntry) int {
+		switch {
+		case t.less(b, a):
+			return -1
+		case t.less(a, b):
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	posts := make([]TopPost, len(entries))
+	for i, entry := range entries {
+		posts[i] = newTopPost(entry.post, t.dimensions)
+	}
+	return posts
+}
+
+// less reports whether a ranks below b: it has a smaller value, or the same
+// value and was added later.
+func (t *topPosts) less(a, b topEntry) bool {
+	if a.value != b.value {
+		return a.value < b.value
+	}
+	return a.seq > b.seq
+}
+
+// Len, Less, Swap, Push and Pop implement heap.Interface.
+
+func (t *topPosts) Len() int           { return len(t.entries) }
+func (t *topPosts) Less(i, j int) bool { return t.less(t.entries[i], t.entries[j]) }
+func (t *topPosts) Swap(i, j int)      { t.entries[i], t.entries[j] = t.entries[j], t.entries[i] }
+func (t *topPosts) Push(x any)         { t.entries = append(t.entries, x.(topEntry)) }
+func (t *topPosts) Pop() any {
+	last := t.entries[len(t.entries)-1]
+	t.entries = t.entries[:len(t.entries)-1]
+	return last
+}
@@ -0,0 +1,550 @@
+// Package config loads and validates the configuration of the server binary.
+//
+// Every setting can be given, from lowest to highest precedence, by its default
+// value, a JSON configuration file, an environment variable, or a command-line
+// flag. The configuration file is selected with the -config flag or the
+// UPFCC_CONFIG environment variable.
+package config
+
+import (
+	"upfcc/internal/dimension"
+	"upfcc/internal/types"
+
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that is read from JSON as a string such as "30s".
+type Duration time.Duration
+
+// UnmarshalJSON decodes a duration from a string accepted by time.ParseDuration.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON encodes a duration as a string such as "30s".
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// TLSConfig holds the TLS settings of the server. TLS is enabled when both
+// the certificate and the key files are set.
+type TLSConfig struct {
+	CertFile   string `json:"cert_file"`   // CertFile is the path of the PEM encoded certificate chain.
+	KeyFile    string `json:"key_file"`    // KeyFile is the path of the PEM encoded private key.
+	MinVersion string `json:"min_version"` // MinVersion is the minimum TLS version accepted, "1.2" or "1.3".
+}
+
+// Enabled reports whether the server should serve HTTPS.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// Version returns the tls package constant of the minimum TLS version.
+func (t TLSConfig) Version() uint16 {
+	if t.MinVersion == "1.3" {
+		return tls.VersionTLS13
+	}
+	return tls.VersionTLS12
+}
+
+// DimensionConfig declares a dimension analyzed in addition to the built-in ones,
+// read from a field of the posts or derived from other dimensions by a formula.
+// Dimensions can only be declared in the configuration file.
+type DimensionConfig struct {
+	Name      string   `json:"name"`       // Name identifies the dimension in requests and results.
+	Field     string   `json:"field"`      // Field is the numeric field of the post payload, the name by default.
+	Formula   string   `json:"formula"`    // Formula derives the dimension from the dimensions declared before it, instead of a field.
+	PostTypes []string `json:"post_types"` // PostTypes are the post types supporting the dimension, all of them by default.
+}
+
+// Config holds the configuration of the server binary.
+type Config struct {
+	ListenAddr          string    `json:"listen_addr"`           // ListenAddr is the TCP address the server listens on.
+	UpstreamURLs        []string  `json:"upstream_urls"`         // UpstreamURLs are the SSE streams to read, the first one being preferred.
+	ReadHeaderTimeout   Duration  `json:"read_header_timeout"`   // ReadHeaderTimeout bounds the time to read request headers.
+	ReadTimeout         Duration  `json:"read_timeout"`          // ReadTimeout bounds the time to read a whole request, 0 for none.
+	WriteTimeout        Duration  `json:"write_timeout"`         // WriteTimeout bounds the time to write a response, 0 for none.
+	IdleTimeout         Duration  `json:"idle_timeout"`          // IdleTimeout bounds the time a keep-alive connection stays idle.
+	ShutdownGracePeriod Duration  `json:"shutdown_grace_period"` // ShutdownGracePeriod is the time given to in-flight analyses on shutdown.
+	MinDuration         Duration  `json:"min_duration"`          // MinDuration is the shortest analysis duration accepted.
+	MaxDuration         Duration  `json:"max_duration"`          // MaxDuration is the longest analysis duration accepted, 0 for no limit.
+	ReadinessWindow     Duration  `json:"readiness_window"`      // ReadinessWindow is the longest time without upstream events before /readyz fails.
+	RecordFile          string    `json:"record_file"`           // RecordFile is the recording the upstream events are appended to, empty for none.
+	ReplayFile          string    `json:"replay_file"`           // ReplayFile is a recording read instead of the upstream stream, empty for none.
+	ReplaySpeed         float64   `json:"replay_speed"`          // ReplaySpeed is the speed of the replay, 1 for real time and 0 for instant.
+	HistoryRetention    Duration  `json:"history_retention"`     // HistoryRetention is how long posts are kept for historical analyses, 0 (the default) to disable them.
+	HistoryMaxPosts     int       `json:"history_max_posts"`     // HistoryMaxPosts is the maximum number of posts kept for historical analyses, 0 for no limit.
+	HistoryFile         string    `json:"history_file"`          // HistoryFile is the file the history is persisted to, empty to keep it in memory only.
+	MaxJobs             int       `json:"max_jobs"`              // MaxJobs is the maximum number of analysis jobs tracked, running or finished.
+	JobRetention        Duration  `json:"job_retention"`         // JobRetention is how long finished analysis jobs and their results are kept.
+	CallbackSecret      string    `json:"callback_secret"`       // CallbackSecret is the key signing the deliveries to callback URLs, empty to disable callbacks.
+	CallbackMaxAttempts int       `json:"callback_max_attempts"` // CallbackMaxAttempts is the number of attempts before a delivery is given up.
+	CallbackBackoff     Duration  `json:"callback_backoff"`      // CallbackBackoff is the delay before the first retry of a delivery, doubled after every retry.
+	CallbackHosts       []string  `json:"callback_hosts"`        // CallbackHosts are the only hosts callbacks are delivered to, any host with a public address if empty.
+	RateLimit           float64   `json:"rate_limit"`            // RateLimit is the number of analysis requests per second of each client, 0 for no limit.
+	RateLimitBurst      int       `json:"rate_limit_burst"`      // RateLimitBurst is the number of analysis requests a client can make at once.
+	MaxAnalyses         int       `json:"max_analyses"`          // MaxAnalyses is the maximum number of analyses running at once, 0 for no limit.
+	APIKeys             []string  `json:"api_keys"`              // APIKeys are the API keys identifying clients for rate limiting, other keys being ignored.
+	TrustedProxies      []string  `json:"trusted_proxies"`       // TrustedProxies are the addresses or CIDR ranges of the proxies whose X-Forwarded-For header is trusted.
+	LogLevel            string    `json:"log_level"`             // LogLevel is one of "debug", "info", "warn" or "error".
+	TLS                 TLSConfig `json:"tls"`                   // TLS holds the TLS settings of the server.
+
+	Dimensions []DimensionConfig `json:"dimensions"` // Dimensions are the dimensions analyzed in addition to the built-in ones.
+}
+
+// Default returns the configuration used when no setting is given.
+func Default() Config {
+	return Config{
+		ListenAddr:          ":8080",
+		UpstreamURLs:        []string{"https://stream.upfluence.co/stream"},
+		ReadHeaderTimeout:   Duration(10 * time.Second),
+		IdleTimeout:         Duration(2 * time.Minute),
+		ShutdownGracePeriod: Duration(30 * time.Second),
+		MinDuration:         Duration(time.Second),
+		MaxDuration:         Duration(time.Hour),
+		ReadinessWindow:     Duration(30 * time.Second),
+		ReplaySpeed:         1,
+		HistoryMaxPosts:     1000000,
+		MaxJobs:             100,
+		JobRetention:        Duration(time.Hour),
+		CallbackMaxAttempts: 5,
+		CallbackBackoff:     Duration(time.Second),
+		RateLimitBurst:      10,
+		LogLevel:            "info",
+		TLS:                 TLSConfig{MinVersion: "1.2"},
+	}
+}
+
+// setting describes a configuration setting that can be set by a flag and an
+// environment variable.
+type setting struct {
+	flag  string                                // flag is the name of the command-line flag.
+	env   string                                // env is the name of the environment variable.
+	usage string                                // usage is the help text of the flag.
+	set   func(cfg *Config, value string) error // set parses the value into the configuration.
+}
+
+// settings lists the settings that can be set by flags and environment variables.
+var settings = []setting{
+	{"listen-addr", "UPFCC_LISTEN_ADDR", "TCP address to listen on", func(cfg *Config, v string) error {
+		cfg.ListenAddr = v
+		return nil
+	}},
+	{"upstream-urls", "UPFCC_UPSTREAM_URLS", "comma-separated SSE stream URLs, the first one being preferred", func(cfg *Config, v string) error {
+		cfg.UpstreamURLs = splitList(v)
+		return nil
+	}},
+	{"read-header-timeout", "UPFCC_READ_HEADER_TIMEOUT", "maximum time to read request headers", durationSetter(func(cfg *Config) *Duration { return &cfg.ReadHeaderTimeout })},
+	{"read-timeout", "UPFCC_READ_TIMEOUT", "maximum time to read a request, 0 for none", durationSetter(func(cfg *Config) *Duration { return &cfg.ReadTimeout })},
+	{"write-timeout", "UPFCC_WRITE_TIMEOUT", "maximum time to write a response, 0 for none", durationSetter(func(cfg *Config) *Duration { return &cfg.WriteTimeout })},
+	{"idle-timeout", "UPFCC_IDLE_TIMEOUT", "maximum time a keep-alive connection stays idle", durationSetter(func(cfg *Config) *Duration { return &cfg.IdleTimeout })},
+	{"shutdown-grace-period", "UPFCC_SHUTDOWN_GRACE_PERIOD", "time given to in-flight analyses to finish on shutdown", durationSetter(func(cfg *Config) *Duration { return &cfg.ShutdownGracePeriod })},
+	{"min-duration", "UPFCC_MIN_DURATION", "shortest analysis duration accepted", durationSetter(func(cfg *Config) *Duration { return &cfg.MinDuration })},
+	{"max-duration", "UPFCC_MAX_DURATION", "longest analysis duration accepted, 0 for no limit", durationSetter(func(cfg *Config) *Duration { return &cfg.MaxDuration })},
+	{"readiness-window", "UPFCC_READINESS_WINDOW", "longest time without upstream events before the service is not ready", durationSetter(func(cfg *Config) *Duration { return &cfg.ReadinessWindow })},
+	{"record-file", "UPFCC_RECORD_FILE", "file the upstream events are recorded to", func(cfg *Config, v string) error {
+		cfg.RecordFile = v
+		return nil
+	}},
+	{"replay-file", "UPFCC_REPLAY_FILE", "recording to replay instead of reading the upstream stream", func(cfg *Config, v string) error {
+		cfg.ReplayFile = v
+		return nil
+	}},
+	{"replay-speed", "UPFCC_REPLAY_SPEED", "replay speed: 1 for real time, 10 for ten times faster, 0 for instant", func(cfg *Config, v string) error {
+		speed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		cfg.ReplaySpeed = speed
+		return nil
+	}},
+	{"history-retention", "UPFCC_HISTORY_RETENTION", "how long posts are kept for historical analyses, such as 1h, 0 (the default) to disable them", durationSetter(func(cfg *Config) *Duration { return &cfg.HistoryRetention })},
+	{"history-max-posts", "UPFCC_HISTORY_MAX_POSTS", "maximum number of posts kept for historical analyses, 0 for no limit", func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		cfg.HistoryMaxPosts = n
+		return nil
+	}},
+	{"history-file", "UPFCC_HISTORY_FILE", "file the history is persisted to across restarts", func(cfg *Config, v string) error {
+		cfg.HistoryFile = v
+		return nil
+	}},
+	{"max-jobs", "UPFCC_MAX_JOBS", "maximum number of analysis jobs tracked, running or finished", func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		cfg.MaxJobs = n
+		return nil
+	}},
+	{"job-retention", "UPFCC_JOB_RETENTION", "how long finished analysis jobs and their results are kept", durationSetter(func(cfg *Config) *Duration { return &cfg.JobRetention })},
+	{"callback-secret", "UPFCC_CALLBACK_SECRET", "key signing the deliveries to callback URLs, enables callbacks", func(cfg *Config, v string) error {
+		cfg.CallbackSecret = v
+		return nil
+	}},
+	{"callback-max-attempts", "UPFCC_CALLBACK_MAX_ATTEMPTS", "number of attempts before a delivery to a callback URL is given up", func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		cfg.CallbackMaxAttempts = n
+		return nil
+	}},
+	{"callback-backoff", "UPFCC_CALLBACK_BACKOFF", "delay before the first retry of a delivery, doubled after every retry", durationSetter(func(cfg *Config) *Duration { return &cfg.CallbackBackoff })},
+	{"callback-hosts", "UPFCC_CALLBACK_HOSTS", "comma-separated hosts callbacks are delivered to, any host with a public address if empty", func(cfg *Config, v string) error {
+		cfg.CallbackHosts = splitList(v)
+		return nil
+	}},
+	{"rate-limit", "UPFCC_RATE_LIMIT", "analysis requests per second of each client IP or known API key, 0 for no limit", func(cfg *Config, v string) error {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		cfg.RateLimit = rate
+		return nil
+	}},
+	{"rate-limit-burst", "UPFCC_RATE_LIMIT_BURST", "analysis requests a client can make at once", func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		cfg.RateLimitBurst = n
+		return nil
+	}},
+	{"max-analyses", "UPFCC_MAX_ANALYSES", "maximum number of analyses running at once, 0 for no limit", func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		cfg.MaxAnalyses = n
+		return nil
+	}},
+	{"api-keys", "UPFCC_API_KEYS", "comma-separated API keys identifying clients for rate limiting, other keys being ignored", func(cfg *Config, v string) error {
+		cfg.APIKeys = splitList(v)
+		return nil
+	}},
+	{"trusted-proxies", "UPFCC_TRUSTED_PROXIES", "comma-separated addresses or CIDR ranges of the proxies whose X-Forwarded-For header identifies clients for rate limiting", func(cfg *Config, v string) error {
+		cfg.TrustedProxies = splitList(v)
+		return nil
+	}},
+	{"log-level", "UPFCC_LOG_LEVEL", "log level: debug, info, warn or error", func(cfg *Config, v string) error {
+		cfg.LogLevel = v
+		return nil
+	}},
+	{"tls-cert-file", "UPFCC_TLS_CERT_FILE", "PEM certificate file, enables HTTPS with -tls-key-file", func(cfg *Config, v string) error {
+		cfg.TLS.CertFile = v
+		return nil
+	}},
+	{"tls-key-file", "UPFCC_TLS_KEY_FILE", "PEM private key file, enables HTTPS with -tls-cert-file", func(cfg *Config, v string) error {
+		cfg.TLS.KeyFile = v
+		return nil
+	}},
+	{"tls-min-version", "UPFCC_TLS_MIN_VERSION", "minimum TLS version: 1.2 or 1.3", func(cfg *Config, v string) error {
+		cfg.TLS.MinVersion = v
+		return nil
+	}},
+}
+
+// durationSetter returns a setter that parses a duration into the field returned by field.
+func durationSetter(field func(cfg *Config) *Duration) func(cfg *Config, value string) error {
+	return func(cfg *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = Duration(d)
+		return nil
+	}
+}
+
+// Load builds the configuration from the defaults, the configuration file, the
+// environment and the command-line arguments, in increasing order of
+// precedence, and validates it.
+//
+// Parameters:
+//   - args: The command-line arguments, without the program name.
+//   - getenv: A function returning the value of an environment variable, such as os.Getenv.
+//
+// Returns:
+//   - The validated configuration.
+//   - An error if a setting cannot be parsed or is invalid, or flag.ErrHelp if -h was given.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", getenv("UPFCC_CONFIG"), "path of a JSON configuration file (env UPFCC_CONFIG)")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.flag] = fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := Default()
+	if *configFile != "" {
+		if err := loadFile(&cfg, *configFile); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(&cfg, value); err != nil {
+				return Config{}, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(&cfg, *flagValues[s.flag]); err != nil {
+					flagErr = fmt.Errorf("invalid -%s: %w", s.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return Config{}, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadFile reads the JSON configuration file at path into cfg. Settings that
+// are missing from the file keep their current value, and unknown settings
+// are rejected to catch typos.
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate verifies that the configuration is usable, and returns an error
+// describing every invalid setting otherwise.
+func (cfg Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(cfg.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("listen_addr %q: %w", cfg.ListenAddr, err))
+	}
+
+	if len(cfg.UpstreamURLs) == 0 {
+		errs = append(errs, errors.New("upstream_urls: at least one URL is required"))
+	}
+	for _, upstream := range cfg.UpstreamURLs {
+		u, err := url.Parse(upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("upstream_urls: %q is not an absolute http(s) URL", upstream))
+		}
+	}
+
+	for _, timeout := range []struct {
+		name  string
+		value Duration
+	}{
+		{"read_header_timeout", cfg.ReadHeaderTimeout},
+		{"read_timeout", cfg.ReadTimeout},
+		{"write_timeout", cfg.WriteTimeout},
+		{"idle_timeout", cfg.IdleTimeout},
+		{"shutdown_grace_period", cfg.ShutdownGracePeriod},
+	} {
+		if timeout.value < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative", timeout.name))
+		}
+	}
+	if cfg.MinDuration <= 0 {
+		errs = append(errs, errors.New("min_duration: must be positive"))
+	}
+	if cfg.MaxDuration < 0 {
+		errs = append(errs, errors.New("max_duration: must not be negative"))
+	}
+	if cfg.MaxDuration > 0 && cfg.MinDuration > cfg.MaxDuration {
+		errs = append(errs, fmt.Errorf("min_duration: %v exceeds max_duration %v",
+			time.Duration(cfg.MinDuration), time.Duration(cfg.MaxDuration)))
+	}
+	if cfg.ReadinessWindow <= 0 {
+		errs = append(errs, errors.New("readiness_window: must be positive"))
+	}
+	if cfg.WriteTimeout > 0 && cfg.MaxDuration == 0 {
+		errs = append(errs, fmt.Errorf("write_timeout: %v would cut off analyses, whose duration max_duration 0 does not limit, use 0",
+			time.Duration(cfg.WriteTimeout)))
+	}
+	if cfg.WriteTimeout > 0 && cfg.WriteTimeout <= cfg.MaxDuration {
+		errs = append(errs, fmt.Errorf("write_timeout: %v would cut off analyses of up to max_duration %v, use 0 or a longer timeout",
+			time.Duration(cfg.WriteTimeout), time.Duration(cfg.MaxDuration)))
+	}
+
+	if cfg.RecordFile != "" && cfg.ReplayFile != "" {
+		errs = append(errs, errors.New("record_file: cannot record while replaying replay_file"))
+	}
+	if cfg.ReplayFile != "" {
+		if _, err := os.Stat(cfg.ReplayFile); err != nil {
+			errs = append(errs, fmt.Errorf("replay_file: %w", err))
+		}
+	}
+	if cfg.ReplaySpeed < 0 {
+		errs = append(errs, errors.New("replay_speed: must not be negative"))
+	}
+	if cfg.HistoryRetention < 0 {
+		errs = append(errs, errors.New("history_retention: must not be negative"))
+	}
+	if cfg.HistoryMaxPosts < 0 {
+		errs = append(errs, errors.New("history_max_posts: must not be negative"))
+	}
+	if cfg.MaxJobs <= 0 {
+		errs = append(errs, errors.New("max_jobs: must be positive"))
+	}
+	if cfg.JobRetention <= 0 {
+		errs = append(errs, errors.New("job_retention: must be positive"))
+	}
+	if cfg.CallbackMaxAttempts <= 0 {
+		errs = append(errs, errors.New("callback_max_attempts: must be positive"))
+	}
+	if cfg.CallbackBackoff <= 0 {
+		errs = append(errs, errors.New("callback_backoff: must be positive"))
+	}
+	if cfg.RateLimit < 0 {
+		errs = append(errs, errors.New("rate_limit: must not be negative"))
+	}
+	if cfg.RateLimit > 0 && cfg.RateLimitBurst < 1 {
+		errs = append(errs, errors.New("rate_limit_burst: must be positive"))
+	}
+	if cfg.MaxAnalyses < 0 {
+		errs = append(errs, errors.New("max_analyses: must not be negative"))
+	}
+
+	if _, err := cfg.TrustedProxyPrefixes(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := cfg.SlogLevel(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := cfg.DimensionRegistry(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls: cert_file and key_file must be set together"))
+	}
+	for _, file := range []string{cfg.TLS.CertFile, cfg.TLS.KeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			errs = append(errs, fmt.Errorf("tls: %w", err))
+		}
+	}
+	if cfg.TLS.MinVersion != "1.2" && cfg.TLS.MinVersion != "1.3" {
+		errs = append(errs, fmt.Errorf("tls: min_version %q must be 1.2 or 1.3", cfg.TLS.MinVersion))
+	}
+
+	return errors.Join(errs...)
+}
+
+// SlogLevel returns the slog level matching the configured log level.
+func (cfg Config) SlogLevel() (slog.Level, error) {
+	switch strings.ToLower(cfg.LogLevel) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("log_level: %q must be debug, info, warn or error", cfg.LogLevel)
+	}
+}
+
+// TrustedProxyPrefixes returns the trusted proxies as prefixes, a single address
+// being a prefix of its full length.
+func (cfg Config) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cfg.TrustedProxies))
+	for _, proxy := range cfg.TrustedProxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_proxies: %q is not an address or a CIDR range", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// DimensionRegistry returns the registry of the built-in dimensions and the
+// dimensions declared in the configuration.
+func (cfg Config) DimensionRegistry() (*dimension.Registry, error) {
+	registry := dimension.Default()
+	for _, d := range cfg.Dimensions {
+		dim := dimension.FromField(types.Dimension(d.Name), d.Field, d.PostTypes...)
+		switch {
+		case d.Formula == "" && d.Field == "":
+			dim.Field = d.Name
+		case d.Formula != "" && (d.Field != "" || d.PostTypes != nil):
+			return nil, fmt.Errorf("dimensions: dimension %q: field and post_types cannot be set with formula", d.Name)
+		case d.Formula != "":
+			formula, err := dimension.ParseFormula(d.Formula, registry)
+			if err != nil {
+				return nil, fmt.Errorf("dimensions: dimension %q: formula %w", d.Name, err)
+			}
+			dim = dimension.FromFormula(types.Dimension(d.Name), formula)
+		}
+		if err := registry.Register(dim); err != nil {
+			return nil, fmt.Errorf("dimensions: %w", err)
+		}
+	}
+	return registry, nil
+}
+
+// splitList splits a comma-separated list, ignoring empty items and spaces.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,485 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.json")
+	writeFile(t, configFile, `{
+		"listen_addr": ":9000",
+		"upstream_urls": ["http://file.example/stream"],
+		"max_duration": "2h",
+		"log_level": "debug",
+		"dimensions": [
+			{"name": "views", "post_types": ["youtube_video", "tiktok_video"]},
+			{"name": "view_rate", "formula": "interactions / views"}
+		]
+	}`)
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		check   func(t *testing.T, cfg Config)
+		wantErr string
+	}{
+		{
+			name: "Defaults",
+			check: func(t *testing.T, cfg Config) {
+				if cfg.ListenAddr != ":8080" {
+					t.Errorf("ListenAddr = %q, want :8080", cfg.ListenAddr)
+				}
+				if !slices.Equal(cfg.UpstreamURLs, []string{"https://stream.upfluence.co/stream"}) {
+					t.Errorf("UpstreamURLs = %v, want the Upfluence stream", cfg.UpstreamURLs)
+				}
+				if cfg.MaxDuration != Duration(time.Hour) {
+					t.Errorf("MaxDuration = %v, want 1h", time.Duration(cfg.MaxDuration))
+				}
+			},
+		},
+		{
+			name: "ConfigFile",
+			args: []string{"-config", configFile},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.ListenAddr != ":9000" || cfg.MaxDuration != Duration(2*time.Hour) || cfg.LogLevel != "debug" {
+					t.Errorf("config file settings were not applied: %+v", cfg)
+				}
+				if cfg.ShutdownGracePeriod != Duration(30*time.Second) {
+					t.Errorf("ShutdownGracePeriod = %v, want the default 30s", time.Duration(cfg.ShutdownGracePeriod))
+				}
+				registry, err := cfg.DimensionRegistry()
+				if err != nil {
+					t.Fatalf("DimensionRegistry() error = %v", err)
+				}
+				views, ok := registry.Lookup("views")
+				if !ok || views.Field != "views" || !views.Supports("tiktok_video") || views.Supports("tweet") {
+					t.Errorf("DimensionRegistry() views = %+v, %v, want the configured dimension", views, ok)
+				}
+				viewRate, ok := registry.Lookup("view_rate")
+				if !ok || viewRate.Formula.String() != "interactions / views" || !slices.Equal(viewRate.PostTypes, views.PostTypes) {
+					t.Errorf("DimensionRegistry() view_rate = %+v, %v, want the configured formula on videos", viewRate, ok)
+				}
+				if _, ok := registry.Lookup("likes"); !ok {
+					t.Error("DimensionRegistry() does not hold the built-in likes dimension")
+				}
+			},
+		},
+		{
+			name: "ConfigFileFromEnv",
+			env:  map[string]string{"UPFCC_CONFIG": configFile},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.ListenAddr != ":9000" {
+					t.Errorf("ListenAddr = %q, want :9000 from the config file", cfg.ListenAddr)
+				}
+			},
+		},
+		{
+			name: "EnvOverridesFile",
+			args: []string{"-config", configFile},
+			env: map[string]string{
+				"UPFCC_LISTEN_ADDR":   ":9100",
+				"UPFCC_UPSTREAM_URLS": "http://a.example/stream, http://b.example/stream",
+			},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.ListenAddr != ":9100" {
+					t.Errorf("ListenAddr = %q, want :9100 from the environment", cfg.ListenAddr)
+				}
+				if !slices.Equal(cfg.UpstreamURLs, []string{"http://a.example/stream", "http://b.example/stream"}) {
+					t.Errorf("UpstreamURLs = %v, want the URLs of the environment", cfg.UpstreamURLs)
+				}
+				if cfg.MaxDuration != Duration(2*time.Hour) {
+					t.Errorf("MaxDuration = %v, want 2h from the config file", time.Duration(cfg.MaxDuration))
+				}
+			},
+		},
+		{
+			name: "FlagsOverrideEnv",
+			args: []string{"-config", configFile, "-listen-addr", ":9200", "-max-duration", "10m", "-readiness-window", "1m"},
+			env:  map[string]string{"UPFCC_LISTEN_ADDR": ":9100", "UPFCC_READINESS_WINDOW": "10s"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.ListenAddr != ":9200" || cfg.MaxDuration != Duration(10*time.Minute) || cfg.ReadinessWindow != Duration(time.Minute) {
+					t.Errorf("flag settings were not applied: %+v", cfg)
+				}
+			},
+		},
+		{
+			name:    "Help",
+			args:    []string{"-h"},
+			wantErr: flag.ErrHelp.Error(),
+		},
+		{
+			name:    "MissingConfigFile",
+			args:    []string{"-config", filepath.Join(dir, "missing.json")},
+			wantErr: "config file",
+		},
+		{
+			name:    "InvalidEnvDuration",
+			env:     map[string]string{"UPFCC_MAX_DURATION": "forever"},
+			wantErr: "invalid UPFCC_MAX_DURATION",
+		},
+		{
+			name: "Replay",
+			args: []string{"-replay-file", configFile, "-replay-speed", "0"},
+			env:  map[string]string{"UPFCC_REPLAY_SPEED": "10"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.ReplayFile != configFile || cfg.ReplaySpeed != 0 {
+					t.Errorf("replay settings were not applied: %+v", cfg)
+				}
+			},
+		},
+		{
+			name:    "InvalidReplaySpeed",
+			env:     map[string]string{"UPFCC_REPLAY_SPEED": "fast"},
+			wantErr: "invalid UPFCC_REPLAY_SPEED",
+		},
+		{
+			name: "History",
+			args: []string{"-history-retention", "2h", "-history-file", "posts.store"},
+			env:  map[string]string{"UPFCC_HISTORY_MAX_POSTS": "500"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.HistoryRetention != Duration(2*time.Hour) || cfg.HistoryMaxPosts != 500 || cfg.HistoryFile != "posts.store" {
+					t.Errorf("history settings were not applied: %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "Jobs",
+			args: []string{"-job-retention", "10m"},
+			env:  map[string]string{"UPFCC_MAX_JOBS": "20"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.MaxJobs != 20 || cfg.JobRetention != Duration(10*time.Minute) {
+					t.Errorf("job settings were not applied: %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "MinDuration",
+			env:  map[string]string{"UPFCC_MIN_DURATION": "5s"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.MinDuration != Duration(5*time.Second) {
+					t.Errorf("MinDuration = %v, want 5s", time.Duration(cfg.MinDuration))
+				}
+			},
+		},
+		{
+			name: "Callbacks",
+			args: []string{"-callback-secret", "s3cret", "-callback-backoff", "5s"},
+			env:  map[string]string{"UPFCC_CALLBACK_MAX_ATTEMPTS": "3", "UPFCC_CALLBACK_HOSTS": "hooks.example.com, 10.0.0.1"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.CallbackSecret != "s3cret" || cfg.CallbackMaxAttempts != 3 || cfg.CallbackBackoff != Duration(5*time.Second) ||
+					!slices.Equal(cfg.CallbackHosts, []string{"hooks.example.com", "10.0.0.1"}) {
+					t.Errorf("callback settings were not applied: %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "RateLimit",
+			args: []string{"-rate-limit", "0.5", "-max-analyses", "20"},
+			env:  map[string]string{"UPFCC_RATE_LIMIT_BURST": "3", "UPFCC_API_KEYS": "key-1,key-2", "UPFCC_TRUSTED_PROXIES": "10.0.0.0/8, 192.0.2.1"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.RateLimit != 0.5 || cfg.RateLimitBurst != 3 || cfg.MaxAnalyses != 20 || !slices.Equal(cfg.APIKeys, []string{"key-1", "key-2"}) ||
+					!slices.Equal(cfg.TrustedProxies, []string{"10.0.0.0/8", "192.0.2.1"}) {
+					t.Errorf("rate limit settings were not applied: %+v", cfg)
+				}
+			},
+		},
+		{
+			name:    "InvalidRateLimit",
+			env:     map[string]string{"UPFCC_RATE_LIMIT": "fast"},
+			wantErr: "invalid UPFCC_RATE_LIMIT",
+		},
+		{
+			name:    "InvalidCallbackMaxAttempts",
+			env:     map[string]string{"UPFCC_CALLBACK_MAX_ATTEMPTS": "many"},
+			wantErr: "invalid UPFCC_CALLBACK_MAX_ATTEMPTS",
+		},
+		{
+			name:    "InvalidMaxJobs",
+			env:     map[string]string{"UPFCC_MAX_JOBS": "many"},
+			wantErr: "invalid UPFCC_MAX_JOBS",
+		},
+		{
+			name:    "InvalidHistoryMaxPosts",
+			env:     map[string]string{"UPFCC_HISTORY_MAX_POSTS": "many"},
+			wantErr: "invalid UPFCC_HISTORY_MAX_POSTS",
+		},
+		{
+			name:    "InvalidFlagDuration",
+			args:    []string{"-read-timeout", "soon"},
+			wantErr: "invalid -read-timeout",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(key string) string { return tt.env[key] }
+			cfg, err := Load(tt.args, getenv)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "UnknownSetting",
+			content: `{"listen_adr": ":9000"}`,
+			wantErr: "unknown field",
+		},
+		{
+			name:    "InvalidDuration",
+			content: `{"max_duration": 30}`,
+			wantErr: "duration must be a string",
+		},
+		{
+			name:    "InvalidJSON",
+			content: `{`,
+			wantErr: "config file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "config.json")
+			writeFile(t, configFile, tt.content)
+
+			_, err := Load([]string{"-config", configFile}, func(string) string { return "" })
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	writeFile(t, certFile, "")
+
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr string
+	}{
+		{
+			name:   "Valid",
+			modify: func(cfg *Config) {},
+		},
+		{
+			name:    "ListenAddrWithoutPort",
+			modify:  func(cfg *Config) { cfg.ListenAddr = "localhost" },
+			wantErr: "listen_addr",
+		},
+		{
+			name:    "NoUpstreamURL",
+			modify:  func(cfg *Config) { cfg.UpstreamURLs = nil },
+			wantErr: "at least one URL",
+		},
+		{
+			name:    "RelativeUpstreamURL",
+			modify:  func(cfg *Config) { cfg.UpstreamURLs = []string{"/stream"} },
+			wantErr: "not an absolute http(s) URL",
+		},
+		{
+			name:    "NegativeTimeout",
+			modify:  func(cfg *Config) { cfg.IdleTimeout = Duration(-time.Second) },
+			wantErr: "idle_timeout: must not be negative",
+		},
+		{
+			name:   "ZeroMaxDuration",
+			modify: func(cfg *Config) { cfg.MaxDuration = 0 },
+		},
+		{
+			name:    "NegativeMaxDuration",
+			modify:  func(cfg *Config) { cfg.MaxDuration = Duration(-time.Hour) },
+			wantErr: "max_duration: must not be negative",
+		},
+		{
+			name:    "WriteTimeoutWithoutMaxDuration",
+			modify:  func(cfg *Config) { cfg.MaxDuration, cfg.WriteTimeout = 0, Duration(2*time.Hour) },
+			wantErr: "write_timeout",
+		},
+		{
+			name:    "ZeroMinDuration",
+			modify:  func(cfg *Config) { cfg.MinDuration = 0 },
+			wantErr: "min_duration: must be positive",
+		},
+		{
+			name:    "MinDurationAboveMaxDuration",
+			modify:  func(cfg *Config) { cfg.MinDuration = Duration(2 * time.Hour) },
+			wantErr: "min_duration: 2h0m0s exceeds max_duration 1h0m0s",
+		},
+		{
+			name:    "ZeroReadinessWindow",
+			modify:  func(cfg *Config) { cfg.ReadinessWindow = 0 },
+			wantErr: "readiness_window: must be positive",
+		},
+		{
+			name:    "WriteTimeoutShorterThanMaxDuration",
+			modify:  func(cfg *Config) { cfg.WriteTimeout = Duration(time.Minute) },
+			wantErr: "write_timeout",
+		},
+		{
+			name: "RecordWhileReplaying",
+			modify: func(cfg *Config) {
+				cfg.RecordFile = certFile + ".rec"
+				cfg.ReplayFile = certFile
+			},
+			wantErr: "cannot record while replaying",
+		},
+		{
+			name:    "MissingReplayFile",
+			modify:  func(cfg *Config) { cfg.ReplayFile = certFile + ".missing" },
+			wantErr: "replay_file",
+		},
+		{
+			name:    "NegativeReplaySpeed",
+			modify:  func(cfg *Config) { cfg.ReplaySpeed = -1 },
+			wantErr: "replay_speed: must not be negative",
+		},
+		{
+			name:    "NegativeHistoryRetention",
+			modify:  func(cfg *Config) { cfg.HistoryRetention = Duration(-time.Hour) },
+			wantErr: "history_retention: must not be negative",
+		},
+		{
+			name:    "NegativeHistoryMaxPosts",
+			modify:  func(cfg *Config) { cfg.HistoryMaxPosts = -1 },
+			wantErr: "history_max_posts: must not be negative",
+		},
+		{
+			name:    "ZeroMaxJobs",
+			modify:  func(cfg *Config) { cfg.MaxJobs = 0 },
+			wantErr: "max_jobs: must be positive",
+		},
+		{
+			name:    "ZeroJobRetention",
+			modify:  func(cfg *Config) { cfg.JobRetention = 0 },
+			wantErr: "job_retention: must be positive",
+		},
+		{
+			name:    "ZeroCallbackMaxAttempts",
+			modify:  func(cfg *Config) { cfg.CallbackMaxAttempts = 0 },
+			wantErr: "callback_max_attempts: must be positive",
+		},
+		{
+			name:    "NegativeRateLimit",
+			modify:  func(cfg *Config) { cfg.RateLimit = -1 },
+			wantErr: "rate_limit: must not be negative",
+		},
+		{
+			name:    "ZeroRateLimitBurst",
+			modify:  func(cfg *Config) { cfg.RateLimit, cfg.RateLimitBurst = 1, 0 },
+			wantErr: "rate_limit_burst: must be positive",
+		},
+		{
+			name:    "NegativeMaxAnalyses",
+			modify:  func(cfg *Config) { cfg.MaxAnalyses = -1 },
+			wantErr: "max_analyses: must not be negative",
+		},
+		{
+			name:    "InvalidTrustedProxy",
+			modify:  func(cfg *Config) { cfg.TrustedProxies = []string{"10.0.0.0/8", "proxy.internal"} },
+			wantErr: `trusted_proxies: "proxy.internal" is not an address or a CIDR range`,
+		},
+		{
+			name:    "NegativeCallbackBackoff",
+			modify:  func(cfg *Config) { cfg.CallbackBackoff = Duration(-time.Second) },
+			wantErr: "callback_backoff: must be positive",
+		},
+		{
+			name:    "DuplicateDimension",
+			modify:  func(cfg *Config) { cfg.Dimensions = []DimensionConfig{{Name: "likes"}} },
+			wantErr: `dimensions: dimension "likes": already registered`,
+		},
+		{
+			name:    "InvalidDimensionName",
+			modify:  func(cfg *Config) { cfg.Dimensions = []DimensionConfig{{Name: "Play Count", Field: "plays"}} },
+			wantErr: `dimensions: dimension "Play Count": name must be`,
+		},
+		{
+			name:    "InvalidDimensionFormula",
+			modify:  func(cfg *Config) { cfg.Dimensions = []DimensionConfig{{Name: "rate", Formula: "likes / plays"}} },
+			wantErr: `dimensions: dimension "rate": formula at position 9: unknown field "plays"`,
+		},
+		{
+			name: "DimensionFormulaWithField",
+			modify: func(cfg *Config) {
+				cfg.Dimensions = []DimensionConfig{{Name: "rate", Field: "views", Formula: "likes / 2"}}
+			},
+			wantErr: "field and post_types cannot be set with formula",
+		},
+		{
+			name:    "InvalidLogLevel",
+			modify:  func(cfg *Config) { cfg.LogLevel = "verbose" },
+			wantErr: "log_level",
+		},
+		{
+			name:    "CertWithoutKey",
+			modify:  func(cfg *Config) { cfg.TLS.CertFile = certFile },
+			wantErr: "must be set together",
+		},
+		{
+			name: "MissingKeyFile",
+			modify: func(cfg *Config) {
+				cfg.TLS.CertFile = certFile
+				cfg.TLS.KeyFile = certFile + ".missing"
+			},
+			wantErr: "no such file",
+		},
+		{
+			name:    "InvalidTLSVersion",
+			modify:  func(cfg *Config) { cfg.TLS.MinVersion = "1.0" },
+			wantErr: "min_version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+/////// Helpers
+
+// writeFile writes content to the file at path, failing the test on error.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
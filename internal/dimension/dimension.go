@@ -0,0 +1,203 @@
+// Package dimension provides the registry of the dimensions that can be
+// analyzed. A dimension is a metric of posts, such as likes or views, with the
+// post types that support it and the way to extract its value from a post.
+//
+// The built-in dimensions are read from the typed metrics of sseclient.SocialPost.
+// Other dimensions are read from any numeric field of the payload, so that the
+// metrics of new networks can be analyzed by registering them from the
+// configuration, without code changes. Derived dimensions, such as the total
+// interactions, are computed from other dimensions by a Formula.
+package dimension
+
+import (
+	"upfcc/internal/sseclient"
+	"upfcc/internal/types"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// Dimension is a metric of posts that can be analyzed.
+type Dimension struct {
+	Name      types.Dimension // Name identifies the dimension in requests and results.
+	Field     string          // Field is the JSON field of the post payload the value is read from, empty for derived dimensions.
+	Formula   *Formula        // Formula computes the value of derived dimensions, nil for the others.
+	PostTypes []string        // PostTypes are the post types supporting the dimension, nil for all of them.
+}
+
+// FromField returns the dimension with the given name whose value is read from
+// a numeric field of the post payload. Posts of other types than postTypes, if
+// any, do not support it.
+func FromField(name types.Dimension, field string, postTypes ...string) Dimension {
+	return Dimension{Name: name, Field: field, PostTypes: postTypes}
+}
+
+// FromFormula returns the derived dimension with the given name whose value is
+// computed by a formula. It is supported by the post types supporting every
+// dimension the formula reads.
+func FromFormula(name types.Dimension, formula *Formula) Dimension {
+	return Dimension{Name: name, Formula: formula, PostTypes: formula.postTypes()}
+}
+
+// Supports reports whether posts of the given type support the dimension.
+func (d Dimension) Supports(postType string) bool {
+	return d.PostTypes == nil || slices.Contains(d.PostTypes, postType)
+}
+
+// Extract returns the value of the dimension for a post. The second return
+// value is false if the post does not support the dimension, or if its formula
+// is undefined for the post, such as when it divides by zero. A post without
+// the field of a dimension that it supports has a value of 0.
+func (d Dimension) Extract(post sseclient.Post) (float64, bool) {
+	if !d.Supports(post.Type) {
+		return 0, false
+	}
+	if d.Formula != nil {
+		return d.Formula.Eval(post)
+	}
+	value, _ := post.Data.Number(d.Field)
+	return value, true
+}
+
+// Value returns the value of the dimension for a post, 0 if it is undefined.
+func (d Dimension) Value(post sseclient.Post) float64 {
+	value, _ := d.Extract(post)
+	return value
+}
+
+// MarshalJSON encodes the dimension as listed by the /dimensions endpoint.
+func (d Dimension) MarshalJSON() ([]byte, error) {
+	var formula string
+	if d.Formula != nil {
+		formula = d.Formula.String()
+	}
+	return json.Marshal(struct {
+		Name      types.Dimension `json:"name"`
+		Field     string          `json:"field,omitempty"`
+		Formula   string          `json:"formula,omitempty"`
+		PostTypes []string        `json:"post_types,omitempty"`
+	}{d.Name, d.Field, formula, d.PostTypes})
+}
+
+// The built-in dimensions, supported by every post type. Posts without the
+// metric count as zero, as networks omit the metrics that are zero.
+var (
+	Likes     = FromField(types.Likes, "likes")
+	Comments  = FromField(types.Comments, "comments")
+	Favorites = FromField(types.Favorites, "favorites")
+	Retweets  = FromField(types.Retweets, "retweets")
+)
+
+// Interactions is the built-in derived dimension counting every interaction with a post.
+var Interactions = FromFormula(types.Interactions, mustParseFormula("likes + comments + favorites + retweets", Likes, Comments, Favorites, Retweets))
+
+// Builtins lists the built-in dimensions, in the order they are reported.
+var Builtins = []Dimension{Likes, Comments, Favorites, Retweets, Interactions}
+
+// mustParseFormula parses the formula of a built-in dimension, reading the given
+// dimensions, and panics if it is invalid.
+func mustParseFormula(expr string, dimensions ...Dimension) *Formula {
+	registry, err := NewRegistry(dimensions...)
+	if err == nil {
+		var formula *Formula
+		if formula, err = ParseFormula(expr, registry); err == nil {
+			return formula
+		}
+	}
+	panic(fmt.Sprintf("dimension: built-in formula %q: %v", expr, err))
+}
+
+// ErrDuplicate is returned when a dimension is registered under a name already taken.
+var ErrDuplicate = errors.New("already registered")
+
+// validName matches the names accepted for dimensions.
+var validName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Registry holds the dimensions that can be analyzed, in the order they were
+// registered. It is filled at startup and only read afterwards, so it is not
+// safe to register dimensions while it is in use. Dimensions defined by a
+// single request are registered in a Clone.
+type Registry struct {
+	dimensions []Dimension
+	byName     map[types.Dimension]int // byName indexes dimensions by name.
+}
+
+// NewRegistry creates a Registry holding the given dimensions.
+//
+// Parameters:
+//   - dimensions: The dimensions to register, in the order they are listed.
+//
+// Returns:
+//   - The Registry.
+//   - An error if a dimension is invalid or registered twice.
+func NewRegistry(dimensions ...Dimension) (*Registry, error) {
+	r := &Registry{byName: make(map[types.Dimension]int)}
+	for _, d := range dimensions {
+		if err := r.Register(d); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Clone returns a copy of the registry, to which dimensions can be registered
+// without changing the original.
+func (r *Registry) Clone() *Registry {
+	clone := &Registry{dimensions: slices.Clone(r.dimensions), byName: make(map[types.Dimension]int, len(r.byName))}
+	for name, i := range r.byName {
+		clone.byName[name] = i
+	}
+	return clone
+}
+
+// Default returns a new Registry holding the built-in dimensions.
+func Default() *Registry {
+	r, _ := NewRegistry(Builtins...)
+	return r
+}
+
+// Register adds a dimension to the registry.
+//
+// Parameters:
+//   - d: The dimension, whose name must be lowercase letters, digits and underscores.
+//
+// Returns:
+//   - An error if the dimension is invalid or its name is already registered.
+func (r *Registry) Register(d Dimension) error {
+	switch {
+	case !validName.MatchString(string(d.Name)):
+		return fmt.Errorf("dimension %q: name must be lowercase letters, digits and underscores, starting with a letter", d.Name)
+	case d.Field == "" && d.Formula == nil:
+		return fmt.Errorf("dimension %q: field or formula is required", d.Name)
+	case d.Field != "" && d.Formula != nil:
+		return fmt.Errorf("dimension %q: field and formula cannot be set together", d.Name)
+	case d.Formula != nil && d.PostTypes != nil && len(d.PostTypes) == 0:
+		return fmt.Errorf("dimension %q: no post type supports every field of the formula %q", d.Name, d.Formula)
+	case d.PostTypes != nil && len(d.PostTypes) == 0:
+		return fmt.Errorf("dimension %q: post types must be omitted or not empty", d.Name)
+	}
+	if _, ok := r.byName[d.Name]; ok {
+		return fmt.Errorf("dimension %q: %w", d.Name, ErrDuplicate)
+	}
+
+	r.byName[d.Name] = len(r.dimensions)
+	r.dimensions = append(r.dimensions, d)
+	return nil
+}
+
+// Lookup returns the dimension with the given name.
+func (r *Registry) Lookup(name types.Dimension) (Dimension, bool) {
+	i, ok := r.byName[name]
+	if !ok {
+		return Dimension{}, false
+	}
+	return r.dimensions[i], true
+}
+
+// All returns the registered dimensions, in the order they were registered.
+func (r *Registry) All() []Dimension {
+	return slices.Clone(r.dimensions)
+}
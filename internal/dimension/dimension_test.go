@@ -0,0 +1,122 @@
+package dimension
+
+import (
+	"upfcc/internal/sseclient"
+	"upfcc/internal/types"
+
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDimension_Value(t *testing.T) {
+	var data sseclient.SocialPost
+	json.Unmarshal([]byte(`{"timestamp":1,"likes":10,"comments":5,"favorites":7,"retweets":3,"views":1500,"text":"hello"}`), &data)
+	post := sseclient.Post{Type: "tiktok_video", Data: data}
+
+	tests := []struct {
+		name      string
+		dimension Dimension
+		want      float64
+	}{
+		{name: "Likes", dimension: Likes, want: 10},
+		{name: "Comments", dimension: Comments, want: 5},
+		{name: "Favorites", dimension: Favorites, want: 7},
+		{name: "Retweets", dimension: Retweets, want: 3},
+		{name: "Extra Field", dimension: FromField("views", "views"), want: 1500},
+		{name: "Renamed Field", dimension: FromField("plays", "views"), want: 1500},
+		{name: "Not A Number", dimension: FromField("text", "text"), want: 0},
+		{name: "Missing Field", dimension: FromField("shares", "shares"), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dimension.Value(post); got != tt.want {
+				t.Errorf("Value() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDimension_Supports(t *testing.T) {
+	views := FromField("views", "views", "youtube_video", "tiktok_video")
+	if !views.Supports("youtube_video") || views.Supports("tweet") {
+		t.Errorf("Supports() does not follow the post types %v", views.PostTypes)
+	}
+	if !Likes.Supports("tweet") || !Likes.Supports("article") {
+		t.Error("Supports() = false for a built-in dimension, want every post type")
+	}
+}
+
+func TestDimension_MarshalJSON(t *testing.T) {
+	got, err := json.Marshal([]Dimension{Likes, FromField("plays", "views", "youtube_video")})
+	if err != nil {
+		t.Fatalf("MarshalJSON() error = %v", err)
+	}
+	want := `[{"name":"likes","field":"likes"},{"name":"plays","field":"views","post_types":["youtube_video"]}]`
+	if string(got) != want {
+		t.Errorf("MarshalJSON() = %s, want %s", got, want)
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	tests := []struct {
+		name      string
+		dimension Dimension
+		wantErr   string
+	}{
+		{name: "Valid", dimension: FromField("views", "views", "youtube_video")},
+		{name: "All Post Types", dimension: FromField("saves", "saves")},
+		{name: "Duplicate", dimension: FromField("likes", "favorites"), wantErr: `dimension "likes": already registered`},
+		{name: "Invalid Name", dimension: FromField("Views", "views"), wantErr: `dimension "Views": name must be`},
+		{name: "Empty Name", dimension: FromField("", "views"), wantErr: `dimension "": name must be`},
+		{name: "Missing Field", dimension: FromField("views", ""), wantErr: `dimension "views": field or formula is required`},
+		{name: "Empty Post Types", dimension: Dimension{Name: "views", Field: "views", PostTypes: []string{}}, wantErr: "post types must be omitted or not empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := Default()
+			err := registry.Register(tt.dimension)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Register() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				if _, ok := registry.Lookup(tt.dimension.Name); ok && tt.dimension.Name != types.Likes {
+					t.Errorf("Register() registered the invalid dimension %q", tt.dimension.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Register() error = %v", err)
+			}
+			if got, ok := registry.Lookup(tt.dimension.Name); !ok || got.Field != tt.dimension.Field {
+				t.Errorf("Lookup(%q) = %+v, %v, want the registered dimension", tt.dimension.Name, got, ok)
+			}
+		})
+	}
+
+	if _, err := NewRegistry(Likes, Likes); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("NewRegistry() error = %v, want ErrDuplicate", err)
+	}
+}
+
+func TestRegistry_All(t *testing.T) {
+	registry := Default()
+	registry.Register(FromField("views", "views"))
+
+	all := registry.All()
+	var names []string
+	for _, d := range all {
+		names = append(names, string(d.Name))
+	}
+	if got := strings.Join(names, ","); got != "likes,comments,favorites,retweets,interactions,views" {
+		t.Errorf("All() = %s, want the dimensions in registration order", got)
+	}
+
+	all[0] = Comments
+	if d, _ := registry.Lookup(types.Likes); d.Name != types.Likes || registry.All()[0].Name != types.Likes {
+		t.Error("All() returned the slice of the registry, which was modified")
+	}
+}
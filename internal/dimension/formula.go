@@ -0,0 +1,397 @@
+package dimension
+
+import (
+	"upfcc/internal/sseclient"
+	"upfcc/internal/types"
+
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Formula is an arithmetic expression computing the value of a derived
+// dimension from other dimensions of the same post, such as:
+//
+//	likes + comments*2 + retweets*3
+//	(likes + comments) / views
+//
+// It combines registered dimensions, the extra metrics of
+// sseclient.ExtraMetrics and numbers with +, -, *, / and parentheses, with the
+// usual precedence. Formulas are parsed and validated once, and then evaluated
+// for every post.
+type Formula struct {
+	expr   string
+	root   node
+	fields []Dimension // fields are the dimensions read by the formula, in the order they first appear.
+}
+
+// FormulaError describes an invalid formula and where the problem is.
+type FormulaError struct {
+	Formula string // Formula is the invalid formula.
+	Pos     int    // Pos is the byte offset of the problem in Formula.
+	Msg     string // Msg describes the problem.
+}
+
+// Error returns the description of the problem with its position, counted in
+// characters from 1.
+func (e *FormulaError) Error() string {
+	return fmt.Sprintf("at position %d: %s", e.column(), e.Msg)
+}
+
+// column returns the position of the problem, counted in characters from 1.
+func (e *FormulaError) column() int {
+	return utf8.RuneCountInString(e.Formula[:e.Pos]) + 1
+}
+
+// ParseFormula parses and validates a formula.
+//
+// Parameters:
+//   - expr: The formula, such as "likes + comments*2 + retweets*3".
+//   - registry: The registry of the dimensions the formula can read.
+//
+// Returns:
+//   - The Formula.
+//   - A *FormulaError if the formula is invalid, reads an unknown field or
+//     divides by a constant zero.
+func ParseFormula(expr string, registry *Registry) (*Formula, error) {
+	tokens, err := lexFormula(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &formulaParser{expr: expr, tokens: tokens, registry: registry}
+	if p.peek().kind == formulaEOF {
+		return nil, p.errorf(p.peek(), "empty formula")
+	}
+
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != formulaEOF {
+		return nil, p.errorf(next, "expected an operator (+, -, * or /), got %s", next)
+	}
+	return &Formula{expr: strings.TrimSpace(expr), root: root, fields: p.fields}, nil
+}
+
+// String returns the formula as written.
+func (f *Formula) String() string {
+	return f.expr
+}
+
+// Eval computes the value of the formula for a post. The second return value
+// is false if the value is undefined, because the post does not support one of
+// the fields of the formula or the formula divides by zero.
+func (f *Formula) Eval(post sseclient.Post) (float64, bool) {
+	return f.root.eval(post)
+}
+
+// postTypes returns the post types supporting every field of the formula, nil
+// for all of them.
+func (f *Formula) postTypes() []string {
+	var postTypes []string
+	for _, d := range f.fields {
+		switch {
+		case d.PostTypes == nil:
+		case postTypes == nil:
+			postTypes = slices.Clone(d.PostTypes)
+		default:
+			postTypes = slices.DeleteFunc(postTypes, func(postType string) bool {
+				return !slices.Contains(d.PostTypes, postType)
+			})
+		}
+	}
+	return postTypes
+}
+
+// node is a node of the syntax tree of a formula.
+type node interface {
+	eval(post sseclient.Post) (float64, bool)
+}
+
+// constant is a number, or a part of a formula reading no field.
+type constant float64
+
+func (c constant) eval(sseclient.Post) (float64, bool) {
+	return float64(c), true
+}
+
+// fieldNode reads the value of a dimension.
+type fieldNode struct {
+	dimension Dimension
+}
+
+func (n fieldNode) eval(post sseclient.Post) (float64, bool) {
+	return n.dimension.Extract(post)
+}
+
+// negation negates its operand.
+type negation struct {
+	operand node
+}
+
+func (n negation) eval(post sseclient.Post) (float64, bool) {
+	value, ok := n.operand.eval(post)
+	return -value, ok
+}
+
+// binary applies an arithmetic operator to two operands.
+type binary struct {
+	op          byte
+	left, right node
+}
+
+func (n binary) eval(post sseclient.Post) (float64, bool) {
+	left, ok := n.left.eval(post)
+	if !ok {
+		return 0, false
+	}
+	right, ok := n.right.eval(post)
+	if !ok {
+		return 0, false
+	}
+	return apply(n.op, left, right)
+}
+
+// apply applies an arithmetic operator. The second return value is false for
+// a division by zero.
+func apply(op byte, left, right float64) (float64, bool) {
+	switch op {
+	case '+':
+		return left + right, true
+	case '-':
+		return left - right, true
+	case '*':
+		return left * right, true
+	default:
+		if right == 0 {
+			return 0, false
+		}
+		return left / right, true
+	}
+}
+
+// formulaParser is a recursive descent parser of formulas:
+//
+//	sum     = product { ("+" | "-") product }
+//	product = unary { ("*" | "/") unary }
+//	unary   = "-" unary | "(" sum ")" | number | field
+//
+// Parts of a formula reading no field are computed while parsing, so that
+// dividing by a constant zero is reported up front.
+type formulaParser struct {
+	expr     string
+	tokens   []formulaToken
+	pos      int         // pos is the index of the next token.
+	registry *Registry   // registry holds the dimensions the formula can read.
+	fields   []Dimension // fields are the dimensions read so far.
+}
+
+func (p *formulaParser) peek() formulaToken {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the next token. The final formulaEOF is never consumed.
+func (p *formulaParser) next() formulaToken {
+	t := p.tokens[p.pos]
+	if t.kind != formulaEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *formulaParser) errorf(t formulaToken, format string, args ...any) *FormulaError {
+	return &FormulaError{Formula: p.expr, Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parseSum parses products combined with + and -, which bind the loosest.
+func (p *formulaParser) parseSum() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isOp('+') || p.peek().isOp('-') {
+		op := p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left, _ = combine(op.text[0], left, right)
+	}
+	return left, nil
+}
+
+// parseProduct parses operands combined with * and /.
+func (p *formulaParser) parseProduct() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isOp('*') || p.peek().isOp('/') {
+		op := p.next()
+		divisor := p.peek()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if left, ok = combine(op.text[0], left, right); !ok {
+			return nil, p.errorf(divisor, "division by zero")
+		}
+	}
+	return left, nil
+}
+
+// parseUnary parses a negation, a parenthesized formula, a number or a field.
+func (p *formulaParser) parseUnary() (node, error) {
+	t := p.next()
+	switch {
+	case t.isOp('-'):
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := operand.(constant); ok {
+			return -c, nil
+		}
+		return negation{operand}, nil
+	case t.kind == formulaLParen:
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != formulaRParen {
+			return nil, p.errorf(closing, "expected \")\" to close the \"(\" at position %d, got %s", p.errorf(t, "").column(), closing)
+		}
+		return inner, nil
+	case t.kind == formulaNumber:
+		number, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %q", t.text)
+		}
+		return constant(number), nil
+	case t.kind == formulaName:
+		d, ok := p.lookupField(t.text)
+		if !ok {
+			return nil, p.errorf(t, "unknown field %q, expected one of %s", t.text, p.fieldNames())
+		}
+		if !slices.ContainsFunc(p.fields, func(field Dimension) bool { return field.Name == d.Name }) {
+			p.fields = append(p.fields, d)
+		}
+		return fieldNode{d}, nil
+	default:
+		return nil, p.errorf(t, "expected a number, a field or \"(\", got %s", t)
+	}
+}
+
+// combine returns the node applying the operator to two operands, computed at
+// once if both are constants. The second return value is false for a division
+// by a constant zero.
+func combine(op byte, left, right node) (node, bool) {
+	if r, ok := right.(constant); ok && op == '/' && r == 0 {
+		return nil, false
+	}
+	l, leftConstant := left.(constant)
+	r, rightConstant := right.(constant)
+	if leftConstant && rightConstant {
+		value, _ := apply(op, float64(l), float64(r))
+		return constant(value), true
+	}
+	return binary{op: op, left: left, right: right}, true
+}
+
+// lookupField returns the dimension read by a field of a formula: a registered
+// dimension or an extra metric, which is zero for the posts without it.
+func (p *formulaParser) lookupField(name string) (Dimension, bool) {
+	if d, ok := p.registry.Lookup(types.Dimension(name)); ok {
+		return d, true
+	}
+	if sseclient.IsExtraMetric(name) {
+		return FromField(types.Dimension(name), name), true
+	}
+	return Dimension{}, false
+}
+
+// fieldNames returns the names of the fields, as listed in error messages.
+func (p *formulaParser) fieldNames() string {
+	var names []string
+	for _, d := range p.registry.All() {
+		names = append(names, string(d.Name))
+	}
+	for _, metric := range sseclient.ExtraMetrics {
+		if !slices.Contains(names, metric) {
+			names = append(names, metric)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// formulaTokenKind is the kind of a token of a formula.
+type formulaTokenKind int
+
+const (
+	formulaEOF formulaTokenKind = iota
+	formulaNumber
+	formulaName
+	formulaOp
+	formulaLParen
+	formulaRParen
+)
+
+// formulaToken is a token of a formula.
+type formulaToken struct {
+	kind formulaTokenKind
+	text string
+	pos  int // pos is the byte offset of the token in the formula.
+}
+
+// isOp reports whether the token is the given operator.
+func (t formulaToken) isOp(op byte) bool {
+	return t.kind == formulaOp && t.text[0] == op
+}
+
+// String describes the token in error messages.
+func (t formulaToken) String() string {
+	if t.kind == formulaEOF {
+		return "the end of the formula"
+	}
+	return strconv.Quote(t.text)
+}
+
+// lexFormula splits a formula into tokens, ending with a formulaEOF token.
+func lexFormula(expr string) ([]formulaToken, error) {
+	var tokens []formulaToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+			continue
+		case c == '+' || c == '-' || c == '*' || c == '/':
+			tokens = append(tokens, formulaToken{kind: formulaOp, text: expr[i : i+1], pos: i})
+			i++
+		case c == '(':
+			tokens = append(tokens, formulaToken{kind: formulaLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, formulaToken{kind: formulaRParen, text: ")", pos: i})
+			i++
+		case c >= '0' && c <= '9' || c == '.':
+			for i < len(expr) && (expr[i] >= '0' && expr[i] <= '9' || expr[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, formulaToken{kind: formulaNumber, text: expr[start:i], pos: start})
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_':
+			for i < len(expr) && (expr[i] >= 'a' && expr[i] <= 'z' || expr[i] >= 'A' && expr[i] <= 'Z' || expr[i] >= '0' && expr[i] <= '9' || expr[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, formulaToken{kind: formulaName, text: expr[start:i], pos: start})
+		default:
+			r, _ := utf8.DecodeRuneInString(expr[i:])
+			return nil, &FormulaError{Formula: expr, Pos: i, Msg: fmt.Sprintf("unexpected %q", r)}
+		}
+	}
+	return append(tokens, formulaToken{kind: formulaEOF, pos: len(expr)}), nil
+}
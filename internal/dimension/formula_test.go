@@ -0,0 +1,110 @@
+package dimension
+
+import (
+	"upfcc/internal/sseclient"
+
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseFormula(t *testing.T) {
+	var video sseclient.SocialPost
+	json.Unmarshal([]byte(`{"timestamp":1,"likes":10,"comments":4,"views":200}`), &video)
+	posts := []sseclient.Post{
+		{Type: "tweet", Data: sseclient.SocialPost{Timestamp: 1, Likes: 6, Comments: 1, Retweets: 2}},
+		{Type: "youtube_video", Data: video},
+	}
+	registry := Default()
+	registry.Register(FromField("plays", "views", "youtube_video"))
+
+	tests := []struct {
+		name          string
+		expr          string
+		want          []float64
+		wantOk        []bool // wantOk reports whether the formula is defined for each post.
+		wantPostTypes []string
+	}{
+		{name: "Weighted Sum", expr: "likes+comments*2+retweets*3", want: []float64{14, 18}, wantOk: []bool{true, true}},
+		{name: "Parentheses", expr: "(likes + comments) * 2", want: []float64{14, 28}, wantOk: []bool{true, true}},
+		{name: "Left Associative", expr: "likes - comments - 1", want: []float64{4, 5}, wantOk: []bool{true, true}},
+		{name: "Negation", expr: "-likes + 10", want: []float64{4, 0}, wantOk: []bool{true, true}},
+		{name: "Built-in Interactions", expr: "interactions / 2", want: []float64{4.5, 7}, wantOk: []bool{true, true}},
+		{name: "Division By A Zero Field", expr: "likes / retweets", want: []float64{3, 0}, wantOk: []bool{true, false}},
+		{name: "Extra Metric", expr: "views + 1", want: []float64{1, 201}, wantOk: []bool{true, true}},
+		{name: "Restricted Dimension", expr: "interactions / plays", want: []float64{0, 0.07}, wantOk: []bool{false, true}, wantPostTypes: []string{"youtube_video"}},
+		{name: "Constant", expr: "2 * (3 - 1.5)", want: []float64{3, 3}, wantOk: []bool{true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formula, err := ParseFormula(tt.expr, registry)
+			if err != nil {
+				t.Fatalf("ParseFormula(%q) error = %v", tt.expr, err)
+			}
+			for i, post := range posts {
+				got, ok := formula.Eval(post)
+				if got != tt.want[i] || ok != tt.wantOk[i] {
+					t.Errorf("Eval(%s) = %v, %v, want %v, %v", post.Type, got, ok, tt.want[i], tt.wantOk[i])
+				}
+			}
+			if d := FromFormula("derived", formula); !slices.Equal(d.PostTypes, tt.wantPostTypes) {
+				t.Errorf("FromFormula() post types = %v, want %v", d.PostTypes, tt.wantPostTypes)
+			}
+		})
+	}
+}
+
+func TestParseFormulaErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		expr      string
+		wantError string
+	}{
+		{name: "Empty", expr: " ", wantError: "at position 2: empty formula"},
+		{name: "Unknown Field", expr: "likes + like", wantError: `at position 9: unknown field "like", expected one of likes, comments, favorites, retweets, interactions, views, shares, saves, repins`},
+		{name: "Division By Zero", expr: "likes / 0", wantError: "at position 9: division by zero"},
+		{name: "Division By A Zero Expression", expr: "likes / (2 - 2*1)", wantError: "at position 9: division by zero"},
+		{name: "Missing Operator", expr: "likes comments", wantError: `at position 7: expected an operator (+, -, * or /), got "comments"`},
+		{name: "Missing Operand", expr: "likes *", wantError: "at position 8: expected a number, a field or \"(\", got the end of the formula"},
+		{name: "Unclosed Parenthesis", expr: "(likes + 1", wantError: `at position 11: expected ")" to close the "(" at position 1, got the end of the formula`},
+		{name: "Invalid Number", expr: "likes * 1.2.3", wantError: `at position 9: invalid number "1.2.3"`},
+		{name: "Unexpected Character", expr: "likes % 2", wantError: `at position 7: unexpected '%'`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFormula(tt.expr, Default())
+			var formulaErr *FormulaError
+			if !errors.As(err, &formulaErr) {
+				t.Fatalf("ParseFormula(%q) error = %v, want a *FormulaError", tt.expr, err)
+			}
+			if got := err.Error(); !strings.HasPrefix(got, tt.wantError) {
+				t.Errorf("ParseFormula(%q) error = %q, want %q", tt.expr, got, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestRegistry_RegisterFormula(t *testing.T) {
+	registry := Default()
+	registry.Register(FromField("plays", "views", "youtube_video"))
+	registry.Register(FromField("saves", "saves", "pin"))
+
+	formula, _ := ParseFormula("plays + saves", registry)
+	err := registry.Register(FromFormula("reach", formula))
+	if err == nil || !strings.Contains(err.Error(), `no post type supports every field of the formula "plays + saves"`) {
+		t.Errorf("Register() error = %v, want an error about the post types", err)
+	}
+
+	formula, _ = ParseFormula("plays * 2", registry)
+	clone := registry.Clone()
+	if err := clone.Register(FromFormula("reach", formula)); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, ok := registry.Lookup("reach"); ok {
+		t.Error("Register() on a clone changed the original registry")
+	}
+}
@@ -0,0 +1,425 @@
+// Package filter implements the expression language used to select the posts
+// an analysis aggregates, such as:
+//
+//	type in (tweet, instagram_media) and likes > 100
+//	timestamp between 1714557600 and 1714561200
+//	not (comments = 0 or type = pin)
+//
+// An expression compares fields of a post with values, and combines the
+// comparisons with and, or, not and parentheses. The fields are the post type,
+// its timestamp, every registered dimension and the extra metrics of
+// sseclient.ExtraMetrics, which are zero for the posts that do not have them.
+// The type is compared with =, != and in, while the numeric fields also accept
+// <, <=, >, >= and between, whose bounds are inclusive. Timestamps are given in Unix seconds or in RFC 3339 format.
+// Values containing spaces or operators are quoted with ' or ".
+//
+// Expressions are compiled once into a Predicate, which is then evaluated for
+// every post without parsing.
+package filter
+
+import (
+	"upfcc/internal/dimension"
+	"upfcc/internal/sseclient"
+	"upfcc/internal/types"
+
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Predicate reports whether a post is selected by a filter.
+type Predicate func(post sseclient.Post) bool
+
+// SyntaxError describes an invalid filter expression and where the problem is.
+type SyntaxError struct {
+	Expr string // Expr is the invalid expression.
+	Pos  int    // Pos is the byte offset of the problem in Expr.
+	Msg  string // Msg describes the problem.
+}
+
+// Error returns the description of the problem with its position, counted in
+// characters from 1, and its line in multi-line expressions.
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("at %s: %s", e.location(), e.Msg)
+}
+
+// location describes the position of the problem, such as "position 3", or
+// "line 2, position 3" in a multi-line expression.
+func (e *SyntaxError) location() string {
+	if !strings.Contains(e.Expr, "\n") {
+		return fmt.Sprintf("position %d", e.Column())
+	}
+	return fmt.Sprintf("line %d, position %d", e.Line(), e.Column())
+}
+
+// Line returns the line of the problem, counted from 1.
+func (e *SyntaxError) Line() int {
+	return strings.Count(e.Expr[:e.Pos], "\n") + 1
+}
+
+// Column returns the position of the problem in its line, counted in characters from 1.
+func (e *SyntaxError) Column() int {
+	return utf8.RuneCountInString(e.Expr[e.lineStart():e.Pos]) + 1
+}
+
+// lineStart returns the byte offset of the start of the line of the problem.
+func (e *SyntaxError) lineStart() int {
+	return strings.LastIndexByte(e.Expr[:e.Pos], '\n') + 1
+}
+
+// Caret returns the line of the problem followed by a line with a caret under
+// the problem. Tabs are kept in front of the caret, so that it lines up with
+// the problem whatever the width of the tabs.
+func (e *SyntaxError) Caret() string {
+	start := e.lineStart()
+	line, _, _ := strings.Cut(e.Expr[start:], "\n")
+	line = strings.TrimSuffix(line, "\r")
+	indent := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, e.Expr[start:e.Pos])
+	return line + "\n" + indent + "^"
+}
+
+// errorf returns a SyntaxError at the given byte offset of the expression.
+func errorf(expr string, pos int, format string, args ...any) *SyntaxError {
+	return &SyntaxError{Expr: expr, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Parse parses and validates a filter expression, and compiles it to a Predicate.
+//
+// Parameters:
+//   - expr: The filter expression, such as "type = tweet and likes > 100".
+//   - dimensions: The registry of the dimensions the expression can compare.
+//
+// Returns:
+//   - The Predicate selecting the posts that match the expression.
+//   - A *SyntaxError if the expression is invalid.
+func Parse(expr string, dimensions *dimension.Registry) (Predicate, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{expr: expr, tokens: tokens, dimensions: dimensions}
+	if p.peek().kind == tokenEOF {
+		return nil, errorf(expr, 0, "empty expression")
+	}
+
+	predicate, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, p.errorf(next, "unexpected %s, expected and, or or the end of the expression", next)
+	}
+	return predicate, nil
+}
+
+// field is a field of a post that expressions can compare.
+type field struct {
+	name    string
+	numeric bool
+	value   func(post sseclient.Post) float64 // value returns the value of a numeric field.
+}
+
+// typeField is the name of the post type field, the only one that is not numeric.
+const typeField = "type"
+
+// lookupField returns the field with the given name.
+func (p *parser) lookupField(name string) (field, bool) {
+	switch name {
+	case typeField:
+		return field{name: name}, true
+	case "timestamp":
+		return field{name: name, numeric: true, value: func(post sseclient.Post) float64 {
+			return float64(post.Data.Timestamp)
+		}}, true
+	}
+
+	if d, ok := p.dimensions.Lookup(types.Dimension(name)); ok {
+		return field{name: name, numeric: true, value: d.Value}, true
+	}
+	if sseclient.IsExtraMetric(name) {
+		return field{name: name, numeric: true, value: func(post sseclient.Post) float64 {
+			value, _ := post.Data.Number(name)
+			return value
+		}}, true
+	}
+	return field{}, false
+}
+
+// fieldNames returns the names of the fields, as listed in error messages.
+func (p *parser) fieldNames() string {
+	names := []string{typeField, "timestamp"}
+	for _, d := range p.dimensions.All() {
+		names = append(names, string(d.Name))
+	}
+	for _, metric := range sseclient.ExtraMetrics {
+		if !slices.Contains(names, metric) {
+			names = append(names, metric)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// parser is a recursive descent parser of filter expressions:
+//
+//	or         = and { "or" and }
+//	and        = not { "and" not }
+//	not        = "not" not | "(" or ")" | comparison
+//	comparison = field op value
+//	           | field [ "not" ] "in" "(" value { "," value } ")"
+//	           | field "between" value "and" value
+type parser struct {
+	expr       string
+	tokens     []token
+	pos        int                 // pos is the index of the next token.
+	dimensions *dimension.Registry // dimensions are the dimensions that can be compared, along with the other fields.
+}
+
+// peek returns the next token without consuming it.
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the next token. The final tokenEOF is never consumed.
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// errorf returns a SyntaxError at the given token.
+func (p *parser) errorf(t token, format string, args ...any) *SyntaxError {
+	return errorf(p.expr, t.pos, format, args...)
+}
+
+// parseOr parses comparisons combined with or, which binds the loosest.
+func (p *parser) parseOr() (Predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(post sseclient.Post) bool { return l(post) || right(post) }
+	}
+	return left, nil
+}
+
+// parseAnd parses comparisons combined with and.
+func (p *parser) parseAnd() (Predicate, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(post sseclient.Post) bool { return l(post) && right(post) }
+	}
+	return left, nil
+}
+
+// parseNot parses a negation, a parenthesized expression or a comparison.
+func (p *parser) parseNot() (Predicate, error) {
+	t := p.peek()
+	switch {
+	case t.is("not"):
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(post sseclient.Post) bool { return !operand(post) }, nil
+	case t.kind == tokenLParen:
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, p.errorf(closing, "expected \")\" to close the \"(\" at %s, got %s", p.errorf(t, "").location(), closing)
+		}
+		return inner, nil
+	default:
+		return p.parseComparison()
+	}
+}
+
+// parseComparison parses the comparison of a field with one or more values.
+func (p *parser) parseComparison() (Predicate, error) {
+	name := p.next()
+	if name.kind != tokenWord || isKeyword(name) {
+		return nil, p.errorf(name, "expected a field name, got %s", name)
+	}
+	f, ok := p.lookupField(name.text)
+	if !ok {
+		return nil, p.errorf(name, "unknown field %q, expected one of %s", name.text, p.fieldNames())
+	}
+
+	op := p.next()
+	switch {
+	case op.kind == tokenOp:
+		if !f.numeric && op.text != "=" && op.text != "!=" {
+			return nil, p.errorf(op, "operator %s cannot compare %s, use =, != or in", op.text, f.name)
+		}
+		value, err := p.parseValue(f)
+		if err != nil {
+			return nil, err
+		}
+		return compare(f, op.text, value), nil
+	case op.is("in"):
+		return p.parseIn(f, false)
+	case op.is("not") && p.peek().is("in"):
+		p.next()
+		return p.parseIn(f, true)
+	case op.is("between"):
+		if !f.numeric {
+			return nil, p.errorf(op, "between cannot compare %s, use =, != or in", f.name)
+		}
+		return p.parseBetween(f)
+	default:
+		return nil, p.errorf(op, "expected an operator (=, !=, <, <=, >, >=, in or between) after %s, got %s", f.name, op)
+	}
+}
+
+// parseIn parses the list of values of an in comparison, after the in keyword.
+func (p *parser) parseIn(f field, negate bool) (Predicate, error) {
+	if open := p.next(); open.kind != tokenLParen {
+		return nil, p.errorf(open, "expected \"(\" to start the list of values, got %s", open)
+	}
+
+	var values []value
+	for {
+		v, err := p.parseValue(f)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		t := p.next()
+		if t.kind == tokenRParen {
+			break
+		}
+		if t.kind != tokenComma {
+			return nil, p.errorf(t, "expected \",\" or \")\" in the list of values, got %s", t)
+		}
+	}
+
+	return func(post sseclient.Post) bool {
+		for _, v := range values {
+			if v.equals(f, post) {
+				return !negate
+			}
+		}
+		return negate
+	}, nil
+}
+
+// parseBetween parses the bounds of a between comparison, after the between keyword.
+func (p *parser) parseBetween(f field) (Predicate, error) {
+	low, err := p.parseValue(f)
+	if err != nil {
+		return nil, err
+	}
+	if and := p.next(); !and.is("and") {
+		return nil, p.errorf(and, "expected and between the bounds of between, got %s", and)
+	}
+	boundPos := p.peek().pos
+	high, err := p.parseValue(f)
+	if err != nil {
+		return nil, err
+	}
+	if low.number > high.number {
+		return nil, errorf(p.expr, boundPos, "upper bound %v is lower than the lower bound %v", high.text, low.text)
+	}
+
+	return func(post sseclient.Post) bool {
+		v := f.value(post)
+		return v >= low.number && v <= high.number
+	}, nil
+}
+
+// value is a value of a comparison.
+type value struct {
+	text   string  // text is the value as written, which the type is compared with.
+	number float64 // number is the value of a numeric field.
+}
+
+// equals reports whether the field of the post equals the value.
+func (v value) equals(f field, post sseclient.Post) bool {
+	if !f.numeric {
+		return post.Type == v.text
+	}
+	return f.value(post) == v.number
+}
+
+// parseValue parses a value compared with the given field.
+func (p *parser) parseValue(f field) (value, error) {
+	t := p.next()
+	if (t.kind != tokenWord && t.kind != tokenString) || (t.kind == tokenWord && isKeyword(t)) {
+		return value{}, p.errorf(t, "expected a value for %s, got %s", f.name, t)
+	}
+	if !f.numeric {
+		return value{text: t.text}, nil
+	}
+
+	if number, err := strconv.ParseFloat(t.text, 64); err == nil {
+		return value{text: t.text, number: number}, nil
+	}
+	if f.name == "timestamp" {
+		if at, err := time.Parse(time.RFC3339, t.text); err == nil {
+			return value{text: t.text, number: float64(at.Unix())}, nil
+		}
+		return value{}, p.errorf(t, "expected a Unix timestamp or an RFC 3339 time for timestamp, got %s", t)
+	}
+	return value{}, p.errorf(t, "expected a number for %s, got %s", f.name, t)
+}
+
+// compare returns the Predicate comparing the field with the value using the operator.
+func compare(f field, op string, v value) Predicate {
+	if !f.numeric {
+		if op == "!=" {
+			return func(post sseclient.Post) bool { return post.Type != v.text }
+		}
+		return func(post sseclient.Post) bool { return post.Type == v.text }
+	}
+
+	switch op {
+	case "!=":
+		return func(post sseclient.Post) bool { return f.value(post) != v.number }
+	case "<":
+		return func(post sseclient.Post) bool { return f.value(post) < v.number }
+	case "<=":
+		return func(post sseclient.Post) bool { return f.value(post) <= v.number }
+	case ">":
+		return func(post sseclient.Post) bool { return f.value(post) > v.number }
+	case ">=":
+		return func(post sseclient.Post) bool { return f.value(post) >= v.number }
+	default:
+		return func(post sseclient.Post) bool { return f.value(post) == v.number }
+	}
+}
+
+// isKeyword reports whether a word is a keyword of the language, which cannot
+// be used as a field name or an unquoted value.
+func isKeyword(t token) bool {
+	return t.is("and") || t.is("or") || t.is("not") || t.is("in") || t.is("between")
+}
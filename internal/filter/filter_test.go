@@ -0,0 +1,170 @@
+package filter
+
+import (
+	"upfcc/internal/dimension"
+	"upfcc/internal/sseclient"
+
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	posts := []sseclient.Post{
+		{Type: "tweet", Data: sseclient.SocialPost{Timestamp: 1714557600, Likes: 150, Retweets: 3}},
+		{Type: "instagram_media", Data: sseclient.SocialPost{Timestamp: 1714557700, Likes: 50, Comments: 2,
+			Extra: map[string]json.RawMessage{"views": json.RawMessage("2000")}}},
+		{Type: "pin", Data: sseclient.SocialPost{Timestamp: 1714557800, Likes: 500}},
+		{Type: "youtube_video", Data: sseclient.SocialPost{Timestamp: 1714561300, Comments: 10}},
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		want []bool // want reports whether each post is selected.
+	}{
+		{name: "Type Equals", expr: "type = tweet", want: []bool{true, false, false, false}},
+		{name: "Type Double Equals Quoted", expr: `type == "pin"`, want: []bool{false, false, true, false}},
+		{name: "Type Not Equals", expr: "type != tweet", want: []bool{false, true, true, true}},
+		{name: "Type In", expr: "type in (tweet, instagram_media)", want: []bool{true, true, false, false}},
+		{name: "Type Not In", expr: "type not in (tweet, instagram_media)", want: []bool{false, false, true, true}},
+		{name: "Greater Than", expr: "likes > 100", want: []bool{true, false, true, false}},
+		{name: "Less Or Equal", expr: "likes<=50", want: []bool{false, true, false, true}},
+		{name: "Greater Or Equal", expr: "comments >= 2", want: []bool{false, true, false, true}},
+		{name: "Numeric In", expr: "likes in (50, 500)", want: []bool{false, true, true, false}},
+		{name: "Between", expr: "timestamp between 1714557600 and 1714557700", want: []bool{true, true, false, false}},
+		{name: "Between RFC 3339", expr: "timestamp between 2024-05-01T10:00:00Z and '2024-05-01T10:01:40Z'", want: []bool{true, true, false, false}},
+		{name: "And Binds Tighter Than Or", expr: "type = pin or type = tweet and likes > 1000", want: []bool{false, false, true, false}},
+		{name: "Parentheses", expr: "(type = pin or type = tweet) and likes > 200", want: []bool{false, false, true, false}},
+		{name: "Not", expr: "not (comments = 0 or type = pin)", want: []bool{false, true, false, true}},
+		{name: "Extra Metric", expr: "views >= 1000", want: []bool{false, true, false, false}},
+		{name: "Keywords Ignore Case", expr: "type IN (tweet) OR likes BETWEEN 0 AND 60", want: []bool{true, true, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			predicate, err := Parse(tt.expr, dimension.Default())
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+			}
+			for i, post := range posts {
+				if got := predicate(post); got != tt.want[i] {
+					t.Errorf("Parse(%q) selects %+v: %v, want %v", tt.expr, post, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		expr      string
+		wantError string
+		wantCaret string
+	}{
+		{
+			name:      "Empty",
+			expr:      "  ",
+			wantError: "at position 1: empty expression",
+			wantCaret: "  \n^",
+		},
+		{
+			name:      "Unknown Field",
+			expr:      "type = tweet and like > 100",
+			wantError: `at position 18: unknown field "like", expected one of type, timestamp, likes, comments, favorites, retweets, interactions, views, shares, saves, repins`,
+			wantCaret: "type = tweet and like > 100\n                 ^",
+		},
+		{
+			name:      "Missing Value",
+			expr:      "likes >",
+			wantError: "at position 8: expected a value for likes, got the end of the expression",
+			wantCaret: "likes >\n       ^",
+		},
+		{
+			name:      "Not A Number",
+			expr:      "likes > many",
+			wantError: `at position 9: expected a number for likes, got "many"`,
+		},
+		{
+			name:      "Invalid Timestamp",
+			expr:      "timestamp > yesterday",
+			wantError: `at position 13: expected a Unix timestamp or an RFC 3339 time for timestamp, got "yesterday"`,
+		},
+		{
+			name:      "Ordering The Type",
+			expr:      "type > tweet",
+			wantError: "at position 6: operator > cannot compare type, use =, != or in",
+		},
+		{
+			name:      "Missing Operator",
+			expr:      "likes 100",
+			wantError: `at position 7: expected an operator (=, !=, <, <=, >, >=, in or between) after likes, got "100"`,
+		},
+		{
+			name:      "Unclosed Parenthesis",
+			expr:      "(likes > 1 or comments > 1",
+			wantError: `at position 27: expected ")" to close the "(" at position 1, got the end of the expression`,
+		},
+		{
+			name:      "Unclosed List",
+			expr:      "type in (tweet pin)",
+			wantError: `at position 16: expected "," or ")" in the list of values, got "pin"`,
+		},
+		{
+			name:      "Reversed Between",
+			expr:      "likes between 10 and 5",
+			wantError: "at position 22: upper bound 5 is lower than the lower bound 10",
+		},
+		{
+			name:      "Trailing Tokens",
+			expr:      "likes > 1 comments > 1",
+			wantError: `at position 11: unexpected "comments", expected and, or or the end of the expression`,
+		},
+		{
+			name:      "Unterminated String",
+			expr:      "type = 'tweet",
+			wantError: "at position 8: unterminated string, missing closing '",
+		},
+		{
+			name:      "Bang",
+			expr:      "!likes",
+			wantError: `at position 1: unexpected "!", use != or not`,
+		},
+		{
+			name:      "Position In Characters",
+			expr:      "type = 'é' and é > 1",
+			wantError: `at position 16: unknown field "é"`,
+			wantCaret: "type = 'é' and é > 1\n               ^",
+		},
+		{
+			name:      "Multi-line",
+			expr:      "type = tweet\nand\tlike > 100",
+			wantError: `at line 2, position 5: unknown field "like"`,
+			wantCaret: "and\tlike > 100\n   \t^",
+		},
+		{
+			name:      "Multi-line Unclosed Parenthesis",
+			expr:      "type = tweet and\r\n  (likes > 1\r\n  or comments > 2",
+			wantError: `at line 3, position 18: expected ")" to close the "(" at line 2, position 3, got the end of the expression`,
+			wantCaret: "  or comments > 2\n                 ^",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expr, dimension.Default())
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Parse(%q) error = %v, want a *SyntaxError", tt.expr, err)
+			}
+			if got := err.Error(); !strings.HasPrefix(got, tt.wantError) {
+				t.Errorf("Parse(%q) error = %q, want %q", tt.expr, got, tt.wantError)
+			}
+			if tt.wantCaret != "" && syntaxErr.Caret() != tt.wantCaret {
+				t.Errorf("Caret() = %q, want %q", syntaxErr.Caret(), tt.wantCaret)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind is the kind of a token of a filter expression.
+type tokenKind int
+
+const (
+	tokenEOF    tokenKind = iota // tokenEOF ends every expression.
+	tokenWord                    // tokenWord is a field name, a keyword, a number or an unquoted value.
+	tokenString                  // tokenString is a quoted value, without its quotes.
+	tokenOp                      // tokenOp is a comparison operator.
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+// token is a token of a filter expression.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // pos is the byte offset of the token in the expression.
+}
+
+// String returns the token as it is quoted in error messages.
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "the end of the expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// is reports whether the token is the given keyword, ignoring case.
+func (t token) is(keyword string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, keyword)
+}
+
+// lex splits an expression into tokens, ending with a tokenEOF.
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			n := 1
+			if i+1 < len(expr) && expr[i+1] == '=' {
+				n = 2
+			}
+			op := expr[i : i+n]
+			switch op {
+			case "!":
+				return nil, errorf(expr, i, "unexpected \"!\", use != or not")
+			case "==":
+				op = "="
+			}
+			tokens = append(tokens, token{tokenOp, op, i})
+			i += n
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, errorf(expr, i, "unterminated string, missing closing %c", c)
+			}
+			tokens = append(tokens, token{tokenString, expr[i+1 : i+1+end], i})
+			i += end + 2
+		default:
+			start := i
+			for i < len(expr) && !strings.ContainsRune(" \t\n\r(),=!<>'\"", rune(expr[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, expr[start:i], start})
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(expr)}), nil
+}
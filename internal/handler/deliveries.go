@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"upfcc/internal/webhook"
+
+	"net/http"
+)
+
+// DeliveriesHandler lists the last deliveries of results to callback URLs,
+// newest first, with their attempts, as a JSON array. The optional 'job_id'
+// query parameter restricts the list to the deliveries of a job. The list is
+// empty if callbacks are not enabled.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+func (h *Handler) DeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	deliveries := []webhook.Delivery{}
+	if h.callbacks != nil {
+		deliveries = h.callbacks.List(r.URL.Query().Get("job_id"))
+	}
+	writeStatus(w, http.StatusOK, deliveries)
+}
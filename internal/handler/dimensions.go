@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"net/http"
+)
+
+// DimensionsHandler lists the dimensions that can be analyzed, with the post
+// types supporting them, as a JSON array in the order they were registered.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+func (h *Handler) DimensionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, h.dimensions.All())
+}
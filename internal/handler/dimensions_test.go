@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDimensionsHandler(t *testing.T) {
+	handler := New(nil, &MockAggregator{}, WithDimensions(registryWithViews(t)))
+
+	rr := httptest.NewRecorder()
+	handler.DimensionsHandler(rr, httptest.NewRequest("GET", "/dimensions", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("handler returned Content-Type %q, want application/json", contentType)
+	}
+	want := `[{"name":"likes","field":"likes"},{"name":"comments","field":"comments"},` +
+		`{"name":"favorites","field":"favorites"},{"name":"retweets","field":"retweets"},` +
+		`{"name":"interactions","formula":"likes + comments + favorites + retweets"},` +
+		`{"name":"views","field":"views","post_types":["youtube_video","tiktok_video"]}]`
+	if got := strings.TrimSpace(rr.Body.String()); got != want {
+		t.Errorf("handler returned %s, want %s", got, want)
+	}
+}
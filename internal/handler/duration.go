@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isoDurationPattern matches the ISO 8601 durations made of weeks, days, hours,
+// minutes and seconds, such as "PT30S", "PT1H30M" or "P1DT12H". Only the last
+// component may have a fraction.
+var isoDurationPattern = regexp.MustCompile(`^([-+])?P(?:([\d.,]+)W)?(?:([\d.,]+)D)?(?:T(?:([\d.,]+)H)?(?:([\d.,]+)M)?(?:([\d.,]+)S)?)?$`)
+
+// isoDurationUnits are the units of the components captured by isoDurationPattern.
+var isoDurationUnits = []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+
+// parseDurationValue parses a duration given either as a Go duration, such as
+// "1h30m", or as an ISO 8601 duration, such as "PT1H30M".
+func parseDurationValue(value string) (time.Duration, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return duration, nil
+	}
+	if trimmed := strings.TrimLeft(value, "+-"); strings.HasPrefix(trimmed, "P") || strings.HasPrefix(trimmed, "p") {
+		return parseISODuration(value)
+	}
+	return 0, fmt.Errorf("%q is neither a duration such as 30s nor an ISO 8601 duration such as PT30S", value)
+}
+
+// parseISODuration parses an ISO 8601 duration, such as "PT30S" or "P1DT12H".
+// Years and months are rejected, as they do not have a fixed length.
+func parseISODuration(value string) (time.Duration, error) {
+	upper := strings.ToUpper(value)
+	match := isoDurationPattern.FindStringSubmatch(upper)
+	if match == nil {
+		datePart, _, _ := strings.Cut(upper, "T")
+		if strings.ContainsAny(datePart, "YM") {
+			return 0, fmt.Errorf("ISO 8601 duration %q: years and months are not supported, use weeks or days", value)
+		}
+		return 0, fmt.Errorf("ISO 8601 duration %q is invalid, expected a duration such as PT30S, PT1H30M or P1DT12H", value)
+	}
+
+	components := match[2:]
+	last := -1
+	for i, component := range components {
+		if component != "" {
+			last = i
+		}
+	}
+	if last < 0 || strings.HasSuffix(upper, "T") {
+		return 0, fmt.Errorf("ISO 8601 duration %q has no components", value)
+	}
+
+	total := 0.0
+	for i, component := range components {
+		if component == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.Replace(component, ",", ".", 1), 64)
+		if err != nil || (i != last && strings.ContainsAny(component, ".,")) {
+			return 0, fmt.Errorf("ISO 8601 duration %q: invalid number %q", value, component)
+		}
+		total += n * float64(isoDurationUnits[i])
+	}
+	if total >= math.MaxInt64 {
+		return 0, fmt.Errorf("ISO 8601 duration %q is too long", value)
+	}
+	if match[1] == "-" {
+		total = -total
+	}
+	return time.Duration(math.Round(total)), nil
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDurationValue(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr string
+	}{
+		{value: "30s", want: 30 * time.Second},
+		{value: "1h30m", want: 90 * time.Minute},
+		{value: "PT30S", want: 30 * time.Second},
+		{value: "pt1h30m", want: 90 * time.Minute},
+		{value: "PT0.5S", want: 500 * time.Millisecond},
+		{value: "PT1,5M", want: 90 * time.Second},
+		{value: "P1DT12H", want: 36 * time.Hour},
+		{value: "P2W", want: 14 * 24 * time.Hour},
+		{value: "-PT5S", want: -5 * time.Second},
+		{value: "P1Y", wantErr: "years and months are not supported"},
+		{value: "P1M", wantErr: "years and months are not supported"},
+		{value: "PT1.5H30M", wantErr: `invalid number "1.5"`},
+		{value: "P", wantErr: "has no components"},
+		{value: "P1DT", wantErr: "has no components"},
+		{value: "PT30", wantErr: "is invalid"},
+		{value: "P100000000W", wantErr: "is too long"},
+		{value: "soon", wantErr: `"soon" is neither a duration such as 30s nor an ISO 8601 duration`},
+		{value: "", wantErr: "is neither"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseDurationValue(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseDurationValue(%q) error = %v, want an error containing %q", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("parseDurationValue(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+			}
+		})
+	}
+}
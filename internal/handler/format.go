@@ -0,0 +1,426 @@
+package handler
+
+import (
+	"upfcc/internal/aggregator"
+	"upfcc/internal/types"
+
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// format is an encoding of analysis results that clients can ask for.
+type format struct {
+	name        string   // name is the value of the 'format' query parameter selecting the format.
+	contentType string   // contentType is the Content-Type of the responses.
+	mediaTypes  []string // mediaTypes are the media types of the Accept header selecting the format.
+	top         bool     // top reports whether the format holds the top posts, omitted by the other formats.
+	stream      bool     // stream reports whether the analysis is streamed as Server-Sent Events by streamAnalysis.
+	write       func(h *Handler, w http.ResponseWriter, query aggregator.Query, result aggregator.AnalysisResult)
+}
+
+// omittedHeader is the header listing the fields of the result that were asked
+// for but cannot be encoded in the format of the response.
+const omittedHeader = "X-Omitted-Fields"
+
+// formats lists the supported formats. A wildcard Accept header selects the first one.
+// The event stream has no name and is only selected by its own media type.
+var formats = []format{
+	{name: "json", contentType: "application/json", mediaTypes: []string{"application/json"}, top: true, write: writeJSONFormat},
+	{name: "csv", contentType: "text/csv; charset=utf-8", mediaTypes: []string{"text/csv"}, write: writeCSVFormat},
+	{name: "ndjson", contentType: "application/x-ndjson", mediaTypes: []string{"application/x-ndjson", "application/ndjson"}, write: writeNDJSONFormat},
+	{name: "text", contentType: "text/plain; version=0.0.4; charset=utf-8", mediaTypes: []string{"text/plain"}, write: writeTextFormat},
+	{contentType: "text/event-stream", mediaTypes: []string{"text/event-stream"}, stream: true},
+}
+
+// errNotAcceptable is returned by parseFormat when no supported format is acceptable.
+var errNotAcceptable = errors.New("not acceptable")
+
+// parseFormat selects the format of the response, from the optional 'format'
+// query parameter, or else from the Accept header. Without either, results are
+// encoded as JSON. If no supported format is acceptable, it writes a 406 Not
+// Acceptable response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+//
+// Returns:
+//   - The selected format.
+//   - An error if no supported format is acceptable.
+func (h *Handler) parseFormat(w http.ResponseWriter, r *http.Request) (format, error) {
+	query := r.URL.Query()
+	if query.Has("format") {
+		name := query.Get("format")
+		for _, f := range formats {
+			if f.name == name && !f.stream {
+				return f, nil
+			}
+		}
+		http.Error(w, "Not acceptable: unsupported format "+strconv.Quote(name)+", expected one of json, csv, ndjson or text", http.StatusNotAcceptable)
+		return format{}, errNotAcceptable
+	}
+
+	f, ok := negotiateFormat(r.Header.Values("Accept"))
+	if !ok {
+		http.Error(w, "Not acceptable: "+strings.Join(r.Header.Values("Accept"), ", ")+
+			", expected application/json, text/csv, application/x-ndjson, text/plain or text/event-stream", http.StatusNotAcceptable)
+		return format{}, errNotAcceptable
+	}
+	return f, nil
+}
+
+// negotiateFormat selects the supported format with the highest quality in the
+// given Accept headers, preferring the one listed first among equal qualities.
+// The quality of a format is given by the most specific media range matching
+// it, so that "application/json;q=0, */*" excludes JSON while accepting any
+// other format. Invalid media ranges are ignored.
+//
+// Parameters:
+//   - accept: The values of the Accept headers of the request.
+//
+// Returns:
+//   - The selected format, JSON if there is no Accept header.
+//   - false if no supported format is acceptable.
+func negotiateFormat(accept []string) (format, bool) {
+	if len(accept) == 0 {
+		return formats[0], true
+	}
+
+	var ranges []acceptRange
+	for _, mediaRange := range strings.Split(strings.Join(accept, ","), ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality, position: len(ranges)})
+	}
+
+	var best format
+	var bestRange acceptRange
+	for _, f := range formats {
+		r, ok := matchFormat(f, ranges)
+		if ok && r.quality > 0 && (r.quality > bestRange.quality || r.quality == bestRange.quality && r.position < bestRange.position) {
+			best, bestRange = f, r
+		}
+	}
+	return best, bestRange.quality > 0
+}
+
+// acceptRange is a media range of an Accept header.
+type acceptRange struct {
+	mediaType string
+	quality   float64
+	position  int // position is the index of the range in the Accept header.
+}
+
+// matchFormat returns the most specific media range matching a format: a media
+// type of the format, then its type with any subtype, then any media type.
+// Among equally specific ranges, the first one listed is returned. Streamed
+// formats are only matched by their media types, never by wildcards.
+func matchFormat(f format, ranges []acceptRange) (acceptRange, bool) {
+	best, bestSpecificity := acceptRange{}, -1
+	for _, r := range ranges {
+		for _, mediaType := range f.mediaTypes {
+			kind, _, _ := strings.Cut(mediaType, "/")
+			specificity := -1
+			switch {
+			case r.mediaType == mediaType:
+				specificity = 2
+			case f.stream:
+			case r.mediaType == kind+"/*":
+				specificity = 1
+			case r.mediaType == "*/*":
+				specificity = 0
+			}
+			if specificity > bestSpecificity {
+				best, bestSpecificity = r, specificity
+			}
+		}
+	}
+	return best, bestSpecificity >= 0
+}
+
+// scope is a part of an analysis result: the overall roll-up, a group or a time
+// bucket, with the values of each of its dimensions.
+type scope struct {
+	group        string
+	start        time.Time // start is the start of the bucket, zero outside buckets.
+	end          time.Time // end is the end of the bucket, zero outside buckets.
+	totalPosts   int
+	minTimestamp *int64 // minTimestamp is the timestamp of the first post, nil for buckets.
+	maxTimestamp *int64 // maxTimestamp is the timestamp of the last post, nil for buckets.
+	values       []dimensionValue
+}
+
+// dimensionValue holds the values of a dimension in a scope.
+type dimensionValue struct {
+	dimension types.Dimension
+	avgValue  float64
+	stats     map[types.Statistic]float64
+}
+
+// flattenResult splits a result into its scopes: the overall roll-up, then
+// its buckets in chronological order, then its groups in alphabetical order,
+// each followed by its own buckets.
+//
+// Parameters:
+//   - query: The query of the analysis, whose dimensions are reported in order.
+//   - result: The result of the analysis.
+//
+// Returns:
+//   - The scopes of the result.
+func flattenResult(query aggregator.Query, result aggregator.AnalysisResult) []scope {
+	var scopes []scope
+	var add func(group string, result aggregator.AnalysisResult)
+	add = func(group string, result aggregator.AnalysisResult) {
+		scopes = append(scopes, scope{
+			group:        group,
+			totalPosts:   result.TotalPosts,
+			minTimestamp: &result.MinTimestamp,
+			maxTimestamp: &result.MaxTimestamp,
+			values:       dimensionValues(query, result.AvgValue, result.Stats, result.Dimensions),
+		})
+		for _, bucket := range result.Buckets {
+			scopes = append(scopes, scope{
+				group:      group,
+				start:      bucket.Start,
+				end:        bucket.End,
+				totalPosts: bucket.TotalPosts,
+				values:     dimensionValues(query, bucket.AvgValue, bucket.Stats, bucket.Dimensions),
+			})
+		}
+	}
+
+	add("", result)
+	groups := make([]string, 0, len(result.Groups))
+	for group := range result.Groups {
+		groups = append(groups, group)
+	}
+	slices.Sort(groups)
+	for _, group := range groups {
+		add(group, result.Groups[group])
+	}
+	return scopes
+}
+
+// dimensionValues returns the values of the dimensions of the query, read from
+// the top level of a single-dimension result, or per dimension otherwise.
+func dimensionValues(query aggregator.Query, avgValue float64, stats map[types.Statistic]float64, dimensions map[types.Dimension]aggregator.DimensionResult) []dimensionValue {
+	if len(query.Dimensions) == 1 {
+		return []dimensionValue{{dimension: query.Dimensions[0].Name, avgValue: avgValue, stats: stats}}
+	}
+	values := make([]dimensionValue, 0, len(query.Dimensions))
+	for _, d := range query.Dimensions {
+		if result, ok := dimensions[d.Name]; ok {
+			values = append(values, dimensionValue{dimension: d.Name, avgValue: result.AvgValue, stats: result.Stats})
+		}
+	}
+	return values
+}
+
+// writeJSONFormat writes the result as a JSON document.
+func writeJSONFormat(h *Handler, w http.ResponseWriter, query aggregator.Query, result aggregator.AnalysisResult) {
+	h.writeJSONResponse(w, result)
+}
+
+// writeCSVFormat writes the result as CSV, with a header row and one row per
+// scope and dimension. The cells of the fields a scope does not have are empty.
+// The last column tells whether the result is truncated, the same in every row.
+func writeCSVFormat(h *Handler, w http.ResponseWriter, query aggregator.Query, result aggregator.AnalysisResult) {
+	header := []string{"group", "bucket_start", "bucket_end", "dimension", "total_posts", "minimum_timestamp", "maximum_timestamp", "avg_value"}
+	for _, statistic := range query.Stats {
+		header = append(header, string(statistic))
+	}
+	header = append(header, "truncated")
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for _, s := range flattenResult(query, result) {
+		for _, value := range s.values {
+			row := []string{
+				s.group,
+				formatTime(s.start),
+				formatTime(s.end),
+				string(value.dimension),
+				strconv.Itoa(s.totalPosts),
+				formatTimestamp(s.minTimestamp),
+				formatTimestamp(s.maxTimestamp),
+				formatValue(value.avgValue),
+			}
+			for _, statistic := range query.Stats {
+				row = append(row, formatValue(value.stats[statistic]))
+			}
+			row = append(row, strconv.FormatBool(result.Truncated))
+			cw.Write(row)
+		}
+	}
+	cw.Flush()
+}
+
+// ndjsonRow is a line of a result encoded as NDJSON.
+type ndjsonRow struct {
+	Group        string                      `json:"group,omitempty"`
+	BucketStart  *time.Time                  `json:"bucket_start,omitempty"`
+	BucketEnd    *time.Time                  `json:"bucket_end,omitempty"`
+	Dimension    types.Dimension             `json:"dimension"`
+	TotalPosts   int                         `json:"total_posts"`
+	MinTimestamp *int64                      `json:"minimum_timestamp,omitempty"`
+	MaxTimestamp *int64                      `json:"maximum_timestamp,omitempty"`
+	AvgValue     float64                     `json:"avg_value"`
+	Stats        map[types.Statistic]float64 `json:"stats,omitempty"`
+	Truncated    bool                        `json:"truncated,omitempty"`
+}
+
+// writeNDJSONFormat writes the result as NDJSON, with one JSON object per line
+// for each scope and dimension.
+func writeNDJSONFormat(h *Handler, w http.ResponseWriter, query aggregator.Query, result aggregator.AnalysisResult) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, s := range flattenResult(query, result) {
+		for _, value := range s.values {
+			row := ndjsonRow{
+				Group:        s.group,
+				Dimension:    value.dimension,
+				TotalPosts:   s.totalPosts,
+				MinTimestamp: s.minTimestamp,
+				MaxTimestamp: s.maxTimestamp,
+				AvgValue:     value.avgValue,
+				Stats:        value.stats,
+				Truncated:    result.Truncated,
+			}
+			if !s.start.IsZero() {
+				row.BucketStart, row.BucketEnd = &s.start, &s.end
+			}
+			enc.Encode(row)
+		}
+	}
+}
+
+// textMetric is a metric of a result encoded in the Prometheus text format.
+type textMetric struct {
+	name    string
+	help    string
+	samples func(s scope, write func(labels []string, value float64))
+}
+
+// textMetrics lists the metrics of a result encoded in the Prometheus text format.
+var textMetrics = []textMetric{
+	{"upfcc_analysis_posts", "Number of posts analyzed.", func(s scope, write func([]string, float64)) {
+		write(nil, float64(s.totalPosts))
+	}},
+	{"upfcc_analysis_minimum_timestamp_seconds", "Timestamp of the first post analyzed.", func(s scope, write func([]string, float64)) {
+		if s.minTimestamp != nil {
+			write(nil, float64(*s.minTimestamp))
+		}
+	}},
+	{"upfcc_analysis_maximum_timestamp_seconds", "Timestamp of the last post analyzed.", func(s scope, write func([]string, float64)) {
+		if s.maxTimestamp != nil {
+			write(nil, float64(*s.maxTimestamp))
+		}
+	}},
+	{"upfcc_analysis_avg_value", "Average value of the dimension.", func(s scope, write func([]string, float64)) {
+		for _, value := range s.values {
+			write([]string{"dimension", string(value.dimension)}, value.avgValue)
+		}
+	}},
+	{"upfcc_analysis_statistic", "Requested statistic of the dimension.", func(s scope, write func([]string, float64)) {
+		for _, value := range s.values {
+			statistics := make([]types.Statistic, 0, len(value.stats))
+			for statistic := range value.stats {
+				statistics = append(statistics, statistic)
+			}
+			slices.Sort(statistics)
+			for _, statistic := range statistics {
+				write([]string{"dimension", string(value.dimension), "statistic", string(statistic)}, value.stats[statistic])
+			}
+		}
+	}},
+}
+
+// labelValueReplacer escapes the label values of the Prometheus text format.
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// writeTextFormat writes the result as plain text in the Prometheus exposition
+// format, one gauge per field, labelled by group, bucket, dimension and statistic.
+func writeTextFormat(h *Handler, w http.ResponseWriter, query aggregator.Query, result aggregator.AnalysisResult) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	scopes := flattenResult(query, result)
+	for _, metric := range textMetrics {
+		bw.WriteString("# HELP " + metric.name + " " + metric.help + "\n")
+		bw.WriteString("# TYPE " + metric.name + " gauge\n")
+		for _, s := range scopes {
+			metric.samples(s, func(labels []string, value float64) {
+				var scopeLabels []string
+				if s.group != "" {
+					scopeLabels = append(scopeLabels, "group", s.group)
+				}
+				if !s.start.IsZero() {
+					scopeLabels = append(scopeLabels, "bucket_start", formatTime(s.start))
+				}
+				writeTextSample(bw, metric.name, append(scopeLabels, labels...), value)
+			})
+		}
+	}
+	bw.WriteString("# HELP upfcc_analysis_truncated Whether the analysis stopped before the end of its duration.\n")
+	bw.WriteString("# TYPE upfcc_analysis_truncated gauge\n")
+	truncated := 0.0
+	if result.Truncated {
+		truncated = 1
+	}
+	writeTextSample(bw, "upfcc_analysis_truncated", nil, truncated)
+	bw.Flush()
+}
+
+// writeTextSample writes one sample line, such as `name{label="value"} 1`.
+// The labels alternate names and values.
+func writeTextSample(w *bufio.Writer, name string, labels []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(labels[i] + `="` + labelValueReplacer.Replace(labels[i+1]) + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatValue(value) + "\n")
+}
+
+// formatValue formats a value without exponent nor trailing zeros.
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// formatTime formats a bucket bound in RFC 3339 format, or as an empty string if it is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// formatTimestamp formats a post timestamp, or as an empty string if it is nil.
+func formatTimestamp(timestamp *int64) string {
+	if timestamp == nil {
+		return ""
+	}
+	return strconv.FormatInt(*timestamp, 10)
+}
@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"upfcc/internal/aggregator"
+	"upfcc/internal/types"
+
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalysisHandlerFormats(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	result := aggregator.AnalysisResult{
+		TotalPosts: 3, MinTimestamp: 100, MaxTimestamp: 200, AvgValue: 12.5,
+		Stats: map[types.Statistic]float64{types.P50: 10},
+		Groups: map[string]aggregator.AnalysisResult{
+			"tweet": {TotalPosts: 2, MinTimestamp: 100, MaxTimestamp: 150, AvgValue: 15, Stats: map[types.Statistic]float64{types.P50: 15}},
+		},
+		Buckets: []aggregator.Bucket{
+			{Start: start, End: start.Add(time.Minute), TotalPosts: 3, AvgValue: 12.5, Stats: map[types.Statistic]float64{types.P50: 10}},
+		},
+	}
+	multiResult := aggregator.AnalysisResult{
+		TotalPosts: 3, MinTimestamp: 100, MaxTimestamp: 200, Truncated: true,
+		Dimensions: map[types.Dimension]aggregator.DimensionResult{types.Likes: {AvgValue: 12.5}, types.Comments: {AvgValue: 2}},
+	}
+	const target = "/analysis?duration=5m&dimension=likes&stats=p50&group_by=type&interval=1m"
+
+	tests := []struct {
+		name            string
+		target          string
+		accept          string
+		result          aggregator.AnalysisResult
+		wantStatus      int
+		wantContentType string
+		wantBody        string // wantBody is the whole body, or a part of it for the text format.
+		wantOmitted     string
+	}{
+		{
+			name:            "DefaultJSON",
+			target:          "/analysis?duration=5m&dimension=likes",
+			result:          aggregator.AnalysisResult{TotalPosts: 3, AvgValue: 12.5},
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `{"total_posts":3,"minimum_timestamp":0,"maximum_timestamp":0,"avg_value":12.5}`,
+		},
+		{
+			name:            "CSVParameter",
+			target:          target + "&format=csv",
+			result:          result,
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/csv; charset=utf-8",
+			wantBody: "group,bucket_start,bucket_end,dimension,total_posts,minimum_timestamp,maximum_timestamp,avg_value,p50,truncated\n" +
+				",,,likes,3,100,200,12.5,10,false\n" +
+				",2024-05-01T10:00:00Z,2024-05-01T10:01:00Z,likes,3,,,12.5,10,false\n" +
+				"tweet,,,likes,2,100,150,15,15,false",
+		},
+		{
+			name:            "CSVAccept",
+			target:          "/analysis?duration=5m&dimension=likes,comments",
+			accept:          "text/csv",
+			result:          multiResult,
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/csv; charset=utf-8",
+			wantBody: "group,bucket_start,bucket_end,dimension,total_posts,minimum_timestamp,maximum_timestamp,avg_value,truncated\n" +
+				",,,likes,3,100,200,12.5,true\n" +
+				",,,comments,3,100,200,2,true",
+		},
+		{
+			name:            "NDJSON",
+			target:          target,
+			accept:          "application/x-ndjson",
+			result:          result,
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantBody: `{"dimension":"likes","total_posts":3,"minimum_timestamp":100,"maximum_timestamp":200,"avg_value":12.5,"stats":{"p50":10}}` + "\n" +
+				`{"bucket_start":"2024-05-01T10:00:00Z","bucket_end":"2024-05-01T10:01:00Z","dimension":"likes","total_posts":3,"avg_value":12.5,"stats":{"p50":10}}` + "\n" +
+				`{"group":"tweet","dimension":"likes","total_posts":2,"minimum_timestamp":100,"maximum_timestamp":150,"avg_value":15,"stats":{"p50":15}}`,
+		},
+		{
+			name:            "Text",
+			target:          target,
+			accept:          "application/openmetrics-text;q=0.5, text/plain;version=0.0.4;q=0.4, */*;q=0.1",
+			result:          result,
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/plain; version=0.0.4; charset=utf-8",
+			wantBody: "# TYPE upfcc_analysis_posts gauge\n" +
+				"upfcc_analysis_posts 3\n" +
+				"upfcc_analysis_posts{bucket_start=\"2024-05-01T10:00:00Z\"} 3\n" +
+				"upfcc_analysis_posts{group=\"tweet\"} 2\n",
+		},
+		{
+			name:            "TextStatistics",
+			target:          target + "&format=text",
+			result:          result,
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/plain; version=0.0.4; charset=utf-8",
+			wantBody:        "upfcc_analysis_statistic{group=\"tweet\",dimension=\"likes\",statistic=\"p50\"} 15\n",
+		},
+		{
+			name:            "WildcardAccept",
+			target:          "/analysis?duration=5m&dimension=likes",
+			accept:          "text/html, */*;q=0.8",
+			result:          aggregator.AnalysisResult{TotalPosts: 3, AvgValue: 12.5},
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `{"total_posts":3,"minimum_timestamp":0,"maximum_timestamp":0,"avg_value":12.5}`,
+		},
+		{
+			name:            "ExcludedEventStream",
+			target:          "/analysis?duration=5m&dimension=likes",
+			accept:          "text/event-stream;q=0, application/json",
+			result:          aggregator.AnalysisResult{TotalPosts: 3, AvgValue: 12.5},
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `{"total_posts":3,"minimum_timestamp":0,"maximum_timestamp":0,"avg_value":12.5}`,
+		},
+		{
+			name:            "PreferredJSONOverEventStream",
+			target:          "/analysis?duration=5m&dimension=likes",
+			accept:          "application/json, text/event-stream;q=0.1",
+			result:          aggregator.AnalysisResult{TotalPosts: 3, AvgValue: 12.5},
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `{"total_posts":3,"minimum_timestamp":0,"maximum_timestamp":0,"avg_value":12.5}`,
+		},
+		{
+			name:            "ExcludedJSON",
+			target:          "/analysis?duration=5m&dimension=likes",
+			accept:          "application/json;q=0, */*",
+			result:          aggregator.AnalysisResult{TotalPosts: 3, AvgValue: 12.5},
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/csv; charset=utf-8",
+			wantBody: "group,bucket_start,bucket_end,dimension,total_posts,minimum_timestamp,maximum_timestamp,avg_value,truncated\n" +
+				",,,likes,3,0,0,12.5,false",
+		},
+		{
+			name:            "TopOmitted",
+			target:          "/analysis?duration=5m&dimension=likes&top=1&format=ndjson",
+			result:          aggregator.AnalysisResult{TotalPosts: 3, AvgValue: 12.5, Top: []aggregator.TopPost{{Type: "tweet"}}},
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantBody:        `{"dimension":"likes","total_posts":3,"minimum_timestamp":0,"maximum_timestamp":0,"avg_value":12.5}`,
+			wantOmitted:     "top",
+		},
+		{
+			name:       "UnsupportedAccept",
+			target:     "/analysis?duration=5m&dimension=likes",
+			accept:     "application/xml",
+			wantStatus: http.StatusNotAcceptable,
+		},
+		{
+			name:       "UnsupportedFormat",
+			target:     "/analysis?duration=5m&dimension=likes&format=xml",
+			wantStatus: http.StatusNotAcceptable,
+		},
+		{
+			name:       "RefusedJSON",
+			target:     "/analysis?duration=5m&dimension=likes",
+			accept:     "application/json;q=0",
+			wantStatus: http.StatusNotAcceptable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := New(nil, &MockAggregator{result: tt.result})
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+
+			handler.AnalysisHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if contentType := rr.Header().Get("Content-Type"); contentType != tt.wantContentType {
+				t.Errorf("handler returned Content-Type %q, want %q", contentType, tt.wantContentType)
+			}
+			if omitted := rr.Header().Get("X-Omitted-Fields"); omitted != tt.wantOmitted {
+				t.Errorf("handler returned X-Omitted-Fields %q, want %q", omitted, tt.wantOmitted)
+			}
+			body := rr.Body.String()
+			if strings.HasPrefix(tt.wantContentType, "text/plain") {
+				if !strings.Contains(body, tt.wantBody) {
+					t.Errorf("handler returned:\n%s\nwant it to contain:\n%s", body, tt.wantBody)
+				}
+			} else if strings.TrimSpace(body) != tt.wantBody {
+				t.Errorf("handler returned:\n%s\nwant:\n%s", body, tt.wantBody)
+			}
+		})
+	}
+}
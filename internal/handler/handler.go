@@ -5,71 +5,197 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
 	"time"
 	"upfcc/internal/aggregator"
+	"upfcc/internal/dimension"
+	"upfcc/internal/filter"
+	"upfcc/internal/jobs"
+	"upfcc/internal/ratelimit"
 	"upfcc/internal/sseclient"
 	"upfcc/internal/types"
+	"upfcc/internal/webhook"
 )
 
 // Aggregator is an interface that defines the methods required
 // for aggregating social media posts data.
 type Aggregator interface {
-	AggregateData(duration time.Duration, dimension types.Dimension, resultChan chan aggregator.AnalysisResult)
+	AggregateData(ctx context.Context, query aggregator.Query, resultChan chan aggregator.AnalysisResult)
 }
 
-// SSEClientInterface defines the interface for an SSE client that reads a stream of posts.
+// SSEClientInterface defines the interface for an SSE client that reads a stream of posts
+// and reports the health of its upstream connection.
 type SSEClientInterface interface {
-	ReadStream(duration time.Duration) chan sseclient.Post
+	ReadStream(ctx context.Context, duration time.Duration) chan sseclient.Post
+	Status() sseclient.Status
 }
 
 // Handler is responsible for handling HTTP requests and using the aggregator to process data.
 type Handler struct {
-	aggregator Aggregator
+	sseClient       SSEClientInterface
+	aggregator      Aggregator
+	maxDuration     time.Duration       // maxDuration is the longest analysis duration accepted, 0 for no limit.
+	minDuration     time.Duration       // minDuration is the shortest analysis duration accepted.
+	readinessWindow time.Duration       // readinessWindow is the longest time without upstream events before the service is not ready.
+	dimensions      *dimension.Registry // dimensions are the dimensions that can be analyzed.
+	jobs            *jobs.Registry      // jobs tracks the analyses run in the background.
+	callbacks       *webhook.Dispatcher // callbacks delivers results to callback URLs, nil if callbacks are not enabled.
+	limiter         *ratelimit.Limiter  // limiter limits the analyses of each client and running at once, nil for no limit.
+	apiKeys         map[string]bool     // apiKeys are the API keys identifying clients for rate limiting, other keys being ignored.
+	trustedProxies  []netip.Prefix      // trustedProxies are the proxies whose X-Forwarded-For header identifies clients.
+
+	history          bool          // history reports whether historical analyses are enabled.
+	historyRetention time.Duration // historyRetention is how far back the history goes, 0 for no limit.
 }
 
 // New creates a new Handler with the provided SSE client.
 //
 // Parameters:
 //   - sseClient: An instance of SSEClientInterface to read the stream of posts.
+//   - aggregator: An instance of Aggregator to process the posts.
+//   - opts: Options configuring optional behavior, such as WithMaxDuration or WithReadinessWindow.
 //
 // Returns:
 //   - A pointer to the newly created Handler.
-func New(sseClient SSEClientInterface, aggregator Aggregator) *Handler {
-	return &Handler{
-		aggregator: aggregator,
+func New(sseClient SSEClientInterface, aggregator Aggregator, opts ...Option) *Handler {
+	h := &Handler{
+		sseClient:       sseClient,
+		aggregator:      aggregator,
+		readinessWindow: defaultReadinessWindow,
+		dimensions:      dimension.Default(),
+		jobs:            jobs.NewRegistry(jobs.Options{MaxJobs: defaultMaxJobs, Retention: defaultJobRetention}),
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
 // AnalysisHandler handles HTTP requests for analyzing social media posts data.
-// It reads the 'duration' and 'dimension' query parameters from the URL, validates them,
-// and uses the aggregator to process the data. The results are then returned as a JSON response.
+// It reads the 'duration' (or 'until'), 'dimension' and optional 'stats', 'group_by', 'filter', 'top', 'interval' and 'align' query parameters from the URL, validates them,
+// and uses the aggregator to process the data. The results are then returned as JSON, or as CSV, NDJSON
+// or Prometheus text when asked for by the 'format' query parameter or the Accept header.
+// Instead of 'duration', past posts can be analyzed from the history with 'from' and optional 'to', or with 'last'.
+// Several dimensions can be analyzed over the same posts by separating them with commas, or all of them with "*".
+// Requests whose Accept header prefers "text/event-stream" are streamed as by AnalysisStreamHandler instead.
+// Requests with a 'callback_url' are run as a job whose result is delivered to the URL,
+// and are answered at once with a 202 Accepted holding the job.
+// If rate limiting is enabled, requests over the limit of their client, or
+// beyond the maximum number of running analyses, get a 429 Too Many Requests.
 //
 // Parameters:
 //   - w: An http.ResponseWriter to write the HTTP response.
 //   - r: An http.Request representing the HTTP request.
 func (h *Handler) AnalysisHandler(w http.ResponseWriter, r *http.Request) {
-	duration, err := h.parseDuration(w, r)
+	if f, ok := negotiateFormat(r.Header.Values("Accept")); ok && f.stream && !r.URL.Query().Has("format") {
+		h.streamAnalysis(w, r)
+		return
+	}
+	if r.URL.Query().Has("callback_url") {
+		h.startJob(w, r)
+		return
+	}
+
+	responseFormat, err := h.parseFormat(w, r)
 	if err != nil {
 		return
 	}
 
-	dimension, err := h.parseDimension(w, r)
+	query, err := h.parseQuery(w, r)
 	if err != nil {
 		return
 	}
 
-	resultChan := make(chan aggregator.AnalysisResult)
-	go h.aggregator.AggregateData(duration, dimension, resultChan)
+	if !h.admit(w, r) {
+		return
+	}
+	defer h.release()
+
+	// The aggregation is bound to the request context, so that it stops as soon as
+	// the client disconnects, the server shuts down or a deadline is reached.
+	resultChan := make(chan aggregator.AnalysisResult, 1)
+	go h.aggregate(r.Context(), query, resultChan)
 
 	result := <-resultChan
-	h.writeJSONResponse(w, result)
+	if query.Top > 0 && !responseFormat.top {
+		w.Header().Set(omittedHeader, "top")
+	}
+	responseFormat.write(h, w, query, result)
 }
 
-// parseDuration reads and parses the 'duration' query parameter from the URL.
-// If the parameter is missing or invalid, it writes an HTTP error response.
+// parseQuery reads and validates the query parameters of an analysis request.
+// If a parameter is missing or invalid, it writes an HTTP error response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+//
+// Returns:
+//   - The aggregator.Query described by the request.
+//   - An error if a parameter is missing or invalid.
+func (h *Handler) parseQuery(w http.ResponseWriter, r *http.Request) (aggregator.Query, error) {
+	window, err := h.parseWindow(w, r)
+	if err != nil {
+		return aggregator.Query{}, err
+	}
+
+	dimensions, registry, err := h.parseDimensions(w, r)
+	if err != nil {
+		return aggregator.Query{}, err
+	}
+
+	stats, err := h.parseStats(w, r)
+	if err != nil {
+		return aggregator.Query{}, err
+	}
+
+	groupBy, err := h.parseGroupBy(w, r)
+	if err != nil {
+		return aggregator.Query{}, err
+	}
+
+	interval, alignment, err := h.parseInterval(w, r, window.duration)
+	if err != nil {
+		return aggregator.Query{}, err
+	}
+
+	predicate, err := h.parseFilter(w, r, registry)
+	if err != nil {
+		return aggregator.Query{}, err
+	}
+
+	top, err := h.parseTop(w, r)
+	if err != nil {
+		return aggregator.Query{}, err
+	}
+
+	return aggregator.Query{
+		Duration:   window.duration,
+		Dimensions: dimensions,
+		Stats:      stats,
+		GroupBy:    groupBy,
+		Filter:     predicate,
+		Top:        top,
+		Interval:   interval,
+		Alignment:  alignment,
+		From:       window.from,
+		To:         window.to,
+	}, nil
+}
+
+// parseDuration reads the duration of a live analysis from the URL: either the
+// 'duration' query parameter, as a Go duration such as "30s" or an ISO 8601
+// duration such as "PT30S", or the 'until' parameter, the time the analysis
+// ends at, in RFC 3339 format or as a Unix timestamp. The duration must be
+// positive and within the bounds of the handler.
+// If the parameters are missing, invalid or out of bounds, it writes an HTTP error response.
 //
 // Parameters:
 //   - w: An http.ResponseWriter to write the HTTP response.
@@ -79,8 +205,7 @@ func (h *Handler) AnalysisHandler(w http.ResponseWriter, r *http.Request) {
 //   - A time.Duration value if parsing is successful.
 //   - An error if parsing fails.
 func (h *Handler) parseDuration(w http.ResponseWriter, r *http.Request) (time.Duration, error) {
-	durationStr := r.URL.Query().Get("duration")
-	duration, err := time.ParseDuration(durationStr)
+	duration, err := h.readDuration(r, time.Now())
 	if err != nil {
 		http.Error(w, "Invalid duration: "+err.Error(), http.StatusBadRequest)
 		return 0, err
@@ -88,7 +213,50 @@ func (h *Handler) parseDuration(w http.ResponseWriter, r *http.Request) (time.Du
 	return duration, nil
 }
 
-// parseDimension reads and validates the 'dimension' query parameter from the URL.
+// readDuration reads the duration of a live analysis from the 'duration' or
+// 'until' query parameters, relative to now, and checks its bounds.
+func (h *Handler) readDuration(r *http.Request, now time.Time) (time.Duration, error) {
+	query := r.URL.Query()
+	var duration time.Duration
+	switch {
+	case query.Has("duration") && query.Has("until"):
+		return 0, errors.New("duration cannot be combined with until")
+	case query.Has("until"):
+		until, err := parseTime(query.Get("until"))
+		if err != nil {
+			return 0, fmt.Errorf("until: %w", err)
+		}
+		if !until.After(now) {
+			return 0, fmt.Errorf("until %s is not in the future", until.Format(time.RFC3339))
+		}
+		duration = until.Sub(now)
+	case query.Get("duration") == "":
+		return 0, errors.New("duration is required, such as 30s or PT30S, unless until is set")
+	default:
+		var err error
+		if duration, err = parseDurationValue(query.Get("duration")); err != nil {
+			return 0, err
+		}
+		if duration <= 0 {
+			return 0, fmt.Errorf("duration %v must be positive", duration)
+		}
+	}
+
+	if duration < h.minDuration {
+		return 0, fmt.Errorf("duration %v is shorter than the minimum of %v", duration, h.minDuration)
+	}
+	if h.maxDuration > 0 && duration > h.maxDuration {
+		return 0, fmt.Errorf("duration %v exceeds the maximum of %v", duration, h.maxDuration)
+	}
+	return duration, nil
+}
+
+// parseDimensions reads and validates the 'dimension' query parameter from the URL.
+// The parameter holds a single dimension, a comma-separated list of dimensions
+// such as "likes,comments", or "*" for all the dimensions. A dimension can also
+// be derived for the request with a formula, such as
+// "engagement=likes+comments*2+retweets*3", which can read the registered
+// dimensions and the derived dimensions listed before it.
 // If the parameter is missing or invalid, it writes an HTTP error response.
 //
 // Parameters:
@@ -96,16 +264,232 @@ func (h *Handler) parseDuration(w http.ResponseWriter, r *http.Request) (time.Du
 //   - r: An http.Request representing the HTTP request.
 //
 // Returns:
-//   - A types.Dimension value if the dimension is valid.
-//   - An error if the dimension is invalid.
-func (h *Handler) parseDimension(w http.ResponseWriter, r *http.Request) (types.Dimension, error) {
+//   - The requested dimensions, looked up in the registry, if they are all valid.
+//   - The registry of the dimensions available to the request, including the
+//     derived ones.
+//   - An error if a dimension is invalid.
+func (h *Handler) parseDimensions(w http.ResponseWriter, r *http.Request) ([]dimension.Dimension, *dimension.Registry, error) {
 	dimensionStr := r.URL.Query().Get("dimension")
-	dimension := types.Dimension(dimensionStr)
-	if !types.IsValidDimension(dimension) {
-		http.Error(w, "Invalid dimension: "+dimensionStr, http.StatusBadRequest)
-		return "", errors.New("invalid dimension")
+	if dimensionStr == "*" {
+		return h.dimensions.All(), h.dimensions, nil
+	}
+
+	registry := h.dimensions
+	var dimensions []dimension.Dimension
+	seen := make(map[types.Dimension]bool)
+	for _, item := range strings.Split(dimensionStr, ",") {
+		name, expr, derived := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if derived {
+			if registry == h.dimensions {
+				registry = h.dimensions.Clone()
+			}
+			if err := deriveDimension(registry, types.Dimension(name), expr); err != nil {
+				message := "Invalid dimension: " + name + ": " + err.Error()
+				if strings.Contains(r.URL.RawQuery, "+") {
+					message += ` (a "+" in a URL must be encoded as %2B)`
+				}
+				http.Error(w, message, http.StatusBadRequest)
+				return nil, nil, err
+			}
+		}
+
+		d, ok := registry.Lookup(types.Dimension(name))
+		if !ok {
+			http.Error(w, "Invalid dimension: "+name, http.StatusBadRequest)
+			return nil, nil, errors.New("invalid dimension")
+		}
+		if !seen[d.Name] {
+			seen[d.Name] = true
+			dimensions = append(dimensions, d)
+		}
+	}
+	return dimensions, registry, nil
+}
+
+// deriveDimension parses the formula of a derived dimension and registers the
+// dimension.
+//
+// Parameters:
+//   - registry: The registry the formula reads from and the dimension is registered to.
+//   - name: The name of the derived dimension.
+//   - expr: The formula of the derived dimension.
+//
+// Returns:
+//   - An error if the formula is invalid or the name is already registered.
+func deriveDimension(registry *dimension.Registry, name types.Dimension, expr string) error {
+	formula, err := dimension.ParseFormula(expr, registry)
+	if err != nil {
+		return err
+	}
+	return registry.Register(dimension.FromFormula(name, formula))
+}
+
+// parseStats reads and validates the optional 'stats' query parameter from the URL,
+// a comma-separated list of statistics such as "p50,p99,stddev".
+// If a statistic is invalid, it writes an HTTP error response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+//
+// Returns:
+//   - The requested statistics, or nil if the parameter is missing.
+//   - An error if a statistic is invalid.
+func (h *Handler) parseStats(w http.ResponseWriter, r *http.Request) ([]types.Statistic, error) {
+	statsStr := r.URL.Query().Get("stats")
+	if statsStr == "" {
+		return nil, nil
+	}
+
+	var stats []types.Statistic
+	seen := make(map[types.Statistic]bool)
+	for _, name := range strings.Split(statsStr, ",") {
+		statistic := types.Statistic(strings.TrimSpace(name))
+		if !types.IsValidStatistic(statistic) {
+			http.Error(w, "Invalid statistic: "+string(statistic), http.StatusBadRequest)
+			return nil, errors.New("invalid statistic")
+		}
+		if !seen[statistic] {
+			seen[statistic] = true
+			stats = append(stats, statistic)
+		}
+	}
+	return stats, nil
+}
+
+// parseGroupBy reads and validates the optional 'group_by' query parameter from the URL.
+// If the parameter is invalid, it writes an HTTP error response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+//
+// Returns:
+//   - The requested types.GroupBy, or an empty one if the parameter is missing.
+//   - An error if the parameter is invalid.
+func (h *Handler) parseGroupBy(w http.ResponseWriter, r *http.Request) (types.GroupBy, error) {
+	groupByStr := r.URL.Query().Get("group_by")
+	if groupByStr == "" {
+		return "", nil
+	}
+
+	groupBy := types.GroupBy(groupByStr)
+	if !types.IsValidGroupBy(groupBy) {
+		http.Error(w, "Invalid group_by: "+groupByStr, http.StatusBadRequest)
+		return "", errors.New("invalid group_by")
+	}
+	return groupBy, nil
+}
+
+// parseInterval reads and validates the optional 'interval' and 'align' query
+// parameters from the URL. The interval is the duration of the buckets of a
+// time-bucketed analysis, and align selects whether they are aligned to the
+// wall clock (the default) or to the timestamps of the posts.
+// If a parameter is invalid, it writes an HTTP error response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+//   - duration: The duration of the analysis window, split into buckets.
+//
+// Returns:
+//   - The interval, or 0 if the parameter is missing, and the alignment of the buckets.
+//   - An error if a parameter is invalid.
+func (h *Handler) parseInterval(w http.ResponseWriter, r *http.Request, duration time.Duration) (time.Duration, types.Alignment, error) {
+	query := r.URL.Query()
+	if !query.Has("interval") {
+		if query.Has("align") {
+			http.Error(w, "Invalid align: align requires interval", http.StatusBadRequest)
+			return 0, "", errors.New("align without interval")
+		}
+		return 0, "", nil
+	}
+
+	alignment := types.AlignWallClock
+	if query.Has("align") {
+		alignment = types.Alignment(query.Get("align"))
+		if !types.IsValidAlignment(alignment) {
+			http.Error(w, "Invalid align: "+string(alignment), http.StatusBadRequest)
+			return 0, "", errors.New("invalid align")
+		}
+	}
+
+	interval, err := time.ParseDuration(query.Get("interval"))
+	if err == nil && interval <= 0 {
+		err = errors.New("interval must be positive")
+	}
+	if err == nil && alignment == types.AlignTimestamp && interval%time.Second != 0 {
+		err = fmt.Errorf("interval %v must be a whole number of seconds to align to post timestamps", interval)
+	}
+	if err == nil && alignment == types.AlignWallClock && duration/interval > aggregator.MaxBuckets {
+		err = fmt.Errorf("interval %v splits the %v window into more than %d buckets", interval, duration, aggregator.MaxBuckets)
+	}
+	if err != nil {
+		http.Error(w, "Invalid interval: "+err.Error(), http.StatusBadRequest)
+		return 0, "", err
+	}
+	return interval, alignment, nil
+}
+
+// parseFilter reads and compiles the optional 'filter' query parameter from the URL,
+// an expression selecting the posts to analyze such as "type = tweet and likes > 100".
+// If the expression is invalid, it writes an HTTP error response showing where
+// the problem is in the expression.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+//   - dimensions: The registry of the dimensions available to the request.
+//
+// Returns:
+//   - The predicate selecting the posts, or nil if the parameter is missing.
+//   - An error if the expression is invalid.
+func (h *Handler) parseFilter(w http.ResponseWriter, r *http.Request, dimensions *dimension.Registry) (filter.Predicate, error) {
+	query := r.URL.Query()
+	if !query.Has("filter") {
+		return nil, nil
+	}
+
+	predicate, err := filter.Parse(query.Get("filter"), dimensions)
+	if err != nil {
+		message := "Invalid filter: " + err.Error()
+		var syntaxErr *filter.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			message += "\n" + syntaxErr.Caret()
+		}
+		http.Error(w, message, http.StatusBadRequest)
+		return nil, err
+	}
+	return predicate, nil
+}
+
+// maxTop is the largest number of top posts a request can ask for.
+const maxTop = 1000
+
+// parseTop reads and validates the optional 'top' query parameter from the URL,
+// the number of posts with the largest values to report for each dimension.
+// If the parameter is invalid, it writes an HTTP error response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+//
+// Returns:
+//   - The number of top posts, or 0 if the parameter is missing.
+//   - An error if the parameter is invalid.
+func (h *Handler) parseTop(w http.ResponseWriter, r *http.Request) (int, error) {
+	query := r.URL.Query()
+	if !query.Has("top") {
+		return 0, nil
+	}
+
+	top, err := strconv.Atoi(query.Get("top"))
+	if err != nil || top < 1 || top > maxTop {
+		http.Error(w, fmt.Sprintf("Invalid top: %q must be a number between 1 and %d", query.Get("top"), maxTop), http.StatusBadRequest)
+		return 0, errors.New("invalid top")
 	}
-	return dimension, nil
+	return top, nil
 }
 
 // writeJSONResponse writes the given result as a JSON response.
@@ -2,12 +2,19 @@ package handler
 
 import (
 	"upfcc/internal/aggregator"
+	"upfcc/internal/dimension"
 	"upfcc/internal/sseclient"
+	"upfcc/internal/testingTools"
 	"upfcc/internal/types"
 
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -64,6 +71,550 @@ func TestAnalysisHandler(t *testing.T) {
 	}
 }
 
+func TestAnalysisHandlerMaxDuration(t *testing.T) {
+	tests := []struct {
+		name       string
+		duration   string
+		wantStatus int
+	}{
+		{
+			name:       "BelowMaximum",
+			duration:   "30s",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "AtMaximum",
+			duration:   "1m",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "AboveMaximum",
+			duration:   "10000h",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := New(nil, &MockAggregator{}, WithMaxDuration(time.Minute))
+
+			req := httptest.NewRequest("GET", "/analysis?duration="+tt.duration+"&dimension=likes", nil)
+			rr := httptest.NewRecorder()
+
+			handler.AnalysisHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAnalysisHandlerDuration(t *testing.T) {
+	inAMinute := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	tests := []struct {
+		name         string
+		query        string
+		wantStatus   int
+		wantError    string
+		wantDuration time.Duration // wantDuration is the duration of the query, to the second for 'until'.
+	}{
+		{name: "GoDuration", query: "duration=30s", wantStatus: http.StatusOK, wantDuration: 30 * time.Second},
+		{name: "ISODuration", query: "duration=PT1M", wantStatus: http.StatusOK, wantDuration: time.Minute},
+		{name: "Until", query: "until=" + inAMinute, wantStatus: http.StatusOK, wantDuration: time.Minute},
+		{name: "Missing", query: "", wantStatus: http.StatusBadRequest, wantError: "Invalid duration: duration is required"},
+		{name: "Zero", query: "duration=0s", wantStatus: http.StatusBadRequest, wantError: "Invalid duration: duration 0s must be positive"},
+		{name: "Negative", query: "duration=-5s", wantStatus: http.StatusBadRequest, wantError: "Invalid duration: duration -5s must be positive"},
+		{name: "NegativeISO", query: "duration=-PT5S", wantStatus: http.StatusBadRequest, wantError: "must be positive"},
+		{name: "BelowMinimum", query: "duration=500ms", wantStatus: http.StatusBadRequest, wantError: "duration 500ms is shorter than the minimum of 1s"},
+		{name: "AboveMaximum", query: "duration=10000h", wantStatus: http.StatusBadRequest, wantError: "duration 10000h0m0s exceeds the maximum of 1h0m0s"},
+		{name: "UntilAboveMaximum", query: "until=2099-01-01T00:00:00Z", wantStatus: http.StatusBadRequest, wantError: "exceeds the maximum of 1h0m0s"},
+		{name: "UntilInThePast", query: "until=2024-05-01T10:00:00Z", wantStatus: http.StatusBadRequest, wantError: "until 2024-05-01T10:00:00Z is not in the future"},
+		{name: "InvalidUntil", query: "until=tomorrow", wantStatus: http.StatusBadRequest, wantError: `until: "tomorrow" is neither`},
+		{name: "DurationAndUntil", query: "duration=30s&until=" + inAMinute, wantStatus: http.StatusBadRequest, wantError: "duration cannot be combined with until"},
+		{name: "UntilAndLast", query: "last=1h&until=" + inAMinute, wantStatus: http.StatusBadRequest, wantError: "until cannot be combined with from, to or last"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAggregator := &MockAggregator{}
+			handler := New(nil, mockAggregator, WithMinDuration(time.Second), WithMaxDuration(time.Hour), WithHistory(0))
+
+			req := httptest.NewRequest("GET", "/analysis?dimension=likes&"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			handler.AnalysisHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v (%s)", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if !strings.Contains(rr.Body.String(), tt.wantError) {
+					t.Errorf("handler returned error %q, want it to contain %q", rr.Body.String(), tt.wantError)
+				}
+				return
+			}
+			if got := mockAggregator.query.Duration; (got - tt.wantDuration).Abs() > time.Second {
+				t.Errorf("query duration = %v, want %v", got, tt.wantDuration)
+			}
+		})
+	}
+}
+
+func TestAnalysisHandlerDimensions(t *testing.T) {
+	tests := []struct {
+		name           string
+		dimension      string
+		wantStatus     int
+		wantDimensions []types.Dimension
+	}{
+		{
+			name:           "SingleDimension",
+			dimension:      "likes",
+			wantStatus:     http.StatusOK,
+			wantDimensions: []types.Dimension{types.Likes},
+		},
+		{
+			name:           "MultipleDimensions",
+			dimension:      "likes,comments, retweets,likes",
+			wantStatus:     http.StatusOK,
+			wantDimensions: []types.Dimension{types.Likes, types.Comments, types.Retweets},
+		},
+		{
+			name:           "AllDimensions",
+			dimension:      "*",
+			wantStatus:     http.StatusOK,
+			wantDimensions: []types.Dimension{types.Likes, types.Comments, types.Favorites, types.Retweets, types.Interactions, "views"},
+		},
+		{
+			name:           "ConfiguredDimension",
+			dimension:      "views,likes",
+			wantStatus:     http.StatusOK,
+			wantDimensions: []types.Dimension{"views", types.Likes},
+		},
+		{
+			name:       "InvalidDimensionInList",
+			dimension:  "likes,shares",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "EmptyDimensionInList",
+			dimension:  "likes,",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAggregator := &MockAggregator{}
+			handler := New(nil, mockAggregator, WithDimensions(registryWithViews(t)))
+
+			req := httptest.NewRequest("GET", "/analysis?duration=5s&dimension="+url.QueryEscape(tt.dimension), nil)
+			rr := httptest.NewRecorder()
+
+			handler.AnalysisHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			var names []types.Dimension
+			for _, d := range mockAggregator.query.Dimensions {
+				names = append(names, d.Name)
+			}
+			if !slices.Equal(names, tt.wantDimensions) {
+				t.Errorf("aggregator got dimensions %v, want %v", names, tt.wantDimensions)
+			}
+		})
+	}
+}
+
+func TestAnalysisHandlerDerivedDimensions(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		wantStatus     int
+		wantError      string
+		wantDimensions []types.Dimension
+	}{
+		{
+			name:           "Formula",
+			query:          "dimension=" + url.QueryEscape("likes,engagement=likes+comments*2+retweets*3"),
+			wantStatus:     http.StatusOK,
+			wantDimensions: []types.Dimension{types.Likes, "engagement"},
+		},
+		{
+			name:           "FormulaReadingDerivedDimension",
+			query:          "dimension=" + url.QueryEscape("score=interactions*2,rate=score/views"),
+			wantStatus:     http.StatusOK,
+			wantDimensions: []types.Dimension{"score", "rate"},
+		},
+		{
+			name:           "FilterOnDerivedDimension",
+			query:          "dimension=" + url.QueryEscape("engagement=likes+comments") + "&filter=" + url.QueryEscape("engagement > 10"),
+			wantStatus:     http.StatusOK,
+			wantDimensions: []types.Dimension{"engagement"},
+		},
+		{
+			name:       "UnknownField",
+			query:      "dimension=" + url.QueryEscape("engagement=likes+shares*2+plays"),
+			wantStatus: http.StatusBadRequest,
+			wantError:  `Invalid dimension: engagement: at position 16: unknown field "plays"`,
+		},
+		{
+			name:       "DivisionByZero",
+			query:      "dimension=" + url.QueryEscape("rate=likes/(1-1)"),
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid dimension: rate: at position 7: division by zero",
+		},
+		{
+			name:       "RegisteredName",
+			query:      "dimension=" + url.QueryEscape("likes=likes*2"),
+			wantStatus: http.StatusBadRequest,
+			wantError:  `Invalid dimension: likes: dimension "likes": already registered`,
+		},
+		{
+			name:       "UnencodedPlus",
+			query:      "dimension=engagement=likes+comments",
+			wantStatus: http.StatusBadRequest,
+			wantError:  `got "comments" (a "+" in a URL must be encoded as %2B)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAggregator := &MockAggregator{}
+			handler := New(nil, mockAggregator)
+
+			req := httptest.NewRequest("GET", "/analysis?duration=5s&"+tt.query, nil)
+			rr := httptest.NewRecorder()
+
+			handler.AnalysisHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if !strings.Contains(rr.Body.String(), tt.wantError) {
+				t.Errorf("handler returned %q, want an error containing %q", rr.Body.String(), tt.wantError)
+			}
+			var names []types.Dimension
+			for _, d := range mockAggregator.query.Dimensions {
+				names = append(names, d.Name)
+			}
+			if !slices.Equal(names, tt.wantDimensions) {
+				t.Errorf("aggregator got dimensions %v, want %v", names, tt.wantDimensions)
+			}
+		})
+	}
+
+	if _, ok := New(nil, &MockAggregator{}).dimensions.Lookup("engagement"); ok {
+		t.Error("a derived dimension was registered for every request")
+	}
+}
+
+func TestAnalysisHandlerStats(t *testing.T) {
+	tests := []struct {
+		name       string
+		stats      string
+		wantStatus int
+		wantStats  []types.Statistic
+	}{
+		{
+			name:       "NoStats",
+			stats:      "",
+			wantStatus: http.StatusOK,
+			wantStats:  nil,
+		},
+		{
+			name:       "ValidStats",
+			stats:      "p50,p99, stddev,p50",
+			wantStatus: http.StatusOK,
+			wantStats:  []types.Statistic{types.P50, types.P99, types.StdDev},
+		},
+		{
+			name:       "InvalidStat",
+			stats:      "p50,p42",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAggregator := &MockAggregator{}
+			handler := New(nil, mockAggregator)
+
+			req := httptest.NewRequest("GET", "/analysis?duration=5s&dimension=likes&stats="+url.QueryEscape(tt.stats), nil)
+			rr := httptest.NewRecorder()
+
+			handler.AnalysisHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if !slices.Equal(mockAggregator.query.Stats, tt.wantStats) {
+				t.Errorf("aggregator got stats %v, want %v", mockAggregator.query.Stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestAnalysisHandlerGroupBy(t *testing.T) {
+	tests := []struct {
+		name        string
+		groupBy     string
+		wantStatus  int
+		wantGroupBy types.GroupBy
+	}{
+		{
+			name:        "NoGroupBy",
+			groupBy:     "",
+			wantStatus:  http.StatusOK,
+			wantGroupBy: "",
+		},
+		{
+			name:        "GroupByType",
+			groupBy:     "type",
+			wantStatus:  http.StatusOK,
+			wantGroupBy: types.GroupByType,
+		},
+		{
+			name:       "InvalidGroupBy",
+			groupBy:    "author",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAggregator := &MockAggregator{}
+			handler := New(nil, mockAggregator)
+
+			req := httptest.NewRequest("GET", "/analysis?duration=5s&dimension=likes&group_by="+tt.groupBy, nil)
+			rr := httptest.NewRecorder()
+
+			handler.AnalysisHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if mockAggregator.query.GroupBy != tt.wantGroupBy {
+				t.Errorf("aggregator got group_by %q, want %q", mockAggregator.query.GroupBy, tt.wantGroupBy)
+			}
+		})
+	}
+}
+
+func TestAnalysisHandlerInterval(t *testing.T) {
+	tests := []struct {
+		name          string
+		params        string
+		wantStatus    int
+		wantError     string
+		wantInterval  time.Duration
+		wantAlignment types.Alignment
+	}{
+		{
+			name:       "NoInterval",
+			params:     "",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:          "WallClock",
+			params:        "&interval=10s",
+			wantStatus:    http.StatusOK,
+			wantInterval:  10 * time.Second,
+			wantAlignment: types.AlignWallClock,
+		},
+		{
+			name:          "Timestamp",
+			params:        "&interval=1m&align=timestamp",
+			wantStatus:    http.StatusOK,
+			wantInterval:  time.Minute,
+			wantAlignment: types.AlignTimestamp,
+		},
+		{
+			name:       "InvalidInterval",
+			params:     "&interval=often",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid interval",
+		},
+		{
+			name:       "NegativeInterval",
+			params:     "&interval=-10s",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "interval must be positive",
+		},
+		{
+			name:       "TooManyBuckets",
+			params:     "&interval=1ms",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "more than 10000 buckets",
+		},
+		{
+			name:       "FractionalTimestampInterval",
+			params:     "&interval=1500ms&align=timestamp",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "whole number of seconds",
+		},
+		{
+			name:       "InvalidAlign",
+			params:     "&interval=10s&align=arrival",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid align: arrival",
+		},
+		{
+			name:       "AlignWithoutInterval",
+			params:     "&align=timestamp",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "align requires interval",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAggregator := &MockAggregator{}
+			handler := New(nil, mockAggregator)
+
+			req := httptest.NewRequest("GET", "/analysis?duration=1m&dimension=likes"+tt.params, nil)
+			rr := httptest.NewRecorder()
+
+			handler.AnalysisHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rr.Body.String(), tt.wantError) {
+				t.Errorf("handler returned %q, want it to contain %q", rr.Body.String(), tt.wantError)
+			}
+			if mockAggregator.query.Interval != tt.wantInterval || mockAggregator.query.Alignment != tt.wantAlignment {
+				t.Errorf("aggregator got interval %v aligned to %q, want %v aligned to %q",
+					mockAggregator.query.Interval, mockAggregator.query.Alignment, tt.wantInterval, tt.wantAlignment)
+			}
+		})
+	}
+}
+
+func TestAnalysisHandlerFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		filter     string
+		wantStatus int
+		wantError  string
+		wantTweet  bool // wantTweet reports whether the filter selects a tweet with 150 likes.
+	}{
+		{
+			name:       "NoFilter",
+			wantStatus: http.StatusOK,
+			wantTweet:  true,
+		},
+		{
+			name:       "Selecting",
+			filter:     "&filter=" + url.QueryEscape("type in (tweet, pin) and likes > 100"),
+			wantStatus: http.StatusOK,
+			wantTweet:  true,
+		},
+		{
+			name:       "Excluding",
+			filter:     "&filter=" + url.QueryEscape("likes between 0 and 100"),
+			wantStatus: http.StatusOK,
+			wantTweet:  false,
+		},
+		{
+			name:       "Invalid",
+			filter:     "&filter=" + url.QueryEscape("type = tweet and like > 100"),
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid filter: at position 18: unknown field \"like\"",
+		},
+		{
+			name:       "Caret",
+			filter:     "&filter=" + url.QueryEscape("likes >"),
+			wantStatus: http.StatusBadRequest,
+			wantError:  "likes >\n       ^",
+		},
+	}
+
+	tweet := sseclient.Post{Type: "tweet", Data: sseclient.SocialPost{Likes: 150}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAggregator := &MockAggregator{}
+			handler := New(nil, mockAggregator)
+
+			req := httptest.NewRequest("GET", "/analysis?duration=5s&dimension=likes"+tt.filter, nil)
+			rr := httptest.NewRecorder()
+
+			handler.AnalysisHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if !strings.Contains(rr.Body.String(), tt.wantError) {
+					t.Errorf("handler returned %q, want it to contain %q", rr.Body.String(), tt.wantError)
+				}
+				return
+			}
+			predicate := mockAggregator.query.Filter
+			if selected := predicate == nil || predicate(tweet); selected != tt.wantTweet {
+				t.Errorf("aggregator filter selects the tweet: %v, want %v", selected, tt.wantTweet)
+			}
+		})
+	}
+}
+
+func TestAnalysisHandlerTop(t *testing.T) {
+	tests := []struct {
+		name       string
+		top        string
+		wantStatus int
+		wantTop    int
+	}{
+		{name: "NoTop", top: "", wantStatus: http.StatusOK, wantTop: 0},
+		{name: "Top", top: "&top=10", wantStatus: http.StatusOK, wantTop: 10},
+		{name: "Zero", top: "&top=0", wantStatus: http.StatusBadRequest},
+		{name: "TooMany", top: "&top=1001", wantStatus: http.StatusBadRequest},
+		{name: "NotANumber", top: "&top=ten", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAggregator := &MockAggregator{}
+			handler := New(nil, mockAggregator)
+
+			req := httptest.NewRequest("GET", "/analysis?duration=5s&dimension=likes"+tt.top, nil)
+			rr := httptest.NewRecorder()
+
+			handler.AnalysisHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if mockAggregator.query.Top != tt.wantTop {
+				t.Errorf("aggregator got top %d, want %d", mockAggregator.query.Top, tt.wantTop)
+			}
+		})
+	}
+}
+
+func TestAnalysisHandlerClientDisconnect(t *testing.T) {
+	defer testingTools.CheckNoGoroutineLeak(t)()
+
+	handler := New(nil, aggregator.New(&BlockingSSEClient{}))
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/analysis?duration=1h&dimension=likes", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		handler.AnalysisHandler(rr, req)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler did not return after the client disconnected")
+	}
+}
+
 func TestWriteJSONResponseError(t *testing.T) {
 	mockAggregator := &MockAggregator{
 		result: aggregator.AnalysisResult{},
@@ -81,13 +632,25 @@ func TestWriteJSONResponseError(t *testing.T) {
 
 //// helpers
 
+// registryWithViews returns a registry holding the built-in dimensions and a
+// views dimension supported by videos, as declared in a configuration.
+func registryWithViews(t *testing.T) *dimension.Registry {
+	t.Helper()
+	registry := dimension.Default()
+	if err := registry.Register(dimension.FromField("views", "views", "youtube_video", "tiktok_video")); err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
 // MockSSEClient simulates an SSE client for testing purposes.
 type MockSSEClient struct {
-	posts []sseclient.Post
+	posts  []sseclient.Post
+	status sseclient.Status
 }
 
 // ReadStream simulates reading a stream of posts for the specified duration.
-func (m *MockSSEClient) ReadStream(duration time.Duration) chan sseclient.Post {
+func (m *MockSSEClient) ReadStream(ctx context.Context, duration time.Duration) chan sseclient.Post {
 	postChan := make(chan sseclient.Post)
 	go func() {
 		defer close(postChan)
@@ -98,12 +661,36 @@ func (m *MockSSEClient) ReadStream(duration time.Duration) chan sseclient.Post {
 	return postChan
 }
 
+// Status returns the status set on the mock.
+func (m *MockSSEClient) Status() sseclient.Status {
+	return m.status
+}
+
+// BlockingSSEClient simulates an SSE client that sends no posts until the context is done.
+type BlockingSSEClient struct{}
+
+// ReadStream returns a channel that is closed when the context is done.
+func (m *BlockingSSEClient) ReadStream(ctx context.Context, duration time.Duration) chan sseclient.Post {
+	postChan := make(chan sseclient.Post)
+	go func() {
+		<-ctx.Done()
+		close(postChan)
+	}()
+	return postChan
+}
+
 // MockAggregator simulates an Aggregator for testing purposes.
 type MockAggregator struct {
-	result aggregator.AnalysisResult
+	result    aggregator.AnalysisResult
+	snapshots []aggregator.AnalysisResult
+	query     aggregator.Query
 }
 
-func (m *MockAggregator) AggregateData(duration time.Duration, dimension types.Dimension, resultChan chan aggregator.AnalysisResult) {
+func (m *MockAggregator) AggregateData(ctx context.Context, query aggregator.Query, resultChan chan aggregator.AnalysisResult) {
+	m.query = query
+	for _, snapshot := range m.snapshots {
+		query.Snapshots <- snapshot
+	}
 	resultChan <- m.result
 }
 
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"upfcc/internal/sseclient"
+
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultReadinessWindow is the longest time the upstream stream may go without
+// delivering an event before the service is reported as not ready.
+const defaultReadinessWindow = 30 * time.Second
+
+// Readiness is the body of the readiness response.
+type Readiness struct {
+	Ready       bool                `json:"ready"`
+	Reason      string              `json:"reason,omitempty"`        // Reason explains why the service is not ready.
+	State       sseclient.ConnState `json:"state"`                   // State is the state of the upstream connection.
+	Since       *time.Time          `json:"since,omitempty"`         // Since is the time the connection entered its state.
+	LastEventAt *time.Time          `json:"last_event_at,omitempty"` // LastEventAt is the arrival time of the last upstream event.
+	Failures    int                 `json:"failures"`                // Failures is the number of consecutive failed connection attempts.
+}
+
+// HealthHandler reports that the process is alive. It always responds with a
+// 200 OK, whatever the state of the upstream stream.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+func (h *Handler) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadinessHandler reports whether the service can serve analyses, that is
+// whether the upstream stream is connected and has delivered an event within
+// the readiness window. It responds with a 200 OK when ready and a 503 Service
+// Unavailable otherwise, with a JSON body describing the upstream connection.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+func (h *Handler) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	readiness := h.readiness(h.sseClient.Status(), time.Now())
+	code := http.StatusOK
+	if !readiness.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeStatus(w, code, readiness)
+}
+
+// readiness computes the readiness of the service from the status of the upstream connection.
+//
+// Parameters:
+//   - status: The status of the upstream connection.
+//   - now: The current time.
+//
+// Returns:
+//   - The readiness of the service, with the reason why it is not ready if so.
+func (h *Handler) readiness(status sseclient.Status, now time.Time) Readiness {
+	readiness := Readiness{
+		State:    status.State,
+		Failures: status.Failures,
+	}
+	if !status.Since.IsZero() {
+		readiness.Since = &status.Since
+	}
+	if !status.LastEventAt.IsZero() {
+		readiness.LastEventAt = &status.LastEventAt
+	}
+
+	// When connected, the window starts at the last event, or at the connection
+	// if no event was received yet.
+	lastSeen := status.LastEventAt
+	if status.Since.After(lastSeen) && status.State == sseclient.StateConnected {
+		lastSeen = status.Since
+	}
+
+	switch {
+	case status.State == sseclient.StateReconnecting:
+		readiness.Reason = fmt.Sprintf("upstream connection lost, %d failed attempts", status.Failures)
+	case status.State != sseclient.StateConnected:
+		readiness.Reason = "upstream is " + string(status.State)
+	case now.Sub(lastSeen) > h.readinessWindow:
+		readiness.Reason = fmt.Sprintf("no upstream event for more than %v", h.readinessWindow)
+	default:
+		readiness.Ready = true
+	}
+	return readiness
+}
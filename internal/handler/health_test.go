@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"upfcc/internal/sseclient"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_HealthHandler(t *testing.T) {
+	handler := New(&MockSSEClient{status: sseclient.Status{State: sseclient.StateReconnecting}}, &MockAggregator{})
+
+	rr := httptest.NewRecorder()
+	handler.HealthHandler(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if body := strings.TrimSpace(rr.Body.String()); body != `{"status":"ok"}` {
+		t.Errorf("handler returned unexpected body: got %v", body)
+	}
+}
+
+func TestHandler_ReadinessHandler(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		status     sseclient.Status
+		wantStatus int
+		wantReason string
+	}{
+		{
+			name:       "Recent Event",
+			status:     sseclient.Status{State: sseclient.StateConnected, Since: now.Add(-time.Hour), LastEventAt: now.Add(-time.Second)},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Just Connected",
+			status:     sseclient.Status{State: sseclient.StateConnected, Since: now.Add(-time.Second)},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Stale Stream",
+			status:     sseclient.Status{State: sseclient.StateConnected, Since: now.Add(-time.Hour), LastEventAt: now.Add(-time.Minute)},
+			wantStatus: http.StatusServiceUnavailable,
+			wantReason: "no upstream event for more than 30s",
+		},
+		{
+			name:       "Reconnect Loop",
+			status:     sseclient.Status{State: sseclient.StateReconnecting, Since: now, LastEventAt: now.Add(-time.Second), Failures: 3},
+			wantStatus: http.StatusServiceUnavailable,
+			wantReason: "upstream connection lost, 3 failed attempts",
+		},
+		{
+			name:       "Idle",
+			status:     sseclient.Status{State: sseclient.StateIdle},
+			wantStatus: http.StatusServiceUnavailable,
+			wantReason: "upstream is idle",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := New(&MockSSEClient{status: tt.status}, &MockAggregator{})
+
+			rr := httptest.NewRecorder()
+			handler.ReadinessHandler(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			var got Readiness
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Ready != (tt.wantStatus == http.StatusOK) || got.Reason != tt.wantReason {
+				t.Errorf("handler returned ready %v with reason %q, want reason %q", got.Ready, got.Reason, tt.wantReason)
+			}
+			if got.State != tt.status.State || got.Failures != tt.status.Failures {
+				t.Errorf("handler returned state %q with %d failures, want %q with %d", got.State, got.Failures, tt.status.State, tt.status.Failures)
+			}
+			if tt.status.LastEventAt.IsZero() != (got.LastEventAt == nil) {
+				t.Errorf("handler returned last_event_at %v, want %v", got.LastEventAt, tt.status.LastEventAt)
+			}
+		})
+	}
+}
+
+func TestHandler_ReadinessHandlerWindow(t *testing.T) {
+	status := sseclient.Status{State: sseclient.StateConnected, LastEventAt: time.Now().Add(-time.Minute)}
+	handler := New(&MockSSEClient{status: status}, &MockAggregator{}, WithReadinessWindow(2*time.Minute))
+
+	rr := httptest.NewRecorder()
+	handler.ReadinessHandler(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
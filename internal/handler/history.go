@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// window is the time window of an analysis: either a live duration, or a
+// range of the history when from is set.
+type window struct {
+	duration time.Duration
+	from     time.Time
+	to       time.Time
+}
+
+// parseWindow reads the time window of the analysis from the URL: either the
+// 'duration' query parameter, to analyze the posts to come, or the 'from' and
+// optional 'to' parameters, or the 'last' parameter, to analyze past posts from
+// the history. A live analysis can also end at the time of the 'until' parameter
+// instead of lasting a duration. If the parameters are missing, invalid or
+// conflicting, it writes an HTTP error response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+//
+// Returns:
+//   - The window of the analysis.
+//   - An error if the window is invalid.
+func (h *Handler) parseWindow(w http.ResponseWriter, r *http.Request) (window, error) {
+	query := r.URL.Query()
+	if !query.Has("from") && !query.Has("to") && !query.Has("last") {
+		duration, err := h.parseDuration(w, r)
+		return window{duration: duration}, err
+	}
+
+	win, err := h.parseHistoryWindow(r, time.Now())
+	if err != nil {
+		http.Error(w, "Invalid time range: "+err.Error(), http.StatusBadRequest)
+		return window{}, err
+	}
+	return win, nil
+}
+
+// parseHistoryWindow reads the range of a historical analysis from the 'from',
+// 'to' and 'last' query parameters, relative to now.
+func (h *Handler) parseHistoryWindow(r *http.Request, now time.Time) (window, error) {
+	query := r.URL.Query()
+	switch {
+	case !h.history:
+		return window{}, errors.New("historical analysis is not enabled on this server")
+	case query.Has("duration"):
+		return window{}, errors.New("duration cannot be combined with from, to or last")
+	case query.Has("until"):
+		return window{}, errors.New("until cannot be combined with from, to or last")
+	case query.Has("last") && (query.Has("from") || query.Has("to")):
+		return window{}, errors.New("last cannot be combined with from or to")
+	case query.Has("to") && !query.Has("from"):
+		return window{}, errors.New("to requires from")
+	}
+
+	win := window{to: now}
+	if query.Has("last") {
+		last, err := parseDurationValue(query.Get("last"))
+		if err != nil {
+			return window{}, fmt.Errorf("last: %w", err)
+		}
+		if last <= 0 {
+			return window{}, errors.New("last: must be positive")
+		}
+		win.from = now.Add(-last)
+	} else {
+		from, err := parseTime(query.Get("from"))
+		if err != nil {
+			return window{}, fmt.Errorf("from: %w", err)
+		}
+		win.from = from
+		if query.Has("to") {
+			if win.to, err = parseTime(query.Get("to")); err != nil {
+				return window{}, fmt.Errorf("to: %w", err)
+			}
+		}
+	}
+
+	if !win.from.Before(win.to) {
+		return window{}, fmt.Errorf("from %s must be before to %s", win.from.Format(time.RFC3339), win.to.Format(time.RFC3339))
+	}
+	if h.historyRetention > 0 && win.from.Before(now.Add(-h.historyRetention)) {
+		return window{}, fmt.Errorf("from %s is older than the history retention of %v", win.from.Format(time.RFC3339), h.historyRetention)
+	}
+	win.duration = win.to.Sub(win.from)
+	return win, nil
+}
+
+// parseTime parses a time given either in RFC 3339 format, such as
+// "2024-05-01T10:00:00Z", or as a Unix timestamp in seconds.
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a Unix timestamp", value)
+	}
+	return t, nil
+}
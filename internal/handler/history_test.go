@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalysisHandlerHistory(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		opts         []Option
+		wantStatus   int
+		wantError    string
+		wantFrom     time.Time
+		wantTo       time.Time
+		wantDuration time.Duration
+	}{
+		{
+			name:         "From And To In RFC 3339",
+			query:        "from=2024-05-01T10:00:00Z&to=2024-05-01T10:30:00Z",
+			opts:         []Option{WithHistory(0)},
+			wantStatus:   http.StatusOK,
+			wantFrom:     time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+			wantTo:       time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC),
+			wantDuration: 30 * time.Minute,
+		},
+		{
+			name:         "From And To As Unix Timestamps",
+			query:        "from=1714557600&to=1714557660",
+			opts:         []Option{WithHistory(0)},
+			wantStatus:   http.StatusOK,
+			wantFrom:     time.Unix(1714557600, 0),
+			wantTo:       time.Unix(1714557660, 0),
+			wantDuration: time.Minute,
+		},
+		{
+			name:         "Last",
+			query:        "last=1h",
+			opts:         []Option{WithHistory(2 * time.Hour)},
+			wantStatus:   http.StatusOK,
+			wantDuration: time.Hour,
+		},
+		{
+			name:       "History Disabled",
+			query:      "last=1h",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "historical analysis is not enabled",
+		},
+		{
+			name:       "Older Than Retention",
+			query:      "last=3h",
+			opts:       []Option{WithHistory(2 * time.Hour)},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "older than the history retention of 2h0m0s",
+		},
+		{
+			name:       "With Duration",
+			query:      "last=1h&duration=30s",
+			opts:       []Option{WithHistory(0)},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "duration cannot be combined",
+		},
+		{
+			name:       "Last With From",
+			query:      "last=1h&from=1714557600",
+			opts:       []Option{WithHistory(0)},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "last cannot be combined",
+		},
+		{
+			name:       "To Without From",
+			query:      "to=1714557600",
+			opts:       []Option{WithHistory(0)},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "to requires from",
+		},
+		{
+			name:       "Invalid From",
+			query:      "from=yesterday",
+			opts:       []Option{WithHistory(0)},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "from: \"yesterday\" is neither",
+		},
+		{
+			name:       "From After To",
+			query:      "from=1714557660&to=1714557600",
+			opts:       []Option{WithHistory(0)},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "must be before to",
+		},
+		{
+			name:       "Negative Last",
+			query:      "last=-1h",
+			opts:       []Option{WithHistory(0)},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "last: must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAggregator := &MockAggregator{}
+			handler := New(nil, mockAggregator, tt.opts...)
+
+			req := httptest.NewRequest("GET", "/analysis?dimension=likes&"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			start := time.Now()
+			handler.AnalysisHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v (%s)", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if !strings.Contains(rr.Body.String(), tt.wantError) {
+					t.Errorf("handler returned error %q, want it to contain %q", rr.Body.String(), tt.wantError)
+				}
+				return
+			}
+
+			query := mockAggregator.query
+			if query.Duration != tt.wantDuration {
+				t.Errorf("query duration = %v, want %v", query.Duration, tt.wantDuration)
+			}
+			if !tt.wantFrom.IsZero() && (!query.From.Equal(tt.wantFrom) || !query.To.Equal(tt.wantTo)) {
+				t.Errorf("query range = [%v, %v), want [%v, %v)", query.From, query.To, tt.wantFrom, tt.wantTo)
+			}
+			if tt.wantFrom.IsZero() && (query.To.Before(start) || query.To.After(time.Now())) {
+				t.Errorf("query range ends at %v, want now", query.To)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"upfcc/internal/aggregator"
+	"upfcc/internal/jobs"
+
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultMaxJobs is the default maximum number of analysis jobs tracked.
+	defaultMaxJobs = 100
+	// defaultJobRetention is how long finished analysis jobs are kept by default.
+	defaultJobRetention = time.Hour
+	// jobProgressInterval is the interval between two updates of the progress of a job.
+	jobProgressInterval = time.Second
+)
+
+// AnalysisJobsHandler starts an analysis in the background on POST requests. It
+// accepts the same query parameters as AnalysisHandler, and responds at once
+// with a 202 Accepted holding the job, whose state is then read from the URL
+// in the Location header, as served by AnalysisJobHandler.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+func (h *Handler) AnalysisJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.startJob(w, r)
+}
+
+// startJob starts the analysis described by the request as a job, and responds
+// with a 202 Accepted holding the job. If the request has a 'callback_url', the
+// result of the job is delivered to it once the analysis is finished.
+// If a parameter is invalid or the job cannot be started, it writes an HTTP
+// error response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+func (h *Handler) startJob(w http.ResponseWriter, r *http.Request) {
+	query, err := h.parseQuery(w, r)
+	if err != nil {
+		return
+	}
+
+	callbackURL, err := h.parseCallbackURL(w, r)
+	if err != nil {
+		return
+	}
+
+	// The job holds its slot of the rate limiter until its analysis is finished.
+	if !h.admit(w, r) {
+		return
+	}
+	job, err := h.jobs.Start(func(ctx context.Context, id string, progress func(jobs.Progress)) aggregator.AnalysisResult {
+		result := h.runJob(ctx, query, progress)
+		h.release()
+		if callbackURL != "" {
+			if _, err := h.callbacks.Deliver(id, callbackURL, result); err != nil {
+				slog.Error("Failed to deliver the result of a job", "job", id, "error", err)
+			}
+		}
+		return result
+	})
+	if err != nil {
+		h.release()
+	}
+	if errors.Is(err, jobs.ErrFull) {
+		http.Error(w, "Too many running analysis jobs, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, jobs.ErrShutdown) {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to start the analysis job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/analysis/jobs/"+job.ID)
+	writeStatus(w, http.StatusAccepted, job)
+}
+
+// AnalysisJobHandler serves the analysis job whose ID ends the URL path. GET
+// requests return the state, the progress and, once finished, the result of
+// the job. DELETE requests cancel a running job, keeping its partial result,
+// or forget a finished job.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+func (h *Handler) AnalysisJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/analysis/jobs/")
+
+	var job jobs.Job
+	var ok bool
+	switch r.Method {
+	case http.MethodGet:
+		job, ok = h.jobs.Get(id)
+	case http.MethodDelete:
+		job, ok = h.jobs.Cancel(id)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodDelete)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ok {
+		http.Error(w, "Analysis job not found: "+id, http.StatusNotFound)
+		return
+	}
+	writeStatus(w, http.StatusOK, job)
+}
+
+// ShutdownJobs stops the analysis jobs: no job can be started afterwards, and
+// the running jobs are given until ctx is done to finish. The jobs still running
+// then are stopped with their partial result, marked as truncated, which is
+// delivered to their callback URL like any other result.
+//
+// Parameters:
+//   - ctx: A context that ends the grace period of the running jobs when done.
+//
+// Returns:
+//   - nil if every job finished in time, or the error of ctx if some were truncated.
+func (h *Handler) ShutdownJobs(ctx context.Context) error {
+	return h.jobs.Shutdown(ctx)
+}
+
+// runJob runs the analysis of a job, reporting its progress at every jobProgressInterval.
+//
+// Parameters:
+//   - ctx: A context that stops the analysis when done, as when the job is cancelled.
+//   - query: The analysis to run.
+//   - progress: The function recording the progress of the job.
+//
+// Returns:
+//   - The result of the analysis.
+func (h *Handler) runJob(ctx context.Context, query aggregator.Query, progress func(jobs.Progress)) aggregator.AnalysisResult {
+	snapshots := make(chan aggregator.AnalysisResult)
+	query.Snapshots = snapshots
+	query.SnapshotInterval = jobProgressInterval
+	resultChan := make(chan aggregator.AnalysisResult, 1)
+	start := time.Now()
+	go h.aggregate(ctx, query, resultChan)
+
+	for {
+		select {
+		case snapshot := <-snapshots:
+			// Historical analyses read the posts at once, so only the progress of live
+			// analyses is measured by the elapsed time.
+			var fraction float64
+			if query.From.IsZero() && query.Duration > 0 {
+				fraction = min(float64(time.Since(start))/float64(query.Duration), 1)
+			}
+			progress(jobs.Progress{Fraction: fraction, TotalPosts: snapshot.TotalPosts})
+		case result := <-resultChan:
+			return result
+		}
+	}
+}
+
+// parseCallbackURL reads and validates the optional 'callback_url' query parameter
+// from the URL, the absolute http(s) URL the result of the analysis is delivered
+// to, whose host must be allowed by the dispatcher of the callbacks.
+// If the parameter is invalid or callbacks are not enabled, it writes an HTTP
+// error response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+//
+// Returns:
+//   - The callback URL, or an empty string if the parameter is missing.
+//   - An error if the URL is invalid or callbacks are not enabled.
+func (h *Handler) parseCallbackURL(w http.ResponseWriter, r *http.Request) (string, error) {
+	query := r.URL.Query()
+	if !query.Has("callback_url") {
+		return "", nil
+	}
+	if h.callbacks == nil {
+		http.Error(w, "Invalid callback_url: callbacks are not enabled on this server", http.StatusBadRequest)
+		return "", errors.New("callbacks are not enabled")
+	}
+
+	callbackURL := query.Get("callback_url")
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "Invalid callback_url: must be an absolute http or https URL", http.StatusBadRequest)
+		return "", errors.New("invalid callback_url")
+	}
+	if err := h.callbacks.CheckURL(u); err != nil {
+		http.Error(w, "Invalid callback_url: "+err.Error(), http.StatusBadRequest)
+		return "", err
+	}
+	return callbackURL, nil
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"upfcc/internal/aggregator"
+	"upfcc/internal/jobs"
+	"upfcc/internal/webhook"
+
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalysisJobsHandler(t *testing.T) {
+	mockAggregator := &MockAggregator{
+		result:    aggregator.AnalysisResult{TotalPosts: 3, AvgValue: 12},
+		snapshots: []aggregator.AnalysisResult{{TotalPosts: 1}},
+	}
+	handler := New(nil, mockAggregator)
+
+	rr := httptest.NewRecorder()
+	handler.AnalysisJobsHandler(rr, httptest.NewRequest("POST", "/analysis/jobs?duration=5s&dimension=likes", nil))
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	var job jobs.Job
+	if err := json.Unmarshal(rr.Body.Bytes(), &job); err != nil {
+		t.Fatalf("handler returned an invalid job %q: %v", rr.Body.String(), err)
+	}
+	if location := rr.Header().Get("Location"); location != "/analysis/jobs/"+job.ID || job.State != jobs.Running {
+		t.Errorf("handler returned the job %+v at %q, want a running job at its URL", job, location)
+	}
+
+	job = pollJob(t, handler, job.ID)
+	if job.State != jobs.Done || job.Result == nil || job.Result.TotalPosts != 3 || job.Result.AvgValue != 12 {
+		t.Errorf("job = %+v, want the done job with the result of the aggregator", job)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.AnalysisJobHandler(rr, httptest.NewRequest("DELETE", "/analysis/jobs/"+job.ID, nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("DELETE returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	rr = httptest.NewRecorder()
+	handler.AnalysisJobHandler(rr, httptest.NewRequest("GET", "/analysis/jobs/"+job.ID, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("GET of a deleted job returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestAnalysisJobsHandlerCancel(t *testing.T) {
+	handler := New(nil, aggregator.New(&BlockingSSEClient{}), WithJobs(1, time.Minute))
+
+	rr := httptest.NewRecorder()
+	handler.AnalysisJobsHandler(rr, httptest.NewRequest("POST", "/analysis/jobs?duration=1h&dimension=likes", nil))
+	var job jobs.Job
+	json.Unmarshal(rr.Body.Bytes(), &job)
+
+	rr = httptest.NewRecorder()
+	handler.AnalysisJobsHandler(rr, httptest.NewRequest("POST", "/analysis/jobs?duration=1h&dimension=likes", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code with a full registry: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.AnalysisJobHandler(rr, httptest.NewRequest("DELETE", "/analysis/jobs/"+job.ID, nil))
+	var cancelled jobs.Job
+	json.Unmarshal(rr.Body.Bytes(), &cancelled)
+	if rr.Code != http.StatusOK || cancelled.State != jobs.Cancelled {
+		t.Errorf("DELETE returned %v %+v, want the cancelled job", rr.Code, cancelled)
+	}
+
+	job = pollJob(t, handler, job.ID)
+	if job.State != jobs.Cancelled || job.Result == nil || !job.Result.Truncated {
+		t.Errorf("job = %+v, want the cancelled job with a truncated result", job)
+	}
+}
+
+func TestAnalysisHandlerCallback(t *testing.T) {
+	const secret = "s3cret"
+	bodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify(secret, r.Header, body, time.Minute, time.Now()); err != nil {
+			t.Errorf("receiver got an invalid signature: %v", err)
+		}
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	mockAggregator := &MockAggregator{result: aggregator.AnalysisResult{TotalPosts: 3, AvgValue: 12}}
+	callbacks := webhook.NewDispatcher(webhook.Options{Secret: secret, MaxAttempts: 1, AllowedHosts: []string{"127.0.0.1"}})
+	handler := New(nil, mockAggregator, WithCallbacks(callbacks))
+
+	rr := httptest.NewRecorder()
+	target := "/analysis?duration=5s&dimension=likes&callback_url=" + url.QueryEscape(receiver.URL+"/hook")
+	handler.AnalysisHandler(rr, httptest.NewRequest("GET", target, nil))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	var job jobs.Job
+	json.Unmarshal(rr.Body.Bytes(), &job)
+	if location := rr.Header().Get("Location"); location != "/analysis/jobs/"+job.ID {
+		t.Errorf("handler returned Location %q, want the URL of the job", location)
+	}
+
+	select {
+	case body := <-bodies:
+		var result aggregator.AnalysisResult
+		if err := json.Unmarshal(body, &result); err != nil || result.TotalPosts != 3 || result.AvgValue != 12 {
+			t.Errorf("receiver got %s, want the result of the aggregator", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("receiver got no delivery")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.DeliveriesHandler(rr, httptest.NewRequest("GET", "/deliveries?job_id="+job.ID, nil))
+	var deliveries []webhook.Delivery
+	json.Unmarshal(rr.Body.Bytes(), &deliveries)
+	if len(deliveries) != 1 || deliveries[0].JobID != job.ID || deliveries[0].URL != receiver.URL+"/hook" {
+		t.Errorf("deliveries = %+v, want the delivery of the job", deliveries)
+	}
+
+	for _, callbackURL := range []string{"ftp://example.com", "http://169.254.169.254/latest/meta-data"} {
+		rr = httptest.NewRecorder()
+		handler.AnalysisHandler(rr, httptest.NewRequest("GET", "/analysis?duration=5s&dimension=likes&callback_url="+url.QueryEscape(callbackURL), nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for callback_url %s: got %v want %v", callbackURL, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestDeliveriesHandlerDisabled(t *testing.T) {
+	handler := New(nil, &MockAggregator{})
+
+	rr := httptest.NewRecorder()
+	handler.DeliveriesHandler(rr, httptest.NewRequest("GET", "/deliveries", nil))
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("handler returned %v %s, want an empty list", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAnalysisJobsHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantAllow  string
+	}{
+		{name: "InvalidQuery", method: "POST", target: "/analysis/jobs?duration=5s&dimension=shares", wantStatus: http.StatusBadRequest},
+		{name: "GetJobs", method: "GET", target: "/analysis/jobs?duration=5s&dimension=likes", wantStatus: http.StatusMethodNotAllowed, wantAllow: "POST"},
+		{name: "UnknownJob", method: "GET", target: "/analysis/jobs/unknown", wantStatus: http.StatusNotFound},
+		{name: "CancelUnknownJob", method: "DELETE", target: "/analysis/jobs/unknown", wantStatus: http.StatusNotFound},
+		{name: "PutJob", method: "PUT", target: "/analysis/jobs/unknown", wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET, DELETE"},
+		{name: "CallbacksDisabled", method: "POST", target: "/analysis/jobs?duration=5s&dimension=likes&callback_url=http://example.com", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := New(nil, &MockAggregator{})
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+
+			if strings.HasPrefix(req.URL.Path, "/analysis/jobs/") {
+				handler.AnalysisJobHandler(rr, req)
+			} else {
+				handler.AnalysisJobsHandler(rr, req)
+			}
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if allow := rr.Header().Get("Allow"); allow != tt.wantAllow {
+				t.Errorf("handler returned Allow %q, want %q", allow, tt.wantAllow)
+			}
+		})
+	}
+}
+
+//// helpers
+
+// pollJob polls the job until it has finished, and returns it.
+func pollJob(t *testing.T, handler *Handler, id string) jobs.Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		rr := httptest.NewRecorder()
+		handler.AnalysisJobHandler(rr, httptest.NewRequest("GET", "/analysis/jobs/"+id, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var job jobs.Job
+		if err := json.Unmarshal(rr.Body.Bytes(), &job); err != nil {
+			t.Fatalf("GET returned an invalid job %q: %v", rr.Body.String(), err)
+		}
+		if job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return jobs.Job{}
+}
@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"upfcc/internal/aggregator"
+	"upfcc/internal/metrics"
+
+	"context"
+)
+
+// analysesInFlight is the number of analyses running, exposed on /metrics.
+var analysesInFlight = metrics.Default.NewGauge("upfcc_analyses_in_flight",
+	"Number of analyses currently running.")
+
+// aggregate runs the aggregation of a query, counting it as in flight until its result is sent.
+func (h *Handler) aggregate(ctx context.Context, query aggregator.Query, resultChan chan aggregator.AnalysisResult) {
+	analysesInFlight.Inc()
+	defer analysesInFlight.Dec()
+	h.aggregator.AggregateData(ctx, query, resultChan)
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"upfcc/internal/dimension"
+	"upfcc/internal/jobs"
+	"upfcc/internal/ratelimit"
+	"upfcc/internal/webhook"
+
+	"net/netip"
+	"time"
+)
+
+// Option configures optional behavior of a Handler.
+type Option func(*Handler)
+
+// WithMaxDuration sets the longest analysis duration accepted by the handler.
+// Requests asking for a longer duration are rejected with a 400 Bad Request.
+// A zero duration, the default, accepts any duration.
+func WithMaxDuration(maxDuration time.Duration) Option {
+	return func(h *Handler) {
+		h.maxDuration = maxDuration
+	}
+}
+
+// WithMinDuration sets the shortest analysis duration accepted by the handler.
+// Requests asking for a shorter duration are rejected with a 400 Bad Request.
+// By default, any positive duration is accepted.
+func WithMinDuration(minDuration time.Duration) Option {
+	return func(h *Handler) {
+		h.minDuration = minDuration
+	}
+}
+
+// WithReadinessWindow sets the longest time the upstream stream may go without
+// delivering an event before ReadinessHandler reports the service as not ready.
+// It defaults to defaultReadinessWindow.
+func WithReadinessWindow(window time.Duration) Option {
+	return func(h *Handler) {
+		h.readinessWindow = window
+	}
+}
+
+// WithHistory enables historical analyses, answered from the history of posts
+// kept by the aggregator, over the given retention. Requests for posts older
+// than the retention are rejected with a 400 Bad Request. A zero retention
+// accepts any time range.
+func WithHistory(retention time.Duration) Option {
+	return func(h *Handler) {
+		h.history = true
+		h.historyRetention = retention
+	}
+}
+
+// WithDimensions sets the registry of the dimensions that can be analyzed.
+// It defaults to the built-in dimensions of dimension.Default.
+func WithDimensions(dimensions *dimension.Registry) Option {
+	return func(h *Handler) {
+		h.dimensions = dimensions
+	}
+}
+
+// WithJobs sets the maximum number of analysis jobs tracked, running or
+// finished, and how long finished jobs and their results are kept. They
+// default to defaultMaxJobs and defaultJobRetention.
+func WithJobs(maxJobs int, retention time.Duration) Option {
+	return func(h *Handler) {
+		h.jobs = jobs.NewRegistry(jobs.Options{MaxJobs: maxJobs, Retention: retention})
+	}
+}
+
+// WithCallbacks enables the delivery of analysis results to the 'callback_url'
+// of requests, using the given dispatcher. Callbacks are disabled by default.
+func WithCallbacks(callbacks *webhook.Dispatcher) Option {
+	return func(h *Handler) {
+		h.callbacks = callbacks
+	}
+}
+
+// WithRateLimit limits the analysis requests of each client and the number of
+// analyses running at once with the given limiter. Requests over a limit are
+// rejected with a 429 Too Many Requests. There is no limit by default.
+func WithRateLimit(limiter *ratelimit.Limiter) Option {
+	return func(h *Handler) {
+		h.limiter = limiter
+	}
+}
+
+// WithAPIKeys sets the API keys that identify clients for rate limiting, in
+// place of their IP address. Requests with another key are limited by IP
+// address, so that clients cannot make up keys to get new buckets. No key is
+// accepted by default.
+func WithAPIKeys(keys []string) Option {
+	return func(h *Handler) {
+		h.apiKeys = make(map[string]bool, len(keys))
+		for _, key := range keys {
+			h.apiKeys[key] = true
+		}
+	}
+}
+
+// WithTrustedProxies sets the proxies whose X-Forwarded-For header identifies
+// the clients for rate limiting, in place of the address of the connection.
+// The header of other clients is ignored, so that it cannot be made up to get
+// new buckets. No proxy is trusted by default.
+func WithTrustedProxies(proxies []netip.Prefix) Option {
+	return func(h *Handler) {
+		h.trustedProxies = proxies
+	}
+}
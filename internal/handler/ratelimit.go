@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"upfcc/internal/ratelimit"
+
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// apiKeyHeader is the header holding the API key of a client, whose requests
+	// are then limited per key instead of per IP address if the key is known.
+	apiKeyHeader = "X-API-Key"
+	// forwardedForHeader is the header holding the addresses a request was
+	// forwarded for, read only from trusted proxies.
+	forwardedForHeader = "X-Forwarded-For"
+	// concurrencyRetryAfter is the delay clients are asked to wait when too many
+	// analyses are running, as there is no telling when one will finish.
+	concurrencyRetryAfter = 5 * time.Second
+)
+
+// RateLimitHandler reports the state of the rate limiter as JSON: its limits,
+// the number of analyses running, the number of rejected requests and the
+// buckets of the clients seen recently. Clients are identified by their IP
+// address, or by a hash of their known API key, which is never shown.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+func (h *Handler) RateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	state := ratelimit.State{Clients: []ratelimit.ClientState{}}
+	if h.limiter != nil {
+		state = h.limiter.State()
+	}
+	writeStatus(w, http.StatusOK, state)
+}
+
+// allow takes a token from the bucket of the client of an analysis request,
+// once its parameters are validated, so that invalid requests cost nothing.
+// If the bucket is empty, it writes a 429 Too Many Requests response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+//
+// Returns:
+//   - true if the request can go on.
+func (h *Handler) allow(w http.ResponseWriter, r *http.Request) bool {
+	if h.limiter == nil {
+		return true
+	}
+	ok, retryAfter := h.limiter.Allow(h.clientID(r))
+	if !ok {
+		writeTooManyRequests(w, retryAfter, "Too many requests, retry later")
+	}
+	return ok
+}
+
+// admit lets an analysis request run: it takes a slot for the analysis, then a
+// token from the bucket of its client, so that requests rejected because too
+// many analyses are running do not cost their client a token. The slot must be
+// given back with release once the analysis is finished. If the request is
+// rejected, it writes a 429 Too Many Requests response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+//
+// Returns:
+//   - true if the analysis can run.
+func (h *Handler) admit(w http.ResponseWriter, r *http.Request) bool {
+	if !h.acquire(w) {
+		return false
+	}
+	if !h.allow(w, r) {
+		h.release()
+		return false
+	}
+	return true
+}
+
+// acquire takes a slot for an analysis, which must be given back with release
+// once the analysis is finished. If too many analyses are running, it writes
+// a 429 Too Many Requests response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//
+// Returns:
+//   - true if the analysis can run.
+func (h *Handler) acquire(w http.ResponseWriter) bool {
+	if h.limiter == nil || h.limiter.Acquire() {
+		return true
+	}
+	writeTooManyRequests(w, concurrencyRetryAfter, "Too many running analyses, retry later")
+	return false
+}
+
+// release gives back the slot of a finished analysis, taken by acquire.
+func (h *Handler) release() {
+	if h.limiter != nil {
+		h.limiter.Release()
+	}
+}
+
+// clientID identifies the client of a request for rate limiting: by a hash of
+// its API key if it sends one of the known keys, or else by its IP address.
+func (h *Handler) clientID(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); h.apiKeys[key] {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+	return "ip:" + h.clientIP(r)
+}
+
+// clientIP returns the IP address of the client of a request. It is the address
+// of the connection, unless it comes from a trusted proxy: the X-Forwarded-For
+// header is then read from right to left, each proxy appending the address it
+// got the request from, and the first address that is not a trusted proxy is
+// the client. Entries that are not addresses stop the search, as they cannot be
+// trusted to be followed by the address of the client.
+func (h *Handler) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if addr, err := netip.ParseAddr(host); err != nil || !h.trustedProxy(addr) {
+		return host
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values(forwardedForHeader) {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		host = addr.Unmap().String()
+		if !h.trustedProxy(addr) {
+			break
+		}
+	}
+	return host
+}
+
+// trustedProxy reports whether an address is one of the trusted proxies.
+func (h *Handler) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range h.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTooManyRequests writes a 429 Too Many Requests response, with a
+// Retry-After header rounded up to the second.
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	seconds := max(int(math.Ceil(retryAfter.Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
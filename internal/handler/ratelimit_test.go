@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"upfcc/internal/aggregator"
+	"upfcc/internal/ratelimit"
+
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestAnalysisHandlerRateLimit(t *testing.T) {
+	handler := New(nil, &MockAggregator{}, WithRateLimit(ratelimit.New(ratelimit.Options{Rate: 0.5, Burst: 2})), WithAPIKeys([]string{"secret-key"}))
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		apiKey         string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{name: "FirstOfBurst", remoteAddr: "10.0.0.1:1234", wantStatus: http.StatusOK},
+		{name: "LastOfBurst", remoteAddr: "10.0.0.1:5678", wantStatus: http.StatusOK},
+		{name: "OverLimit", remoteAddr: "10.0.0.1:1234", wantStatus: http.StatusTooManyRequests, wantRetryAfter: "2"},
+		{name: "OtherIP", remoteAddr: "10.0.0.2:1234", wantStatus: http.StatusOK},
+		{name: "APIKey", remoteAddr: "10.0.0.1:1234", apiKey: "secret-key", wantStatus: http.StatusOK},
+		// Unknown keys are ignored, so that rotating keys does not bypass the limit.
+		{name: "UnknownAPIKey", remoteAddr: "10.0.0.1:1234", apiKey: "made-up-1", wantStatus: http.StatusTooManyRequests, wantRetryAfter: "2"},
+		{name: "RotatedAPIKey", remoteAddr: "10.0.0.1:1234", apiKey: "made-up-2", wantStatus: http.StatusTooManyRequests, wantRetryAfter: "2"},
+	}
+
+	// The cases run in order, sharing the buckets of the handler.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/analysis?duration=5s&dimension=likes", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			rr := httptest.NewRecorder()
+
+			handler.AnalysisHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if retryAfter := rr.Header().Get("Retry-After"); retryAfter != tt.wantRetryAfter {
+				t.Errorf("handler returned Retry-After %q, want %q", retryAfter, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestAnalysisHandlerRateLimitRotatingKeys(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Options{Rate: 0.01, Burst: 3})
+	handler := New(nil, &MockAggregator{}, WithRateLimit(limiter))
+
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		req := httptest.NewRequest("GET", "/analysis?duration=5s&dimension=likes", nil)
+		req.Header.Set("X-API-Key", fmt.Sprintf("key-%d", i))
+		rr := httptest.NewRecorder()
+		handler.AnalysisHandler(rr, req)
+		if rr.Code == http.StatusOK {
+			allowed++
+		}
+	}
+
+	if allowed != 3 {
+		t.Errorf("%d requests with rotating keys were allowed, want the burst of 3", allowed)
+	}
+	if state := limiter.State(); len(state.Clients) != 1 || state.Clients[0].Client != "ip:192.0.2.1" {
+		t.Errorf("limiter tracks %+v, want a single bucket for the IP address", state.Clients)
+	}
+}
+
+func TestAnalysisHandlerRateLimitInvalidRequests(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Options{Rate: 0.01, Burst: 1})
+	handler := New(nil, &MockAggregator{}, WithRateLimit(limiter))
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/analysis?duration=5s&dimension=unknown", nil),
+		httptest.NewRequest("GET", "/analysis/stream?duration=5s", nil),
+		httptest.NewRequest("POST", "/analysis/jobs?duration=-5s&dimension=likes", nil),
+	} {
+		rr := httptest.NewRecorder()
+		switch req.URL.Path {
+		case "/analysis/stream":
+			handler.AnalysisStreamHandler(rr, req)
+		case "/analysis/jobs":
+			handler.AnalysisJobsHandler(rr, req)
+		default:
+			handler.AnalysisHandler(rr, req)
+		}
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s %s returned %v, want %v", req.Method, req.URL, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.AnalysisHandler(rr, httptest.NewRequest("GET", "/analysis?duration=5s&dimension=likes", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned %v after invalid requests, want %v as they took no token", rr.Code, http.StatusOK)
+	}
+}
+
+func TestAnalysisHandlerRateLimitTrustedProxies(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.1/32")}
+	handler := New(nil, &MockAggregator{}, WithTrustedProxies(proxies))
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		wantClientID string
+	}{
+		{name: "NoProxy", remoteAddr: "203.0.113.7:1234", wantClientID: "ip:203.0.113.7"},
+		{name: "UntrustedProxy", remoteAddr: "203.0.113.7:1234", forwardedFor: []string{"198.51.100.1"}, wantClientID: "ip:203.0.113.7"},
+		{name: "TrustedProxy", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1"}, wantClientID: "ip:198.51.100.1"},
+		{name: "TrustedProxyWithoutHeader", remoteAddr: "10.0.0.1:1234", wantClientID: "ip:10.0.0.1"},
+		// The left-most entries are set by the client, and cannot be trusted.
+		{name: "SpoofedEntry", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"1.2.3.4, 198.51.100.1"}, wantClientID: "ip:198.51.100.1"},
+		{name: "ChainOfProxies", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1, 192.0.2.1", "10.1.2.3"}, wantClientID: "ip:198.51.100.1"},
+		{name: "InvalidEntry", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1, garbage, 10.1.2.3"}, wantClientID: "ip:10.1.2.3"},
+		{name: "IPv6", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"2001:db8::1"}, wantClientID: "ip:2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/analysis?duration=5s&dimension=likes", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			if clientID := handler.clientID(req); clientID != tt.wantClientID {
+				t.Errorf("clientID() = %q, want %q", clientID, tt.wantClientID)
+			}
+		})
+	}
+}
+
+func TestAnalysisHandlerConcurrencyLimit(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Options{Rate: 0.001, Burst: 3, MaxConcurrent: 1})
+	handler := New(nil, aggregator.New(&BlockingSSEClient{}), WithRateLimit(limiter))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest("GET", "/analysis?duration=1h&dimension=likes", nil).WithContext(ctx)
+		handler.AnalysisHandler(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	waitRunning(t, limiter, 1)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/analysis?duration=5s&dimension=likes", nil),
+		httptest.NewRequest("POST", "/analysis/jobs?duration=5s&dimension=likes", nil),
+	} {
+		rr := httptest.NewRecorder()
+		if req.Method == http.MethodPost {
+			handler.AnalysisJobsHandler(rr, req)
+		} else {
+			handler.AnalysisHandler(rr, req)
+		}
+		if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "5" {
+			t.Errorf("%s %s returned %v with Retry-After %q, want 429 with Retry-After 5",
+				req.Method, req.URL.Path, rr.Code, rr.Header().Get("Retry-After"))
+		}
+	}
+	// Only the running analysis took a token, the rejected ones cost nothing.
+	if state := limiter.State(); len(state.Clients) != 1 || state.Clients[0].Tokens < 1.9 || state.Clients[0].Tokens > 2.1 {
+		t.Errorf("limiter tracks %+v, want 2 tokens left to the client", state.Clients)
+	}
+
+	cancel()
+	<-done
+	waitRunning(t, limiter, 0)
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		wantEnabled bool
+		wantClients int
+	}{
+		{name: "Disabled", wantEnabled: false, wantClients: 0},
+		{name: "Enabled", opts: []Option{WithRateLimit(ratelimit.New(ratelimit.Options{Rate: 1, Burst: 5}))}, wantEnabled: true, wantClients: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := New(nil, &MockAggregator{}, tt.opts...)
+			handler.AnalysisHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/analysis?duration=5s&dimension=likes", nil))
+
+			rr := httptest.NewRecorder()
+			handler.RateLimitHandler(rr, httptest.NewRequest("GET", "/ratelimit", nil))
+
+			var state ratelimit.State
+			if err := json.Unmarshal(rr.Body.Bytes(), &state); err != nil {
+				t.Fatalf("handler returned an invalid state %q: %v", rr.Body.String(), err)
+			}
+			if rr.Code != http.StatusOK || state.Enabled != tt.wantEnabled || len(state.Clients) != tt.wantClients {
+				t.Errorf("handler returned %v %+v, want enabled %v with %d clients", rr.Code, state, tt.wantEnabled, tt.wantClients)
+			}
+			if tt.wantClients > 0 && (state.Clients[0].Client != "ip:192.0.2.1" || int(state.Clients[0].Tokens) != 4) {
+				t.Errorf("client = %+v, want the IP of the request with 4 tokens left", state.Clients[0])
+			}
+		})
+	}
+}
+
+//// helpers
+
+// waitRunning waits for the limiter to count the given number of running analyses.
+func waitRunning(t *testing.T, limiter *ratelimit.Limiter, want int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if limiter.State().Running == want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("limiter counts %d running analyses, want %d", limiter.State().Running, want)
+}
@@ -0,0 +1,17 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// writeStatus writes body as a JSON response with the given status code. The
+// response is never cached: it describes the current state of the service, such
+// as its health, its dimensions, a job or the rate limiter, which may change
+// at any time.
+func writeStatus(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"upfcc/internal/aggregator"
+
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultProgressInterval is the interval between two progress events when the
+// request does not specify one.
+const defaultProgressInterval = time.Second
+
+// AnalysisStreamHandler handles HTTP requests for analyzing social media posts data
+// while reporting the progress of the analysis. It accepts the same query parameters
+// as AnalysisHandler but 'callback_url', rejected with a 400 Bad Request, plus an
+// optional 'progress_interval' duration, and streams the
+// response as Server-Sent Events: a "progress" event holding the running result is
+// sent at every interval, followed by a "result" event holding the final result.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+func (h *Handler) AnalysisStreamHandler(w http.ResponseWriter, r *http.Request) {
+	h.streamAnalysis(w, r)
+}
+
+// streamAnalysis streams the analysis of a request as Server-Sent Events.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+func (h *Handler) streamAnalysis(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// The result is streamed to the caller, so it cannot be delivered to a callback as well.
+	if r.URL.Query().Has("callback_url") {
+		http.Error(w, "Invalid callback_url: streamed analyses cannot be delivered to a callback", http.StatusBadRequest)
+		return
+	}
+
+	query, err := h.parseQuery(w, r)
+	if err != nil {
+		return
+	}
+
+	interval, err := h.parseProgressInterval(w, r)
+	if err != nil {
+		return
+	}
+
+	if !h.admit(w, r) {
+		return
+	}
+	defer h.release()
+
+	snapshots := make(chan aggregator.AnalysisResult)
+	query.Snapshots = snapshots
+	query.SnapshotInterval = interval
+	resultChan := make(chan aggregator.AnalysisResult, 1)
+	go h.aggregate(r.Context(), query, resultChan)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case snapshot := <-snapshots:
+			h.writeEvent(w, "progress", snapshot)
+			flusher.Flush()
+		case result := <-resultChan:
+			h.writeEvent(w, "result", result)
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+// parseProgressInterval reads and parses the optional 'progress_interval' query parameter from the URL.
+// If the parameter is invalid, it writes an HTTP error response.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the HTTP response.
+//   - r: An http.Request representing the HTTP request.
+//
+// Returns:
+//   - The interval between two progress events, defaultProgressInterval if the parameter is missing.
+//   - An error if parsing fails or the interval is not positive.
+func (h *Handler) parseProgressInterval(w http.ResponseWriter, r *http.Request) (time.Duration, error) {
+	intervalStr := r.URL.Query().Get("progress_interval")
+	if intervalStr == "" {
+		return defaultProgressInterval, nil
+	}
+
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		http.Error(w, "Invalid progress_interval: "+err.Error(), http.StatusBadRequest)
+		return 0, err
+	}
+	if interval <= 0 {
+		http.Error(w, "Invalid progress_interval: must be positive", http.StatusBadRequest)
+		return 0, fmt.Errorf("invalid progress_interval %v", interval)
+	}
+	return interval, nil
+}
+
+// writeEvent writes the given result as a Server-Sent Event of the given type.
+//
+// Parameters:
+//   - w: An http.ResponseWriter to write the event.
+//   - event: The type of the event.
+//   - result: The result to write as the JSON data of the event.
+func (h *Handler) writeEvent(w http.ResponseWriter, event string, result aggregator.AnalysisResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"error": "Failed to encode response: " + err.Error()})
+		event = "error"
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
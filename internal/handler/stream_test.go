@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"upfcc/internal/aggregator"
+	"upfcc/internal/testingTools"
+
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalysisStreamHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          string
+		accept       string
+		wantStatus   int
+		wantInterval time.Duration
+		wantBody     string
+	}{
+		{
+			name:         "StreamEndpoint",
+			url:          "/analysis/stream?duration=5s&dimension=likes&progress_interval=2s",
+			wantStatus:   http.StatusOK,
+			wantInterval: 2 * time.Second,
+			wantBody: "event: progress\ndata: {\"total_posts\":1,\"minimum_timestamp\":0,\"maximum_timestamp\":0,\"avg_value\":1}\n\n" +
+				"event: progress\ndata: {\"total_posts\":2,\"minimum_timestamp\":0,\"maximum_timestamp\":0,\"avg_value\":2}\n\n" +
+				"event: result\ndata: {\"total_posts\":3,\"minimum_timestamp\":0,\"maximum_timestamp\":0,\"avg_value\":3}\n\n",
+		},
+		{
+			name:         "AcceptHeader",
+			url:          "/analysis?duration=5s&dimension=likes",
+			accept:       "text/event-stream",
+			wantStatus:   http.StatusOK,
+			wantInterval: defaultProgressInterval,
+			wantBody:     "event: result\ndata:",
+		},
+		{
+			name:         "PreferredAcceptHeader",
+			url:          "/analysis?duration=5s&dimension=likes",
+			accept:       "application/json;q=0.5, text/event-stream",
+			wantStatus:   http.StatusOK,
+			wantInterval: defaultProgressInterval,
+			wantBody:     "event: result\ndata:",
+		},
+		{
+			name:       "CallbackURL",
+			url:        "/analysis?duration=5s&dimension=likes&callback_url=https://example.com/hook",
+			accept:     "text/event-stream",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "StreamEndpointCallbackURL",
+			url:        "/analysis/stream?duration=5s&dimension=likes&callback_url=https://example.com/hook",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "InvalidProgressInterval",
+			url:        "/analysis/stream?duration=5s&dimension=likes&progress_interval=-1s",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "InvalidQuery",
+			url:        "/analysis/stream?duration=5s&dimension=invalid",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAggregator := &MockAggregator{
+				result: aggregator.AnalysisResult{TotalPosts: 3, AvgValue: 3},
+			}
+			if tt.accept == "" {
+				mockAggregator.snapshots = []aggregator.AnalysisResult{
+					{TotalPosts: 1, AvgValue: 1},
+					{TotalPosts: 2, AvgValue: 2},
+				}
+			}
+			handler := New(nil, mockAggregator)
+
+			req := httptest.NewRequest("GET", tt.url, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+
+			if strings.HasPrefix(tt.url, "/analysis/stream") {
+				handler.AnalysisStreamHandler(rr, req)
+			} else {
+				handler.AnalysisHandler(rr, req)
+			}
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if contentType := rr.Header().Get("Content-Type"); contentType != "text/event-stream" {
+				t.Errorf("handler returned Content-Type %q, want text/event-stream", contentType)
+			}
+			if mockAggregator.query.SnapshotInterval != tt.wantInterval {
+				t.Errorf("aggregator got snapshot interval %v, want %v", mockAggregator.query.SnapshotInterval, tt.wantInterval)
+			}
+			if !strings.HasPrefix(rr.Body.String(), tt.wantBody) {
+				t.Errorf("handler returned body %q, want prefix %q", rr.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAnalysisStreamHandlerClientDisconnect(t *testing.T) {
+	defer testingTools.CheckNoGoroutineLeak(t)()
+
+	handler := New(nil, aggregator.New(&BlockingSSEClient{}))
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/analysis/stream?duration=1h&dimension=likes&progress_interval=1ms", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		handler.AnalysisStreamHandler(rr, req)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler did not return after the client disconnected")
+	}
+}
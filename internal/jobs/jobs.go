@@ -0,0 +1,262 @@
+// Package jobs tracks the analyses run in the background, so that clients can
+// start a long analysis and poll for its result, instead of keeping a request
+// open for the whole duration of the analysis.
+//
+// Jobs are kept in memory, and are lost when the server restarts. The registry
+// is bounded: once a job is finished, its result is retained for a configured
+// time, and the oldest finished jobs are evicted early when the registry is full.
+// On shutdown, running jobs are given a grace period to finish, then stopped
+// with their partial result.
+package jobs
+
+import (
+	"upfcc/internal/aggregator"
+
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// State is the state of a job.
+type State string
+
+const (
+	Running   State = "running"   // Running jobs are aggregating posts.
+	Done      State = "done"      // Done jobs have finished their analysis.
+	Cancelled State = "cancelled" // Cancelled jobs were stopped before the end of their analysis, by a client or a shutdown.
+)
+
+// Progress describes how far a running job is.
+type Progress struct {
+	Fraction   float64 `json:"fraction"`    // Fraction is the part of the analysis done, from 0 to 1.
+	TotalPosts int     `json:"total_posts"` // TotalPosts is the number of posts aggregated so far.
+}
+
+// Job is the state of an analysis run in the background.
+type Job struct {
+	ID         string                     `json:"id"`
+	State      State                      `json:"status"`
+	CreatedAt  time.Time                  `json:"created_at"`
+	FinishedAt *time.Time                 `json:"finished_at,omitempty"` // FinishedAt is the time the analysis stopped, once it has.
+	ExpiresAt  *time.Time                 `json:"expires_at,omitempty"`  // ExpiresAt is the time the job is forgotten, once it has finished.
+	Progress   Progress                   `json:"progress"`
+	Result     *aggregator.AnalysisResult `json:"result,omitempty"` // Result is the result of the analysis, partial if it was cancelled.
+}
+
+// Options configures a Registry.
+type Options struct {
+	MaxJobs   int           // MaxJobs is the maximum number of jobs tracked, running or finished.
+	Retention time.Duration // Retention is how long finished jobs and their results are kept.
+}
+
+// ErrFull is returned by Start when the registry holds MaxJobs running jobs.
+var ErrFull = errors.New("too many running jobs")
+
+// ErrShutdown is returned by Start once the registry is shutting down.
+var ErrShutdown = errors.New("shutting down")
+
+// RunFunc runs the analysis of the job with the given ID until it is finished
+// or ctx is done, and returns its result. It reports the progress of the
+// analysis with progress.
+type RunFunc func(ctx context.Context, id string, progress func(Progress)) aggregator.AnalysisResult
+
+// Registry tracks the jobs. It is safe for concurrent use.
+type Registry struct {
+	opts Options
+	now  func() time.Time
+
+	mu       sync.Mutex
+	jobs     map[string]*entry
+	shutdown bool           // shutdown is set once Shutdown is called, so that no job starts afterwards.
+	running  sync.WaitGroup // running counts the jobs whose analysis is running.
+}
+
+// entry is a job tracked by the registry.
+type entry struct {
+	job     Job
+	cancel  context.CancelFunc // cancel stops the analysis of the job.
+	aborted bool               // aborted is set when the job is stopped by Shutdown.
+}
+
+// NewRegistry creates an empty Registry with the given options.
+func NewRegistry(opts Options) *Registry {
+	return &Registry{opts: opts, now: time.Now, jobs: make(map[string]*entry)}
+}
+
+// Start runs an analysis in the background as a new job.
+//
+// Parameters:
+//   - run: The function running the analysis of the job.
+//
+// Returns:
+//   - The job, in the Running state.
+//   - ErrFull if the registry is full of running jobs, or ErrShutdown if it is shutting down.
+func (r *Registry) Start(run RunFunc) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.shutdown {
+		return Job{}, ErrShutdown
+	}
+	now := r.now()
+	r.prune(now)
+	if len(r.jobs) >= r.opts.MaxJobs && !r.evictOldest() {
+		return Job{}, ErrFull
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &entry{job: Job{ID: id, State: Running, CreatedAt: now}, cancel: cancel}
+	r.jobs[id] = e
+
+	r.running.Add(1)
+	go r.run(ctx, e, run)
+	return e.job, nil
+}
+
+// run runs the analysis of a job and records its result.
+func (r *Registry) run(ctx context.Context, e *entry, run RunFunc) {
+	defer r.running.Done()
+	defer e.cancel()
+	result := run(ctx, e.job.ID, func(progress Progress) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if e.job.State == Running {
+			e.job.Progress = progress
+		}
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	expiresAt := now.Add(r.opts.Retention)
+	e.job.FinishedAt = &now
+	e.job.ExpiresAt = &expiresAt
+	if e.aborted {
+		result.Truncated = true
+	}
+	e.job.Result = &result
+	if e.job.State == Running {
+		e.job.State = Done
+		e.job.Progress = Progress{Fraction: 1, TotalPosts: result.TotalPosts}
+	}
+}
+
+// Get returns the job with the given ID, if it is still tracked.
+func (r *Registry) Get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune(r.now())
+	e, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.job, true
+}
+
+// Cancel stops the analysis of a running job, which is then kept with its
+// partial result like a finished job. A finished job is forgotten instead.
+//
+// Parameters:
+//   - id: The ID of the job.
+//
+// Returns:
+//   - The job, in the Cancelled state if it was running.
+//   - false if no job has the given ID.
+func (r *Registry) Cancel(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune(r.now())
+	e, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	if e.job.FinishedAt != nil {
+		delete(r.jobs, id)
+		return e.job, true
+	}
+	e.job.State = Cancelled
+	e.cancel()
+	return e.job, true
+}
+
+// Shutdown stops the registry: jobs can no longer be started, and the running
+// jobs are given until ctx is done to finish. The jobs still running then are
+// stopped, and kept in the Cancelled state with their partial result, marked as
+// truncated. Shutdown returns once every analysis has stopped.
+//
+// Parameters:
+//   - ctx: A context that ends the grace period of the running jobs when done.
+//
+// Returns:
+//   - nil if every job finished in time, or the error of ctx if some were stopped.
+func (r *Registry) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.shutdown = true
+	r.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+	}
+
+	r.mu.Lock()
+	for _, e := range r.jobs {
+		if e.job.FinishedAt == nil {
+			e.job.State = Cancelled
+			e.aborted = true
+			e.cancel()
+		}
+	}
+	r.mu.Unlock()
+	<-finished
+	return ctx.Err()
+}
+
+// prune forgets the finished jobs that expired. The registry must be locked.
+func (r *Registry) prune(now time.Time) {
+	for id, e := range r.jobs {
+		if e.job.ExpiresAt != nil && !now.Before(*e.job.ExpiresAt) {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+// evictOldest forgets the job that finished first, and reports whether there
+// was a finished job to forget. The registry must be locked.
+func (r *Registry) evictOldest() bool {
+	var oldest *entry
+	for _, e := range r.jobs {
+		if e.job.FinishedAt != nil && (oldest == nil || e.job.FinishedAt.Before(*oldest.job.FinishedAt)) {
+			oldest = e
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(r.jobs, oldest.job.ID)
+	return true
+}
+
+// newID returns a random job ID.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
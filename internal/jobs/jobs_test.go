@@ -0,0 +1,209 @@
+package jobs
+
+import (
+	"upfcc/internal/aggregator"
+
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Lifecycle(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	r := NewRegistry(Options{MaxJobs: 10, Retention: time.Hour})
+	r.now = clock.Now
+
+	analysis := newFakeAnalysis()
+	job, err := r.Start(analysis.run)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if job.ID == "" || job.State != Running || !job.CreatedAt.Equal(clock.Now()) {
+		t.Fatalf("Start() = %+v, want a running job created now", job)
+	}
+
+	// The second send waits for the first progress to be recorded.
+	analysis.progress <- Progress{Fraction: 0.5, TotalPosts: 3}
+	analysis.progress <- Progress{Fraction: 0.5, TotalPosts: 3}
+	if got, _ := r.Get(job.ID); got.State != Running || got.Progress != (Progress{Fraction: 0.5, TotalPosts: 3}) || got.Result != nil {
+		t.Errorf("Get() = %+v, want the running job with its progress and without result", got)
+	}
+
+	clock.Advance(time.Minute)
+	analysis.finish(aggregator.AnalysisResult{TotalPosts: 7})
+	got := waitFinished(t, r, job.ID)
+	if got.State != Done || got.Progress != (Progress{Fraction: 1, TotalPosts: 7}) || got.Result.TotalPosts != 7 {
+		t.Errorf("Get() = %+v, want the done job with its result", got)
+	}
+	if !got.FinishedAt.Equal(clock.Now()) || !got.ExpiresAt.Equal(clock.Now().Add(time.Hour)) {
+		t.Errorf("Get() finished at %v and expires at %v, want now and in 1h", got.FinishedAt, got.ExpiresAt)
+	}
+
+	clock.Advance(time.Hour)
+	if _, ok := r.Get(job.ID); ok {
+		t.Error("Get() found the job after its retention")
+	}
+	if _, ok := r.Get("unknown"); ok {
+		t.Error("Get() found an unknown job")
+	}
+}
+
+func TestRegistry_Cancel(t *testing.T) {
+	r := NewRegistry(Options{MaxJobs: 10, Retention: time.Hour})
+
+	job, _ := r.Start(func(ctx context.Context, id string, progress func(Progress)) aggregator.AnalysisResult {
+		<-ctx.Done()
+		return aggregator.AnalysisResult{TotalPosts: 2, Truncated: true}
+	})
+
+	cancelled, ok := r.Cancel(job.ID)
+	if !ok || cancelled.State != Cancelled {
+		t.Fatalf("Cancel() = %+v, %v, want the cancelled job", cancelled, ok)
+	}
+	got := waitFinished(t, r, job.ID)
+	if got.State != Cancelled || got.Result.TotalPosts != 2 || !got.Result.Truncated {
+		t.Errorf("Get() = %+v, want the cancelled job with its partial result", got)
+	}
+
+	if _, ok := r.Cancel(job.ID); !ok {
+		t.Error("Cancel() did not find the finished job")
+	}
+	if _, ok := r.Get(job.ID); ok {
+		t.Error("Get() found the job after cancelling it once finished")
+	}
+	if _, ok := r.Cancel(job.ID); ok {
+		t.Error("Cancel() found the forgotten job")
+	}
+}
+
+func TestRegistry_MaxJobs(t *testing.T) {
+	r := NewRegistry(Options{MaxJobs: 2, Retention: time.Hour})
+
+	finished, _ := r.Start(func(ctx context.Context, id string, progress func(Progress)) aggregator.AnalysisResult {
+		return aggregator.AnalysisResult{}
+	})
+	waitFinished(t, r, finished.ID)
+
+	running := newFakeAnalysis()
+	defer running.finish(aggregator.AnalysisResult{})
+	if _, err := r.Start(running.run); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// The registry is full, so the finished job is evicted for the new one.
+	blocked := newFakeAnalysis()
+	defer blocked.finish(aggregator.AnalysisResult{})
+	if _, err := r.Start(blocked.run); err != nil {
+		t.Fatalf("Start() error = %v, want the finished job to be evicted", err)
+	}
+	if _, ok := r.Get(finished.ID); ok {
+		t.Error("Get() found the evicted job")
+	}
+
+	if _, err := r.Start(blocked.run); !errors.Is(err, ErrFull) {
+		t.Errorf("Start() error = %v, want ErrFull", err)
+	}
+}
+
+func TestRegistry_Shutdown(t *testing.T) {
+	tests := []struct {
+		name          string
+		finishes      bool
+		wantErr       error
+		wantState     State
+		wantTruncated bool
+	}{
+		{name: "Finishes In Time", finishes: true, wantState: Done},
+		{name: "Truncated", finishes: false, wantErr: context.DeadlineExceeded, wantState: Cancelled, wantTruncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry(Options{MaxJobs: 10, Retention: time.Hour})
+			job, _ := r.Start(func(ctx context.Context, id string, progress func(Progress)) aggregator.AnalysisResult {
+				if tt.finishes {
+					return aggregator.AnalysisResult{TotalPosts: 3}
+				}
+				<-ctx.Done()
+				return aggregator.AnalysisResult{TotalPosts: 2}
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if err := r.Shutdown(ctx); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Shutdown() error = %v, want %v", err, tt.wantErr)
+			}
+
+			got, _ := r.Get(job.ID)
+			if got.State != tt.wantState || got.Result == nil || got.Result.Truncated != tt.wantTruncated {
+				t.Errorf("Get() = %+v, want the job %s with a result truncated %v", got, tt.wantState, tt.wantTruncated)
+			}
+			if _, err := r.Start(newFakeAnalysis().run); !errors.Is(err, ErrShutdown) {
+				t.Errorf("Start() error = %v after Shutdown, want ErrShutdown", err)
+			}
+		})
+	}
+}
+
+/////// Helpers
+
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeAnalysis is an analysis reporting the progress sent on its channel until finished.
+type fakeAnalysis struct {
+	progress chan Progress
+	result   chan aggregator.AnalysisResult
+	once     sync.Once
+}
+
+func newFakeAnalysis() *fakeAnalysis {
+	return &fakeAnalysis{progress: make(chan Progress), result: make(chan aggregator.AnalysisResult)}
+}
+
+func (a *fakeAnalysis) run(ctx context.Context, id string, progress func(Progress)) aggregator.AnalysisResult {
+	for {
+		select {
+		case p := <-a.progress:
+			progress(p)
+		case result := <-a.result:
+			return result
+		}
+	}
+}
+
+// finish makes the analysis return the given result, once.
+func (a *fakeAnalysis) finish(result aggregator.AnalysisResult) {
+	a.once.Do(func() { a.result <- result })
+}
+
+// waitFinished waits for the job to record its result, and returns it.
+func waitFinished(t *testing.T, r *Registry, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := r.Get(id); ok && job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
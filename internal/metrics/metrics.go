@@ -0,0 +1,215 @@
+// Package metrics provides counters, gauges and histograms that are safe for
+// concurrent use, and exposes them in the Prometheus text exposition format
+// (version 0.0.4) without any external dependency.
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the histogram buckets used for latencies in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+
+// atomicFloat is a float64 that can be updated atomically.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+// add adds v to the value.
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// set sets the value to v.
+func (f *atomicFloat) set(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+// load returns the value.
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct {
+	value atomicFloat
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.value.add(1)
+}
+
+// Add adds v to the counter. Negative values are ignored, as counters never go down.
+func (c *Counter) Add(v float64) {
+	if v > 0 {
+		c.value.add(v)
+	}
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	return c.value.load()
+}
+
+// Gauge is a value that can go up and down, such as a number of in-flight requests.
+type Gauge struct {
+	value atomicFloat
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	g.value.set(v)
+}
+
+// Add adds v, which may be negative, to the gauge.
+func (g *Gauge) Add(v float64) {
+	g.value.add(v)
+}
+
+// Inc increments the gauge by 1.
+func (g *Gauge) Inc() {
+	g.value.add(1)
+}
+
+// Dec decrements the gauge by 1.
+func (g *Gauge) Dec() {
+	g.value.add(-1)
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	return g.value.load()
+}
+
+// Histogram counts observations, such as latencies, in configurable buckets.
+type Histogram struct {
+	upperBounds []float64
+	counts      []atomic.Uint64 // counts holds the non-cumulative count of each bucket, plus +Inf.
+	count       atomic.Uint64
+	sum         atomicFloat
+}
+
+// newHistogram creates a histogram with the given sorted bucket upper bounds.
+func newHistogram(upperBounds []float64) *Histogram {
+	return &Histogram{
+		upperBounds: upperBounds,
+		counts:      make([]atomic.Uint64, len(upperBounds)+1),
+	}
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.sum.add(v)
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	return h.count.Load()
+}
+
+// vec is a family of metrics of the same kind, distinguished by label values.
+type vec[T any] struct {
+	labels   []string
+	newChild func() T
+	mu       sync.RWMutex
+	children map[string]*child[T]
+}
+
+// child is a metric of a vec with its label values.
+type child[T any] struct {
+	values []string
+	metric T
+}
+
+// newVec creates a vec whose children are created by newChild.
+func newVec[T any](labels []string, newChild func() T) *vec[T] {
+	return &vec[T]{
+		labels:   labels,
+		newChild: newChild,
+		children: make(map[string]*child[T]),
+	}
+}
+
+// with returns the metric with the given label values, creating it if needed.
+// It panics if the number of values does not match the number of labels, as
+// that is a programming error.
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labels) {
+		panic("metrics: wrong number of label values")
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c.metric
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c.metric
+	}
+	c = &child[T]{values: append([]string(nil), values...), metric: v.newChild()}
+	v.children[key] = c
+	return c.metric
+}
+
+// sorted returns the children sorted by label values, for a stable output.
+func (v *vec[T]) sorted() []*child[T] {
+	v.mu.RLock()
+	children := make([]*child[T], 0, len(v.children))
+	for _, c := range v.children {
+		children = append(children, c)
+	}
+	v.mu.RUnlock()
+
+	sort.Slice(children, func(i, j int) bool {
+		return strings.Join(children[i].values, "\xff") < strings.Join(children[j].values, "\xff")
+	})
+	return children
+}
+
+// CounterVec is a family of counters distinguished by label values.
+type CounterVec struct {
+	*vec[*Counter]
+}
+
+// WithLabelValues returns the counter with the given label values, in the order of the labels.
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	return v.with(values)
+}
+
+// GaugeVec is a family of gauges distinguished by label values.
+type GaugeVec struct {
+	*vec[*Gauge]
+}
+
+// WithLabelValues returns the gauge with the given label values, in the order of the labels.
+func (v *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return v.with(values)
+}
+
+// HistogramVec is a family of histograms distinguished by label values.
+type HistogramVec struct {
+	*vec[*Histogram]
+}
+
+// WithLabelValues returns the histogram with the given label values, in the order of the labels.
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return v.with(values)
+}
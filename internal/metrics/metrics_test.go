@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounter("requests_total", "Number of requests.")
+	vec := registry.NewCounterVec("posts_total", "Number of posts,\nper type.", "type")
+	gauge := registry.NewGauge("in_flight", "In-flight analyses.")
+	gaugeVec := registry.NewGaugeVec("queue", "Queue length.", "name")
+	histogram := registry.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1})
+	histogramVec := registry.NewHistogramVec("size", "Size.", []float64{10}, "route")
+
+	counter.Inc()
+	counter.Add(2)
+	counter.Add(-5)
+	vec.WithLabelValues("tweet").Inc()
+	vec.WithLabelValues(`say "hi"\`).Add(3)
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+	gaugeVec.WithLabelValues("a").Set(1.5)
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+	histogramVec.WithLabelValues("/analysis").Observe(10)
+
+	var sb strings.Builder
+	if _, err := registry.WriteTo(&sb); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+
+	want := `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total 3
+# HELP posts_total Number of posts,\nper type.
+# TYPE posts_total counter
+posts_total{type="say \"hi\"\\"} 3
+posts_total{type="tweet"} 1
+# HELP in_flight In-flight analyses.
+# TYPE in_flight gauge
+in_flight 1
+# HELP queue Queue length.
+# TYPE queue gauge
+queue{name="a"} 1.5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+# HELP size Size.
+# TYPE size histogram
+size_bucket{route="/analysis",le="10"} 1
+size_bucket{route="/analysis",le="+Inf"} 1
+size_sum{route="/analysis"} 10
+size_count{route="/analysis"} 1
+`
+	if got := sb.String(); got != want {
+		t.Errorf("WriteTo() wrote:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("requests_total", "Number of requests.").Inc()
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "requests_total 1\n") {
+		t.Errorf("body = %q, want the counter", rec.Body.String())
+	}
+}
+
+func TestRegistry_DuplicateName(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("requests_total", "Number of requests.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate name did not panic")
+		}
+	}()
+	registry.NewGauge("requests_total", "Number of requests.")
+}
+
+func TestMetrics_Concurrent(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("requests_total", "Number of requests.", "route")
+	gauge := registry.NewGauge("in_flight", "In-flight analyses.")
+	histogram := registry.NewHistogram("latency_seconds", "Latency.", DefaultBuckets)
+
+	const workers, iterations = 8, 1000
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				counter.WithLabelValues("/analysis").Inc()
+				gauge.Inc()
+				histogram.Observe(0.5)
+				gauge.Dec()
+				if j%100 == 0 {
+					registry.WriteTo(&strings.Builder{})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := counter.WithLabelValues("/analysis").Value(); got != workers*iterations {
+		t.Errorf("counter = %v, want %v", got, workers*iterations)
+	}
+	if got := gauge.Value(); got != 0 {
+		t.Errorf("gauge = %v, want 0", got)
+	}
+	if got := histogram.Count(); got != workers*iterations {
+		t.Errorf("histogram count = %v, want %v", got, workers*iterations)
+	}
+}
@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry used by the packages of the service, exposed on /metrics.
+var Default = NewRegistry()
+
+// family is a named metric, with its help text and type, that writes its samples.
+type family struct {
+	name  string
+	help  string
+	kind  string
+	write func(w *bufio.Writer, name string)
+}
+
+// Registry holds metrics and writes them in the Prometheus text format.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds a family to the registry. It panics if the name is already
+// registered, as that is a programming error.
+func (r *Registry) register(f *family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.families {
+		if existing.name == f.name {
+			panic("metrics: duplicate metric " + f.name)
+		}
+	}
+	r.families = append(r.families, f)
+}
+
+// NewCounter creates and registers a counter.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	r.register(&family{name: name, help: help, kind: "counter", write: func(w *bufio.Writer, name string) {
+		writeSample(w, name, nil, nil, "", c.Value())
+	}})
+	return c
+}
+
+// NewCounterVec creates and registers a family of counters with the given labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := newVec(labels, func() *Counter { return &Counter{} })
+	r.register(&family{name: name, help: help, kind: "counter", write: func(w *bufio.Writer, name string) {
+		for _, c := range v.sorted() {
+			writeSample(w, name, labels, c.values, "", c.metric.Value())
+		}
+	}})
+	return &CounterVec{v}
+}
+
+// NewGauge creates and registers a gauge.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.register(&family{name: name, help: help, kind: "gauge", write: func(w *bufio.Writer, name string) {
+		writeSample(w, name, nil, nil, "", g.Value())
+	}})
+	return g
+}
+
+// NewGaugeVec creates and registers a family of gauges with the given labels.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := newVec(labels, func() *Gauge { return &Gauge{} })
+	r.register(&family{name: name, help: help, kind: "gauge", write: func(w *bufio.Writer, name string) {
+		for _, c := range v.sorted() {
+			writeSample(w, name, labels, c.values, "", c.metric.Value())
+		}
+	}})
+	return &GaugeVec{v}
+}
+
+// NewHistogram creates and registers a histogram with the given bucket upper bounds.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	buckets = sortedBuckets(buckets)
+	h := newHistogram(buckets)
+	r.register(&family{name: name, help: help, kind: "histogram", write: func(w *bufio.Writer, name string) {
+		writeHistogram(w, name, nil, nil, h)
+	}})
+	return h
+}
+
+// NewHistogramVec creates and registers a family of histograms with the given
+// bucket upper bounds and labels.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = sortedBuckets(buckets)
+	v := newVec(labels, func() *Histogram { return newHistogram(buckets) })
+	r.register(&family{name: name, help: help, kind: "histogram", write: func(w *bufio.Writer, name string) {
+		for _, c := range v.sorted() {
+			writeHistogram(w, name, labels, c.values, c.metric)
+		}
+	}})
+	return &HistogramVec{v}
+}
+
+// WriteTo writes every registered metric to w in the Prometheus text format,
+// in registration order.
+//
+// Parameters:
+//   - w: The writer to write the exposition to.
+//
+// Returns:
+//   - The number of bytes written and any write error.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		bw.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+		bw.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+		f.write(bw, f.name)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP writes the registered metrics as a Prometheus scrape response.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// writeHistogram writes the cumulative buckets, the sum and the count of a histogram.
+func writeHistogram(w *bufio.Writer, name string, labels, values []string, h *Histogram) {
+	labels = append(slices.Clip(labels), "le")
+	var cumulative uint64
+	for i, bound := range h.upperBounds {
+		cumulative += h.counts[i].Load()
+		writeSample(w, name+"_bucket", labels, append(slices.Clip(values), formatFloat(bound)), "", float64(cumulative))
+	}
+	cumulative += h.counts[len(h.upperBounds)].Load()
+	writeSample(w, name+"_bucket", labels, append(slices.Clip(values), "+Inf"), "", float64(cumulative))
+	writeSample(w, name, labels[:len(labels)-1], values, "_sum", h.sum.load())
+	writeSample(w, name, labels[:len(labels)-1], values, "_count", float64(h.Count()))
+}
+
+// writeSample writes one sample line, such as `name_suffix{label="value"} 1`.
+func writeSample(w *bufio.Writer, name string, labels, values []string, suffix string, value float64) {
+	w.WriteString(name)
+	w.WriteString(suffix)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label)
+			w.WriteString(`="`)
+			w.WriteString(escapeLabelValue(values[i]))
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// formatFloat formats a sample value as Prometheus expects it.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// escapeHelp escapes backslashes and line feeds in a help text.
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+// escapeLabelValue escapes backslashes, line feeds and double quotes in a label value.
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+// sortedBuckets returns a sorted copy of the buckets, without +Inf which is always added.
+func sortedBuckets(buckets []float64) []float64 {
+	sorted := slices.DeleteFunc(slices.Clone(buckets), func(b float64) bool { return math.IsInf(b, 1) })
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
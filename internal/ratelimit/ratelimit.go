@@ -0,0 +1,180 @@
+// Package ratelimit protects the server from clients running too many analyses.
+//
+// Every analysis holds a goroutine and a share of the upstream stream for its
+// whole duration, so two limits apply. Each client has a token bucket, refilled
+// at a constant rate, from which every request takes a token: a client can make
+// a burst of requests, then has to slow down to the rate. On top of that, the
+// number of analyses running at the same time is capped for all the clients.
+package ratelimit
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options configures a Limiter.
+type Options struct {
+	Rate          float64 // Rate is the number of requests per second each client can make, 0 for no limit.
+	Burst         int     // Burst is the number of requests a client can make at once, the size of its bucket.
+	MaxConcurrent int     // MaxConcurrent is the maximum number of analyses running at once, 0 for no limit.
+}
+
+// State is a snapshot of the state of a Limiter.
+type State struct {
+	Enabled            bool          `json:"enabled"`
+	Rate               float64       `json:"rate"`
+	Burst              int           `json:"burst"`
+	MaxConcurrent      int           `json:"max_concurrent"`
+	Running            int           `json:"running"`             // Running is the number of analyses running.
+	RateLimited        int64         `json:"rate_limited"`        // RateLimited is the number of requests rejected by the buckets of the clients.
+	ConcurrencyLimited int64         `json:"concurrency_limited"` // ConcurrencyLimited is the number of analyses rejected by the concurrency cap.
+	Clients            []ClientState `json:"clients"`             // Clients are the clients whose buckets are not full, most recently seen first.
+}
+
+// ClientState is the state of the bucket of a client.
+type ClientState struct {
+	Client   string    `json:"client"`
+	Tokens   float64   `json:"tokens"`   // Tokens is the number of requests the client can make right now, fractional while refilling.
+	Rejected int64     `json:"rejected"` // Rejected is the number of requests of the client rejected by its bucket.
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Limiter limits the rate of the requests of each client and the number of
+// analyses running at once. It is safe for concurrent use.
+type Limiter struct {
+	opts Options
+	now  func() time.Time
+
+	mu                 sync.Mutex
+	buckets            map[string]*bucket
+	lastSweep          time.Time
+	running            int
+	rateLimited        int64
+	concurrencyLimited int64
+}
+
+// bucket is the token bucket of a client.
+type bucket struct {
+	tokens   float64   // tokens is the number of tokens at updated.
+	updated  time.Time // updated is the last time the tokens were refilled.
+	lastSeen time.Time // lastSeen is the time of the last request of the client.
+	rejected int64
+}
+
+// New creates a Limiter with the given options.
+func New(opts Options) *Limiter {
+	return &Limiter{opts: opts, now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token from the bucket of a client, if there is one.
+//
+// Parameters:
+//   - client: The identifier of the client, such as its IP address or API key.
+//
+// Returns:
+//   - true if the request is allowed.
+//   - The time until the bucket holds a token again, if the request is rejected.
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	if l.opts.Rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.opts.Burst), updated: now}
+		l.buckets[client] = b
+	}
+	b.refill(now, l.opts)
+	b.lastSeen = now
+	if b.tokens < 1 {
+		b.rejected++
+		l.rateLimited++
+		return false, time.Duration((1 - b.tokens) / l.opts.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Acquire takes a slot for an analysis, if fewer than MaxConcurrent analyses
+// are running. The slot must be given back with Release once the analysis is
+// finished.
+//
+// Returns:
+//   - true if the analysis can run.
+func (l *Limiter) Acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.opts.MaxConcurrent > 0 && l.running >= l.opts.MaxConcurrent {
+		l.concurrencyLimited++
+		return false
+	}
+	l.running++
+	return true
+}
+
+// Release gives back the slot of a finished analysis, taken by Acquire.
+func (l *Limiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+}
+
+// State returns a snapshot of the state of the limiter.
+func (l *Limiter) State() State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	state := State{
+		Enabled:            true,
+		Rate:               l.opts.Rate,
+		Burst:              l.opts.Burst,
+		MaxConcurrent:      l.opts.MaxConcurrent,
+		Running:            l.running,
+		RateLimited:        l.rateLimited,
+		ConcurrencyLimited: l.concurrencyLimited,
+		Clients:            make([]ClientState, 0, len(l.buckets)),
+	}
+	for client, b := range l.buckets {
+		b.refill(now, l.opts)
+		state.Clients = append(state.Clients, ClientState{Client: client, Tokens: b.tokens, Rejected: b.rejected, LastSeen: b.lastSeen})
+	}
+	sort.Slice(state.Clients, func(i, j int) bool {
+		return state.Clients[i].LastSeen.After(state.Clients[j].LastSeen)
+	})
+	return state
+}
+
+// sweep forgets the buckets that are full again, which are the same as the
+// bucket of a new client, so that memory does not grow with the number of
+// clients seen. It runs at most once per time to refill a bucket. The limiter
+// must be locked.
+func (l *Limiter) sweep(now time.Time) {
+	if l.opts.Rate <= 0 || now.Sub(l.lastSweep) < time.Duration(float64(l.opts.Burst)/l.opts.Rate*float64(time.Second)) {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.buckets {
+		b.refill(now, l.opts)
+		if b.tokens >= float64(l.opts.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// refill adds the tokens earned since the last refill, up to the size of the bucket.
+func (b *bucket) refill(now time.Time, opts Options) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(b.tokens+elapsed.Seconds()*opts.Rate, float64(opts.Burst))
+		b.updated = now
+	}
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1714557600, 0)}
+	l := New(Options{Rate: 2, Burst: 3})
+	l.now = clock.Now
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("ip:10.0.0.1"); !ok {
+			t.Fatalf("request %d of the burst was rejected", i+1)
+		}
+	}
+	ok, retryAfter := l.Allow("ip:10.0.0.1")
+	if ok || retryAfter != 500*time.Millisecond {
+		t.Errorf("Allow() = %v, %v after the burst, want false, 500ms", ok, retryAfter)
+	}
+	if ok, _ := l.Allow("ip:10.0.0.2"); !ok {
+		t.Error("the request of another client was rejected")
+	}
+
+	clock.Advance(250 * time.Millisecond)
+	if ok, retryAfter := l.Allow("ip:10.0.0.1"); ok || retryAfter != 250*time.Millisecond {
+		t.Errorf("Allow() = %v, %v while refilling, want false, 250ms", ok, retryAfter)
+	}
+	clock.Advance(250 * time.Millisecond)
+	if ok, _ := l.Allow("ip:10.0.0.1"); !ok {
+		t.Error("the request was rejected once a token was refilled")
+	}
+
+	state := l.State()
+	if state.RateLimited != 2 || len(state.Clients) != 2 {
+		t.Fatalf("State() = %+v, want 2 rejections and 2 clients", state)
+	}
+	if client := state.Clients[0]; client.Client != "ip:10.0.0.1" || client.Rejected != 2 || client.Tokens != 0 {
+		t.Errorf("first client = %+v, want the last client seen, with an empty bucket", client)
+	}
+
+	clock.Advance(time.Minute)
+	if state := l.State(); len(state.Clients) != 0 {
+		t.Errorf("State() = %+v, want the full buckets to be forgotten", state)
+	}
+}
+
+func TestLimiter_AllowUnlimited(t *testing.T) {
+	l := New(Options{MaxConcurrent: 1})
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("ip:10.0.0.1"); !ok {
+			t.Fatalf("request %d was rejected without a rate", i+1)
+		}
+	}
+}
+
+func TestLimiter_Acquire(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxConcurrent int
+		acquired      int
+		want          bool
+	}{
+		{name: "BelowCap", maxConcurrent: 2, acquired: 1, want: true},
+		{name: "AtCap", maxConcurrent: 2, acquired: 2, want: false},
+		{name: "NoCap", maxConcurrent: 0, acquired: 100, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(Options{MaxConcurrent: tt.maxConcurrent})
+			for i := 0; i < tt.acquired; i++ {
+				l.Acquire()
+			}
+			if got := l.Acquire(); got != tt.want {
+				t.Errorf("Acquire() = %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				l.Release()
+				if !l.Acquire() {
+					t.Error("Acquire() = false after a release, want true")
+				}
+			}
+		})
+	}
+}
+
+func TestLimiter_State(t *testing.T) {
+	l := New(Options{Rate: 1, Burst: 5, MaxConcurrent: 1})
+	l.Acquire()
+	l.Acquire()
+
+	state := l.State()
+	if !state.Enabled || state.Rate != 1 || state.Burst != 5 || state.MaxConcurrent != 1 ||
+		state.Running != 1 || state.ConcurrencyLimited != 1 || state.Clients == nil {
+		t.Errorf("State() = %+v, want the options, 1 running analysis and 1 rejection", state)
+	}
+}
+
+/////// Helpers
+
+// fakeClock is a clock advanced by hand.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
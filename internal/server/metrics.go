@@ -0,0 +1,70 @@
+package server
+
+import (
+	"upfcc/internal/metrics"
+
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTP metrics, exposed on /metrics.
+var (
+	requestsTotal = metrics.Default.NewCounterVec("upfcc_http_requests_total",
+		"Number of HTTP requests served, per route and status code.", "route", "status")
+	requestDuration = metrics.Default.NewHistogramVec("upfcc_http_request_duration_seconds",
+		"Time taken to serve HTTP requests, per route.", metrics.DefaultBuckets, "route")
+)
+
+// statusRecorder is an http.ResponseWriter that records the status code of the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code and writes it to the underlying writer.
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 status code and writes to the underlying writer.
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush flushes the underlying writer, so that streamed responses keep working.
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, for use by http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument serves a request with next and records its status and latency
+// under the given route. The route is one of the known paths, so that unknown
+// paths do not create a metric each.
+func instrument(route string, w http.ResponseWriter, r *http.Request, next func(http.ResponseWriter, *http.Request)) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w}
+	next(rec, r)
+
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	requestsTotal.WithLabelValues(route, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+}
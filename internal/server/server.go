@@ -3,18 +3,54 @@
 package server
 
 import (
+	"upfcc/internal/metrics"
+
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// shutdownMargin is the time left to handlers to write their partial results
+// once the grace period is over, before the remaining connections are closed.
+const shutdownMargin = 5 * time.Second
+
 // Handler is an interface that defines the methods required to handle
 // different types of HTTP requests.
 type Handler interface {
 	AnalysisHandler(w http.ResponseWriter, r *http.Request)
+	AnalysisStreamHandler(w http.ResponseWriter, r *http.Request)
+	AnalysisJobsHandler(w http.ResponseWriter, r *http.Request)
+	AnalysisJobHandler(w http.ResponseWriter, r *http.Request)
+	HealthHandler(w http.ResponseWriter, r *http.Request)
+	ReadinessHandler(w http.ResponseWriter, r *http.Request)
+	DimensionsHandler(w http.ResponseWriter, r *http.Request)
+	DeliveriesHandler(w http.ResponseWriter, r *http.Request)
+	RateLimitHandler(w http.ResponseWriter, r *http.Request)
 }
 
 // Server represents an HTTP server with a specific handler for processing requests.
 type Server struct {
-	handler Handler
+	handler  Handler
+	draining atomic.Bool // draining is set once the server is shutting down.
+}
+
+// ListenConfig holds the settings used by Run to serve HTTP requests.
+type ListenConfig struct {
+	Addr              string        // Addr is the TCP address to listen on, such as ":8080".
+	GracePeriod       time.Duration // GracePeriod is the time given to in-flight analyses to finish on shutdown.
+	ReadHeaderTimeout time.Duration // ReadHeaderTimeout bounds the time to read request headers, 0 for none.
+	ReadTimeout       time.Duration // ReadTimeout bounds the time to read a whole request, 0 for none.
+	WriteTimeout      time.Duration // WriteTimeout bounds the time to write a response, 0 for none.
+	IdleTimeout       time.Duration // IdleTimeout bounds the time a keep-alive connection stays idle.
+	TLSCertFile       string        // TLSCertFile is the certificate file, HTTPS is served when it is set with TLSKeyFile.
+	TLSKeyFile        string        // TLSKeyFile is the private key file of the certificate.
+	TLSMinVersion     uint16        // TLSMinVersion is the minimum TLS version accepted, such as tls.VersionTLS12.
 }
 
 // New creates a new instance of the Server with the given handler.
@@ -25,13 +61,131 @@ func New(handler Handler) *Server {
 
 // ServeHTTP routes incoming HTTP requests to the appropriate handler function
 // based on the request URL path. If the URL path matches "/analysis", it invokes
-// the AnalysisHandler function of the provided handler. For any other paths, it
-// returns a 404 Not Found response.
+// the AnalysisHandler function of the provided handler, and if it matches
+// "/analysis/stream", the AnalysisStreamHandler function. "/analysis/jobs" starts
+// analysis jobs, which are served under "/analysis/jobs/{id}". "/healthz" and "/readyz"
+// report the liveness and the readiness of the service, "/dimensions" lists the
+// dimensions that can be analyzed, "/deliveries" lists the deliveries of results
+// to callback URLs, "/ratelimit" reports the state of the rate limiter, and
+// "/metrics" exposes its metrics in the Prometheus text format. For any other
+// paths, it returns a 404 Not Found response.
+//
+// Once the server is shutting down, requests starting new analyses or jobs and
+// readiness checks are rejected with a 503 Service Unavailable response, so that load balancers
+// stop sending traffic to the instance.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
+	route, next := s.route(r.URL.Path)
+	instrument(route, w, r, next)
+}
+
+// route returns the name of the route matching the path, as used in metrics,
+// and the function serving it.
+func (s *Server) route(path string) (string, func(http.ResponseWriter, *http.Request)) {
+	route, next := "other", http.NotFound
+	switch path {
 	case "/analysis":
-		s.handler.AnalysisHandler(w, r)
+		route, next = path, s.handler.AnalysisHandler
+	case "/analysis/stream":
+		route, next = path, s.handler.AnalysisStreamHandler
+	case "/analysis/jobs":
+		route, next = path, s.handler.AnalysisJobsHandler
+	case "/healthz":
+		route, next = path, s.handler.HealthHandler
+	case "/readyz":
+		route, next = path, s.handler.ReadinessHandler
+	case "/dimensions":
+		route, next = path, s.handler.DimensionsHandler
+	case "/deliveries":
+		route, next = path, s.handler.DeliveriesHandler
+	case "/ratelimit":
+		route, next = path, s.handler.RateLimitHandler
+	case "/metrics":
+		route, next = path, metrics.Default.ServeHTTP
 	default:
-		http.NotFound(w, r)
+		if id, ok := strings.CutPrefix(path, "/analysis/jobs/"); ok && id != "" && !strings.Contains(id, "/") {
+			route, next = "/analysis/jobs/{id}", s.handler.AnalysisJobHandler
+		}
+	}
+
+	// Only the routes starting new analyses are rejected while draining, so that
+	// the jobs left to finish during the grace period can still be polled and cancelled.
+	if s.draining.Load() && (route == "/analysis" || route == "/analysis/stream" || route == "/analysis/jobs" || route == "/readyz") {
+		next = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Connection", "close")
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		}
+	}
+	return route, next
+}
+
+// Run listens on the configured address and serves HTTP requests until ctx is done.
+// It then shuts the server down gracefully: new analysis requests are rejected,
+// and in-flight analyses are given the grace period to finish. Analyses still
+// running after the grace period are stopped and return their partial result,
+// marked as truncated.
+//
+// Parameters:
+//   - ctx: A context whose cancellation starts the shutdown (e.g., on SIGTERM).
+//   - cfg: The address to listen on, the timeouts, the TLS settings and the shutdown grace period.
+//
+// Returns:
+//   - nil once the server has shut down, or the error that stopped it.
+func (s *Server) Run(ctx context.Context, cfg ListenConfig) error {
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return s.serve(ctx, ln, cfg)
+}
+
+// serve serves HTTP requests on the listener until ctx is done, then shuts down
+// gracefully as described in Run.
+func (s *Server) serve(ctx context.Context, ln net.Listener, cfg ListenConfig) error {
+	// Requests derive their context from baseCtx, so cancelling it stops every
+	// in-flight analysis at once when the grace period is over.
+	baseCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	httpServer := &http.Server{
+		Handler:           s,
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
+			httpServer.TLSConfig = &tls.Config{MinVersion: cfg.TLSMinVersion}
+			serveErr <- httpServer.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile)
+			return
+		}
+		serveErr <- httpServer.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down, waiting for in-flight analyses", "grace_period", cfg.GracePeriod)
+	s.draining.Store(true)
+	timer := time.AfterFunc(cfg.GracePeriod, func() {
+		slog.Warn("Grace period is over, truncating in-flight analyses")
+		abort()
+	})
+	defer timer.Stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.GracePeriod+shutdownMargin)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
+	return nil
 }
@@ -1,9 +1,14 @@
 package server
 
 import (
+	"context"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestServer_ServeHTTP(t *testing.T) {
@@ -21,6 +26,54 @@ func TestServer_ServeHTTP(t *testing.T) {
 				w.WriteHeader(http.StatusOK)
 			},
 		},
+		{
+			name:           "valid path /analysis/stream",
+			path:           "/analysis/stream",
+			expectedStatus: http.StatusAccepted,
+			handlerFunc: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+			},
+		},
+		{
+			name:           "valid path /healthz",
+			path:           "/healthz",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "valid path /readyz",
+			path:           "/readyz",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "valid path /analysis/jobs",
+			path:           "/analysis/jobs",
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:           "valid path /analysis/jobs/{id}",
+			path:           "/analysis/jobs/0123abcd",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "invalid path /analysis/jobs/{id}/more",
+			path:           "/analysis/jobs/0123abcd/more",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "valid path /dimensions",
+			path:           "/dimensions",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "valid path /deliveries",
+			path:           "/deliveries",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "valid path /ratelimit",
+			path:           "/ratelimit",
+			expectedStatus: http.StatusOK,
+		},
 		{
 			name:           "invalid path",
 			path:           "/invalid",
@@ -35,6 +88,30 @@ func TestServer_ServeHTTP(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockHandler := &MockHandler{
 				AnalysisHandlerFunc: tt.handlerFunc,
+				AnalysisStreamHandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusAccepted)
+				},
+				HealthHandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				},
+				ReadinessHandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				},
+				AnalysisJobsHandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusAccepted)
+				},
+				AnalysisJobHandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				},
+				DimensionsHandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				},
+				DeliveriesHandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				},
+				RateLimitHandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				},
 			}
 			server := New(mockHandler)
 
@@ -50,13 +127,203 @@ func TestServer_ServeHTTP(t *testing.T) {
 	}
 }
 
+func TestServer_ServeHTTPDraining(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	mockHandler := &MockHandler{
+		AnalysisHandlerFunc:    ok,
+		AnalysisJobHandlerFunc: ok,
+		HealthHandlerFunc:      ok,
+		ReadinessHandlerFunc:   ok,
+	}
+	server := New(mockHandler)
+	server.draining.Store(true)
+
+	for request, want := range map[string]int{
+		"GET /analysis":           http.StatusServiceUnavailable,
+		"GET /analysis/stream":    http.StatusServiceUnavailable,
+		"POST /analysis/jobs":     http.StatusServiceUnavailable,
+		"GET /analysis/jobs/1":    http.StatusOK,
+		"DELETE /analysis/jobs/1": http.StatusOK,
+		"GET /readyz":             http.StatusServiceUnavailable,
+		"GET /healthz":            http.StatusOK,
+	} {
+		method, path, _ := strings.Cut(request, " ")
+		req := httptest.NewRequest(method, path, nil)
+		rec := httptest.NewRecorder()
+
+		server.ServeHTTP(rec, req)
+
+		if rec.Code != want {
+			t.Errorf("%s: expected status %d, got %d", request, want, rec.Code)
+		}
+	}
+}
+
+func TestServer_ServeHTTPMetrics(t *testing.T) {
+	mockHandler := &MockHandler{
+		AnalysisStreamHandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := w.(http.Flusher); !ok {
+				t.Error("the response writer of the stream handler does not implement http.Flusher")
+			}
+			w.WriteHeader(http.StatusTeapot)
+		},
+		AnalysisJobHandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		},
+	}
+	server := New(mockHandler)
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/analysis/stream", nil))
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/analysis/jobs/0123abcd", nil))
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unknown/path", nil))
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("expected the Prometheus content type, got %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`upfcc_http_requests_total{route="/analysis/stream",status="418"} `,
+		`upfcc_http_requests_total{route="other",status="404"} `,
+		`upfcc_http_requests_total{route="/analysis/jobs/{id}",status="404"} `,
+		`upfcc_http_request_duration_seconds_count{route="/analysis/stream"} `,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestServer_serveGracefulShutdown(t *testing.T) {
+	tests := []struct {
+		name          string
+		gracePeriod   time.Duration
+		handlerDelay  time.Duration
+		wantTruncated bool
+	}{
+		{
+			name:          "analysis finishes within the grace period",
+			gracePeriod:   time.Second,
+			handlerDelay:  50 * time.Millisecond,
+			wantTruncated: false,
+		},
+		{
+			name:          "analysis truncated after the grace period",
+			gracePeriod:   50 * time.Millisecond,
+			handlerDelay:  time.Hour,
+			wantTruncated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			mockHandler := &MockHandler{
+				AnalysisHandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+					close(started)
+					select {
+					case <-time.After(tt.handlerDelay):
+						w.Write([]byte("complete"))
+					case <-r.Context().Done():
+						w.Write([]byte("truncated"))
+					}
+				},
+			}
+			server := New(mockHandler)
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			serveErr := make(chan error, 1)
+			go func() {
+				serveErr <- server.serve(ctx, ln, ListenConfig{GracePeriod: tt.gracePeriod})
+			}()
+
+			body := make(chan string, 1)
+			go func() {
+				resp, err := http.Get("http://" + ln.Addr().String() + "/analysis")
+				if err != nil {
+					body <- err.Error()
+					return
+				}
+				defer resp.Body.Close()
+				b, _ := io.ReadAll(resp.Body)
+				body <- string(b)
+			}()
+
+			<-started
+			cancel()
+
+			want := "complete"
+			if tt.wantTruncated {
+				want = "truncated"
+			}
+			if got := <-body; got != want {
+				t.Errorf("expected in-flight response %q, got %q", want, got)
+			}
+			if err := <-serveErr; err != nil {
+				t.Errorf("serve() returned error %v", err)
+			}
+		})
+	}
+}
+
 /////// Helpers
 
 // MockHandler is a mock implementation of the Handler interface.
 type MockHandler struct {
-	AnalysisHandlerFunc func(w http.ResponseWriter, r *http.Request)
+	AnalysisHandlerFunc       func(w http.ResponseWriter, r *http.Request)
+	AnalysisStreamHandlerFunc func(w http.ResponseWriter, r *http.Request)
+	AnalysisJobsHandlerFunc   func(w http.ResponseWriter, r *http.Request)
+	AnalysisJobHandlerFunc    func(w http.ResponseWriter, r *http.Request)
+	HealthHandlerFunc         func(w http.ResponseWriter, r *http.Request)
+	ReadinessHandlerFunc      func(w http.ResponseWriter, r *http.Request)
+	DimensionsHandlerFunc     func(w http.ResponseWriter, r *http.Request)
+	DeliveriesHandlerFunc     func(w http.ResponseWriter, r *http.Request)
+	RateLimitHandlerFunc      func(w http.ResponseWriter, r *http.Request)
 }
 
 func (m *MockHandler) AnalysisHandler(w http.ResponseWriter, r *http.Request) {
 	m.AnalysisHandlerFunc(w, r)
 }
+
+func (m *MockHandler) AnalysisStreamHandler(w http.ResponseWriter, r *http.Request) {
+	m.AnalysisStreamHandlerFunc(w, r)
+}
+
+func (m *MockHandler) AnalysisJobsHandler(w http.ResponseWriter, r *http.Request) {
+	m.AnalysisJobsHandlerFunc(w, r)
+}
+
+func (m *MockHandler) AnalysisJobHandler(w http.ResponseWriter, r *http.Request) {
+	m.AnalysisJobHandlerFunc(w, r)
+}
+
+func (m *MockHandler) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	m.HealthHandlerFunc(w, r)
+}
+
+func (m *MockHandler) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	m.ReadinessHandlerFunc(w, r)
+}
+
+func (m *MockHandler) DimensionsHandler(w http.ResponseWriter, r *http.Request) {
+	m.DimensionsHandlerFunc(w, r)
+}
+
+func (m *MockHandler) DeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	m.DeliveriesHandlerFunc(w, r)
+}
+
+func (m *MockHandler) RateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	m.RateLimitHandlerFunc(w, r)
+}
@@ -0,0 +1,161 @@
+package sseclient
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// subscriberBuffer is the number of posts buffered for each subscriber. When a
+// subscriber falls this far behind, new posts are dropped for that subscriber
+// only, so that a slow consumer cannot stall the others. The drops are counted
+// per subscriber and reported by Subscribe.
+const subscriberBuffer = 256
+
+// stream represents a single upstream connection shared by all the subscribers.
+type stream struct {
+	cancel context.CancelFunc // cancel closes the upstream connection.
+}
+
+// subscriber is a consumer of the shared stream with its own channel and deadline.
+type subscriber struct {
+	postChan chan Post
+	timer    *time.Timer
+	release  func() bool // release stops watching the context of the subscriber.
+	dropped  int         // dropped is the number of posts dropped because the subscriber fell behind.
+}
+
+// ReadStream subscribes to the SSE stream for a given duration.
+// It returns a channel of Post structs that can be consumed by the caller.
+//
+// All the subscribers share one upstream connection: it is opened by the first
+// subscriber and closed once the last one has left, so concurrent callers see
+// the same posts. The returned channel is closed when the duration has elapsed
+// or the context is done, whichever comes first; if the upstream connection
+// drops in the meantime it is re-established.
+func (c *SSEClient) ReadStream(ctx context.Context, duration time.Duration) chan Post {
+	postChan, _ := c.Subscribe(ctx, duration)
+	return postChan
+}
+
+// Subscribe is like ReadStream, and also returns a function reporting the number
+// of posts dropped for this subscriber because it fell behind the stream. Once
+// the channel is closed, the count is final.
+func (c *SSEClient) Subscribe(ctx context.Context, duration time.Duration) (chan Post, func() int) {
+	sub := &subscriber{postChan: make(chan Post, subscriberBuffer)}
+	dropped := func() int {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return sub.dropped
+	}
+	if ctx.Err() != nil {
+		close(sub.postChan)
+		return sub.postChan, dropped
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.subscribers[sub] = struct{}{}
+	subscribersGauge.Inc()
+	if c.stream == nil {
+		c.start()
+	}
+	sub.timer = time.AfterFunc(duration, func() { c.unsubscribe(sub) })
+	sub.release = context.AfterFunc(ctx, func() { c.unsubscribe(sub) })
+
+	return sub.postChan, dropped
+}
+
+// start opens a new upstream connection and broadcasts its posts.
+// It must be called with c.mu held.
+func (c *SSEClient) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &stream{cancel: cancel}
+	c.stream = s
+
+	go func() {
+		postChan := make(chan Post)
+		go c.connect(ctx, postChan)
+
+		for post := range postChan {
+			c.broadcast(s, post)
+		}
+		c.stop(s)
+	}()
+}
+
+// broadcast sends a post read from the given stream to every subscriber. The
+// first post dropped for a subscriber is logged; the following ones are only
+// counted, so that a stalled subscriber cannot flood the log.
+func (c *SSEClient) broadcast(s *stream, post Post) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stream != s {
+		return
+	}
+	longest := 0
+	for sub := range c.subscribers {
+		select {
+		case sub.postChan <- post:
+		default:
+			droppedPosts.Inc()
+			sub.dropped++
+			if sub.dropped == 1 {
+				slog.Warn("Dropping posts for slow SSE subscriber", "buffer", subscriberBuffer)
+			}
+		}
+		longest = max(longest, len(sub.postChan))
+	}
+	queueLength.Set(float64(longest))
+}
+
+// unsubscribe removes a subscriber and closes its channel. The upstream
+// connection is closed when no subscribers are left, unless it is kept open by
+// KeepConnected.
+func (c *SSEClient) unsubscribe(sub *subscriber) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.subscribers[sub]; !ok {
+		return
+	}
+	sub.timer.Stop()
+	sub.release()
+	delete(c.subscribers, sub)
+	close(sub.postChan)
+	subscribersGauge.Dec()
+	c.stopIfUnused()
+}
+
+// stopIfUnused closes the upstream connection when no subscribers are left and
+// no KeepConnected call holds it open. It must be called with c.mu held.
+func (c *SSEClient) stopIfUnused() {
+	if len(c.subscribers) == 0 && c.holds == 0 && c.stream != nil {
+		c.stream.cancel()
+		c.stream = nil
+		c.status.set(StateIdle, "")
+	}
+}
+
+// stop is called when the given stream has ended. If it is still the current
+// stream, all the subscribers are removed and their channels closed.
+func (c *SSEClient) stop(s *stream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s.cancel()
+	if c.stream != s {
+		return
+	}
+	c.stream = nil
+	c.status.set(StateIdle, "")
+	for sub := range c.subscribers {
+		sub.timer.Stop()
+		sub.release()
+		delete(c.subscribers, sub)
+		close(sub.postChan)
+		subscribersGauge.Dec()
+	}
+}
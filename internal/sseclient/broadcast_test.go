@@ -0,0 +1,157 @@
+package sseclient
+
+import (
+	"upfcc/internal/testingTools"
+
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSSEClient_ReadStreamSharesConnection(t *testing.T) {
+	var connections atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connections.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		<-release
+		w.Write([]byte("data: {\"tweet\":{\"timestamp\":1234567890,\"likes\":1}}\n\n"))
+		w.Write([]byte("data: {\"pin\":{\"timestamp\":1234567891,\"likes\":2}}\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := New(server.URL)
+	subscribers := []chan Post{
+		client.ReadStream(context.Background(), 200*time.Millisecond),
+		client.ReadStream(context.Background(), 300*time.Millisecond),
+		client.ReadStream(context.Background(), 400*time.Millisecond),
+	}
+	close(release)
+
+	var wg sync.WaitGroup
+	got := make([][]Post, len(subscribers))
+	for i, postChan := range subscribers {
+		wg.Add(1)
+		go func(i int, postChan chan Post) {
+			defer wg.Done()
+			for post := range postChan {
+				got[i] = append(got[i], post)
+			}
+		}(i, postChan)
+	}
+	wg.Wait()
+
+	if n := connections.Load(); n != 1 {
+		t.Errorf("ReadStream() opened %d upstream connections, want 1", n)
+	}
+	for i, posts := range got {
+		if len(posts) != 2 || posts[0].Type != "tweet" || posts[1].Type != "pin" {
+			t.Errorf("subscriber %d got %v, want the tweet and the pin", i, posts)
+		}
+	}
+}
+
+func TestSSEClient_ReadStreamClosesIdleConnection(t *testing.T) {
+	var connections atomic.Int32
+	closed := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connections.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		closed <- struct{}{}
+	}))
+	defer server.Close()
+
+	client := New(server.URL)
+	for range client.ReadStream(context.Background(), 50*time.Millisecond) {
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("upstream connection was not closed after the last subscriber left")
+	}
+
+	for range client.ReadStream(context.Background(), 50*time.Millisecond) {
+	}
+	if n := connections.Load(); n != 2 {
+		t.Errorf("ReadStream() opened %d upstream connections, want 2", n)
+	}
+}
+
+func TestSSEClient_SubscribeCountsDroppedPosts(t *testing.T) {
+	const extra = 5
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < subscriberBuffer+extra; i++ {
+			w.Write([]byte("data: {\"tweet\":{\"timestamp\":1234567890,\"likes\":1}}\n\n"))
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := New(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	postChan, dropped := client.Subscribe(ctx, time.Hour)
+	for deadline := time.Now().Add(time.Second); dropped() < extra && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	received := 0
+	for range postChan {
+		received++
+	}
+	if received != subscriberBuffer || dropped() != extra {
+		t.Errorf("Subscribe() received %d posts and dropped %d, want %d and %d", received, dropped(), subscriberBuffer, extra)
+	}
+}
+
+func TestSSEClient_ReadStreamContextCancelled(t *testing.T) {
+	defer testingTools.CheckNoGoroutineLeak(t)()
+
+	connected := make(chan struct{}, 1)
+	closed := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		connected <- struct{}{}
+		<-r.Context().Done()
+		closed <- struct{}{}
+	}))
+	defer server.Close()
+	defer http.DefaultClient.CloseIdleConnections()
+
+	client := New(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	postChan := client.ReadStream(ctx, time.Hour)
+	<-connected
+	cancel()
+
+	select {
+	case _, ok := <-postChan:
+		if ok {
+			t.Error("ReadStream() sent a post, want the channel closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadStream() channel was not closed when the context was cancelled")
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("upstream connection was not closed when the context was cancelled")
+	}
+
+	if _, ok := <-client.ReadStream(ctx, time.Hour); ok {
+		t.Error("ReadStream() with a cancelled context sent a post, want the channel closed")
+	}
+}
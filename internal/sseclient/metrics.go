@@ -0,0 +1,39 @@
+package sseclient
+
+import (
+	"upfcc/internal/metrics"
+
+	"slices"
+)
+
+// Metrics of the upstream SSE connection, exposed on /metrics.
+var (
+	connectionAttempts = metrics.Default.NewCounter("upfcc_upstream_connection_attempts_total",
+		"Number of connections attempted to the upstream SSE stream.")
+	connectionFailures = metrics.Default.NewCounter("upfcc_upstream_connection_failures_total",
+		"Number of upstream connections that failed or ended with an error.")
+	reconnects = metrics.Default.NewCounter("upfcc_upstream_reconnects_total",
+		"Number of reconnections to the upstream SSE stream.")
+	postsReceived = metrics.Default.NewCounterVec("upfcc_upstream_posts_total",
+		"Number of posts received from the upstream SSE stream, per post type.", "type")
+	parseErrors = metrics.Default.NewCounter("upfcc_upstream_parse_errors_total",
+		"Number of upstream events whose data is not valid JSON.")
+	subscribersGauge = metrics.Default.NewGauge("upfcc_stream_subscribers",
+		"Number of analyses currently subscribed to the shared upstream stream.")
+	droppedPosts = metrics.Default.NewCounter("upfcc_stream_dropped_posts_total",
+		"Number of posts dropped because a subscriber did not keep up with the stream.")
+	queueLength = metrics.Default.NewGauge("upfcc_stream_max_queue_length",
+		"Number of posts waiting in the fullest subscriber buffer at the last broadcast.")
+)
+
+// otherPostType is the label under which the posts of the types missing from
+// PostTypes are counted, so that the upstream cannot create new series at will.
+const otherPostType = "other"
+
+// postTypeLabel returns the label value counting the posts of the given type.
+func postTypeLabel(postType string) string {
+	if slices.Contains(PostTypes, postType) {
+		return postType
+	}
+	return otherPostType
+}
@@ -0,0 +1,184 @@
+package sseclient
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLineSize is the longest line accepted by the Parser. Longer lines abort
+// the stream with ErrLineTooLong rather than growing the buffer without bound.
+const maxLineSize = 1 << 20
+
+// ErrLineTooLong is returned by Parser.Next when a line exceeds maxLineSize.
+var ErrLineTooLong = errors.New("sseclient: line too long")
+
+// Event represents a single event dispatched from an SSE stream.
+type Event struct {
+	ID    string        // ID is the last event ID at the time the event was dispatched.
+	Type  string        // Type is the event type, "message" when the stream does not set one.
+	Data  string        // Data is the event payload, with multiple "data:" lines joined by "\n".
+	Retry time.Duration // Retry is the reconnection time requested by the stream, zero if never set.
+}
+
+// Parser reads events from an SSE stream following the event stream
+// interpretation rules of the WHATWG HTML specification:
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+//
+// Lines may end with CRLF, LF or CR, a leading byte order mark is ignored,
+// lines starting with ":" are comments, and an event is dispatched on every
+// blank line if it has at least one "data:" field.
+type Parser struct {
+	r           *bufio.Reader
+	started     bool // started reports whether the byte order mark check was done.
+	skipLF      bool // skipLF is set when the previous line ended with a CR.
+	lastEventID string
+	retry       time.Duration
+}
+
+// NewParser creates a new Parser reading an SSE stream from r.
+func NewParser(r io.Reader) *Parser {
+	return &Parser{r: bufio.NewReader(r)}
+}
+
+// LastEventID returns the last event ID set by the blocks of the stream that
+// were terminated by a blank line, including blocks that did not dispatch an
+// event. The ID of an unterminated block is not committed, so that a stream cut
+// in the middle of an event resumes from the event before it.
+func (p *Parser) LastEventID() string {
+	return p.lastEventID
+}
+
+// Retry returns the reconnection time requested by the stream, zero if never set.
+func (p *Parser) Retry() time.Duration {
+	return p.retry
+}
+
+// Next reads the stream until the next event is dispatched and returns it.
+// It returns io.EOF at the end of the stream; an event that is not terminated
+// by a blank line before the end of the stream is discarded.
+func (p *Parser) Next() (Event, error) {
+	var data strings.Builder
+	var eventType string
+	hasData := false
+	// The ID of the block is buffered until the blank line terminating it, as
+	// the last event ID buffer of the specification.
+	var pendingID string
+	hasID := false
+
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return Event{}, err
+		}
+
+		if len(line) == 0 {
+			if hasID {
+				p.lastEventID = pendingID
+				hasID = false
+			}
+			if !hasData {
+				eventType = ""
+				continue
+			}
+			event := Event{
+				ID:    p.lastEventID,
+				Type:  eventType,
+				Data:  strings.TrimSuffix(data.String(), "\n"),
+				Retry: p.retry,
+			}
+			if event.Type == "" {
+				event.Type = "message"
+			}
+			return event, nil
+		}
+
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			value = bytes.TrimPrefix(value, []byte(" "))
+		}
+
+		switch string(field) {
+		case "event":
+			eventType = string(value)
+		case "data":
+			data.Write(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				pendingID = string(value)
+				hasID = true
+			}
+		case "retry":
+			if isDigits(value) {
+				if ms, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+					p.retry = time.Duration(ms) * time.Millisecond
+				}
+			}
+		}
+	}
+}
+
+// readLine returns the next line of the stream without its line terminator.
+// A line is terminated by CRLF, LF or CR.
+func (p *Parser) readLine() ([]byte, error) {
+	if !p.started {
+		p.started = true
+		if bom, err := p.r.Peek(3); err == nil && bytes.Equal(bom, []byte("\xEF\xBB\xBF")) {
+			p.r.Discard(3)
+		}
+	}
+
+	var line []byte
+	for {
+		b, err := p.r.ReadByte()
+		if err != nil {
+			// A line that is not terminated before the end of the stream is
+			// incomplete, so it is discarded along with the pending event.
+			return nil, err
+		}
+
+		if p.skipLF {
+			p.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+
+		switch b {
+		case '\n':
+			return line, nil
+		case '\r':
+			p.skipLF = true
+			return line, nil
+		}
+
+		if len(line) >= maxLineSize {
+			return nil, ErrLineTooLong
+		}
+		line = append(line, b)
+	}
+}
+
+// isDigits reports whether b is a non-empty sequence of ASCII digits.
+func isDigits(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,240 @@
+package sseclient
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParser_Next(t *testing.T) {
+	tests := []struct {
+		name        string
+		stream      string
+		expected    []Event
+		lastEventID string
+	}{
+		{
+			name:     "Single Event",
+			stream:   "data: hello\n\n",
+			expected: []Event{{Type: "message", Data: "hello"}},
+		},
+		{
+			name:     "Data Without Space",
+			stream:   "data:hello\n\n",
+			expected: []Event{{Type: "message", Data: "hello"}},
+		},
+		{
+			name:     "Only First Space Is Removed",
+			stream:   "data:  hello\n\n",
+			expected: []Event{{Type: "message", Data: " hello"}},
+		},
+		{
+			name:     "Multi Line Data",
+			stream:   "data: first\ndata: second\n\n",
+			expected: []Event{{Type: "message", Data: "first\nsecond"}},
+		},
+		{
+			name:     "Empty Data Line",
+			stream:   "data\ndata\n\n",
+			expected: []Event{{Type: "message", Data: "\n"}},
+		},
+		{
+			name:     "Event Type",
+			stream:   "event: tweet\ndata: hello\n\ndata: world\n\n",
+			expected: []Event{{Type: "tweet", Data: "hello"}, {Type: "message", Data: "world"}},
+		},
+		{
+			name:        "Event ID Persists",
+			stream:      "id: 1\ndata: a\n\ndata: b\n\nid\ndata: c\n\n",
+			expected:    []Event{{ID: "1", Type: "message", Data: "a"}, {ID: "1", Type: "message", Data: "b"}, {Type: "message", Data: "c"}},
+			lastEventID: "",
+		},
+		{
+			name:        "ID With NULL Is Ignored",
+			stream:      "id: 1\ndata: a\n\nid: 2\x003\ndata: b\n\n",
+			expected:    []Event{{ID: "1", Type: "message", Data: "a"}, {ID: "1", Type: "message", Data: "b"}},
+			lastEventID: "1",
+		},
+		{
+			name:        "ID Without Data Is Not Dispatched",
+			stream:      "id: 7\n\n",
+			expected:    nil,
+			lastEventID: "7",
+		},
+		{
+			name:        "ID Of Unterminated Event Is Not Committed",
+			stream:      "id: 1\ndata: a\n\nid: 2\ndata: b\n",
+			expected:    []Event{{ID: "1", Type: "message", Data: "a"}},
+			lastEventID: "1",
+		},
+		{
+			name:     "Retry",
+			stream:   "retry: 1500\ndata: a\n\nretry: soon\ndata: b\n\n",
+			expected: []Event{{Type: "message", Data: "a", Retry: 1500 * time.Millisecond}, {Type: "message", Data: "b", Retry: 1500 * time.Millisecond}},
+		},
+		{
+			name:     "Comments",
+			stream:   ": keep-alive\ndata: a\n:another\n\n",
+			expected: []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:     "Unknown Fields",
+			stream:   "foo: bar\ndata: a\nDATA: b\n\n",
+			expected: []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:     "Field Value With Colon",
+			stream:   "data: {\"a\":1}\n\n",
+			expected: []Event{{Type: "message", Data: "{\"a\":1}"}},
+		},
+		{
+			name:     "CRLF Line Endings",
+			stream:   "data: a\r\ndata: b\r\n\r\n",
+			expected: []Event{{Type: "message", Data: "a\nb"}},
+		},
+		{
+			name:     "CR Line Endings",
+			stream:   "data: a\rdata: b\r\rdata: c\r\r",
+			expected: []Event{{Type: "message", Data: "a\nb"}, {Type: "message", Data: "c"}},
+		},
+		{
+			name:     "Byte Order Mark",
+			stream:   "\xEF\xBB\xBFdata: a\n\n",
+			expected: []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:     "Event Type Without Data Is Reset",
+			stream:   "event: tweet\n\ndata: a\n\n",
+			expected: []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:     "Unterminated Event Is Discarded",
+			stream:   "data: a\n\ndata: b\n",
+			expected: []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:     "Empty Stream",
+			stream:   "",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewParser(strings.NewReader(tt.stream))
+			got, err := readAll(parser)
+			if err != nil {
+				t.Fatalf("Next() error = %v", err)
+			}
+
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Next() got %d events %v, want %d", len(got), got, len(tt.expected))
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("Next() event %d = %+v, want %+v", i, got[i], tt.expected[i])
+				}
+			}
+			if tt.lastEventID != "" && parser.LastEventID() != tt.lastEventID {
+				t.Errorf("LastEventID() = %q, want %q", parser.LastEventID(), tt.lastEventID)
+			}
+		})
+	}
+}
+
+func TestParser_NextLineTooLong(t *testing.T) {
+	parser := NewParser(strings.NewReader("data: " + strings.Repeat("a", maxLineSize) + "\n\n"))
+	if _, err := parser.Next(); !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("Next() error = %v, want %v", err, ErrLineTooLong)
+	}
+}
+
+func FuzzParser(f *testing.F) {
+	for _, seed := range []string{
+		"data: {\"tweet\":{\"timestamp\":1234567890,\"likes\":10}}\n\n",
+		"event: pin\nid: 42\nretry: 100\ndata: a\ndata: b\n\n",
+		": comment\r\ndata:a\r\n\r\n",
+		"data: a\rdata: b\r\r",
+		"\xEF\xBB\xBFid: \x00\ndata\n\n",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, stream string) {
+		parser := NewParser(strings.NewReader(stream))
+		events, err := readAll(parser)
+		if err != nil {
+			return
+		}
+
+		for _, event := range events {
+			if strings.ContainsAny(event.Type, "\r\n") {
+				t.Errorf("event type %q contains a line terminator", event.Type)
+			}
+			if strings.ContainsAny(event.ID, "\r\n\x00") {
+				t.Errorf("event ID %q contains a line terminator or NULL", event.ID)
+			}
+			if strings.Contains(event.Data, "\r") {
+				t.Errorf("event data %q contains a CR", event.Data)
+			}
+			if event.Type == "" {
+				t.Errorf("event has an empty type")
+			}
+		}
+	})
+}
+
+func FuzzParserRoundTrip(f *testing.F) {
+	f.Add("tweet", "1", "{\"tweet\":{\"likes\":10}}")
+	f.Add("", "", "first\nsecond")
+	f.Add("message", "abc", " leading space")
+
+	f.Fuzz(func(t *testing.T, eventType, id, data string) {
+		if strings.ContainsAny(eventType+id, "\r\n\x00") || strings.Contains(data, "\r") {
+			t.Skip()
+		}
+
+		var stream strings.Builder
+		if eventType != "" {
+			stream.WriteString("event: " + eventType + "\n")
+		}
+		stream.WriteString("id: " + id + "\n")
+		for _, line := range strings.Split(data, "\n") {
+			stream.WriteString("data: " + line + "\n")
+		}
+		stream.WriteString("\n")
+
+		events, err := readAll(NewParser(strings.NewReader(stream.String())))
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+
+		wantType := eventType
+		if wantType == "" {
+			wantType = "message"
+		}
+		want := Event{ID: id, Type: wantType, Data: data}
+		if len(events) != 1 || events[0] != want {
+			t.Errorf("Next() got %+v, want [%+v]", events, want)
+		}
+	})
+}
+
+/////// Helpers
+
+// readAll reads every event from the parser until the end of the stream.
+func readAll(parser *Parser) ([]Event, error) {
+	var events []Event
+	for {
+		event, err := parser.Next()
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
@@ -0,0 +1,203 @@
+package sseclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Identifier is the identifier of a post. Networks send identifiers either as
+// JSON numbers or as strings, and both are decoded to their text.
+type Identifier string
+
+// UnmarshalJSON decodes an identifier from a JSON string or number.
+func (id *Identifier) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = Identifier(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("identifier must be a string or a number: %w", err)
+	}
+	*id = Identifier(n)
+	return nil
+}
+
+// SocialPost represents a social media post with various metrics such as likes,
+// comments, favorites, and retweets.
+//
+// Every network sends a flat JSON object with a timestamp, but the other fields
+// differ between networks. The fields shared by several networks are decoded
+// to typed fields, and the others are kept as raw JSON in Extra, so that the
+// full payload is preserved. The fields observed for each post type are:
+//
+//	tweet            id, content, retweets, favorites
+//	facebook_status  id, content, likes, comments, shares
+//	instagram_media  id, post_id, text, link, thumbnail_url, likes, comments, views
+//	youtube_video    id, name, description, link, thumbnail_url, views, comments
+//	pin              id, title, description, link, likes, comments, saves, repins
+//	tiktok_video     id, author, url, likes, comments, shares, views
+//	article          id, author, title, content, url
+//
+// A post type may omit any of its fields, in which case they are zero. The
+// numeric fields that are not typed, listed in ExtraMetrics, are read with Number.
+type SocialPost struct {
+	Timestamp int64 `json:"timestamp"`
+	Likes     int   `json:"likes,omitempty"`
+	Comments  int   `json:"comments,omitempty"`
+	Favorites int   `json:"favorites,omitempty"`
+	Retweets  int   `json:"retweets,omitempty"`
+
+	ID           Identifier `json:"id,omitempty"`            // ID identifies the post within the stream.
+	PostID       Identifier `json:"post_id,omitempty"`       // PostID identifies the post on its network, when it differs from ID.
+	Author       string     `json:"author,omitempty"`        // Author is the name of the author of the post.
+	URL          string     `json:"url,omitempty"`           // URL is the address of the post, for the networks sending url.
+	Link         string     `json:"link,omitempty"`          // Link is the address of the post, for the networks sending link.
+	ThumbnailURL string     `json:"thumbnail_url,omitempty"` // ThumbnailURL is the address of the thumbnail of the post.
+
+	// Extra holds the fields of the payload without a typed field, such as the
+	// text of the post or network-specific metrics, as raw JSON. It is nil when
+	// the payload has no other fields.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// ExtraMetrics lists the numeric fields sent by some networks that have no typed
+// field in SocialPost, in the order they are reported.
+var ExtraMetrics = []string{"views", "shares", "saves", "repins"}
+
+// PostTypes lists the post types sent by the stream, as described in SocialPost.
+var PostTypes = []string{"tweet", "facebook_status", "instagram_media", "youtube_video", "pin", "tiktok_video", "article"}
+
+// IsExtraMetric reports whether name is one of the ExtraMetrics.
+func IsExtraMetric(name string) bool {
+	return slices.Contains(ExtraMetrics, name)
+}
+
+// Post represents a structured event containing a type and associated social post data.
+type Post struct {
+	Type string     `json:"type"`
+	Data SocialPost `json:"data"`
+}
+
+// Number returns the value of a numeric field of the post, typed or extra, by
+// its JSON name. The second return value is false if the post has no such
+// field or if it is not a number.
+func (p *SocialPost) Number(name string) (float64, bool) {
+	if target, metric := p.field(name); metric {
+		switch v := target.(type) {
+		case *int64:
+			return float64(*v), true
+		case *int:
+			return float64(*v), true
+		}
+	}
+
+	raw, ok := p.Extra[name]
+	if !ok {
+		return 0, false
+	}
+	var value float64
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// ExtraNumbers returns the numeric fields of Extra, or nil if there are none.
+func (p *SocialPost) ExtraNumbers() map[string]float64 {
+	var numbers map[string]float64
+	for name := range p.Extra {
+		if value, ok := p.Number(name); ok {
+			if numbers == nil {
+				numbers = make(map[string]float64)
+			}
+			numbers[name] = value
+		}
+	}
+	return numbers
+}
+
+// field returns a pointer to the typed field with the given JSON name, or nil
+// if there is none. The second return value reports whether it is a metric,
+// which must be a number for the post to be valid.
+func (p *SocialPost) field(name string) (target any, metric bool) {
+	switch name {
+	case "timestamp":
+		return &p.Timestamp, true
+	case "likes":
+		return &p.Likes, true
+	case "comments":
+		return &p.Comments, true
+	case "favorites":
+		return &p.Favorites, true
+	case "retweets":
+		return &p.Retweets, true
+	case "id":
+		return &p.ID, false
+	case "post_id":
+		return &p.PostID, false
+	case "author":
+		return &p.Author, false
+	case "url":
+		return &p.URL, false
+	case "link":
+		return &p.Link, false
+	case "thumbnail_url":
+		return &p.ThumbnailURL, false
+	default:
+		return nil, false
+	}
+}
+
+// UnmarshalJSON decodes a post from the payload of its network. The fields
+// without a typed field are kept in Extra, as are the typed fields other than
+// the metrics whose value does not have the expected type, such as an author
+// sent as an object. A metric that is not a number is an error.
+func (p *SocialPost) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	*p = SocialPost{}
+	for name, raw := range fields {
+		target, metric := p.field(name)
+		if target != nil {
+			err := json.Unmarshal(raw, target)
+			if err == nil {
+				continue
+			}
+			if metric {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		if p.Extra == nil {
+			p.Extra = make(map[string]json.RawMessage)
+		}
+		p.Extra[name] = raw
+	}
+	return nil
+}
+
+// MarshalJSON encodes the post as a single JSON object holding both the typed
+// fields and Extra, so that decoding it gives back the same post.
+func (p SocialPost) MarshalJSON() ([]byte, error) {
+	type socialPost SocialPost // socialPost does not have the MarshalJSON method, avoiding a recursion.
+	data, err := json.Marshal(socialPost(p))
+	if err != nil || len(p.Extra) == 0 {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, raw := range p.Extra {
+		if _, typed := fields[name]; !typed {
+			fields[name] = raw
+		}
+	}
+	return json.Marshal(fields)
+}
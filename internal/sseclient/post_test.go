@@ -0,0 +1,130 @@
+package sseclient
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSocialPost_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		want      SocialPost
+		wantExtra map[string]string
+		wantErr   string
+	}{
+		{
+			name: "Typed Fields",
+			data: `{"id":"abc","timestamp":1714557600,"likes":10,"comments":2,"author":"jane","url":"https://example.com/p/1"}`,
+			want: SocialPost{ID: "abc", Timestamp: 1714557600, Likes: 10, Comments: 2, Author: "jane", URL: "https://example.com/p/1"},
+		},
+		{
+			name:      "Extra Fields",
+			data:      `{"id":42,"timestamp":1,"likes":3,"views":1500,"text":"hello","tags":["a","b"]}`,
+			want:      SocialPost{ID: "42", Timestamp: 1, Likes: 3},
+			wantExtra: map[string]string{"views": "1500", "text": `"hello"`, "tags": `["a","b"]`},
+		},
+		{
+			name:      "Untyped Author Kept As Extra",
+			data:      `{"timestamp":1,"author":{"name":"jane"}}`,
+			want:      SocialPost{Timestamp: 1},
+			wantExtra: map[string]string{"author": `{"name":"jane"}`},
+		},
+		{
+			name:    "Invalid Metric",
+			data:    `{"timestamp":1,"likes":"many"}`,
+			wantErr: "likes:",
+		},
+		{
+			name:    "Not An Object",
+			data:    `[1,2]`,
+			wantErr: "cannot unmarshal array",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got SocialPost
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("UnmarshalJSON() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UnmarshalJSON() error = %v", err)
+			}
+
+			extra := got.Extra
+			got.Extra = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnmarshalJSON() = %+v, want %+v", got, tt.want)
+			}
+			gotExtra := make(map[string]string)
+			for name, raw := range extra {
+				gotExtra[name] = string(raw)
+			}
+			if !maps.Equal(gotExtra, tt.wantExtra) {
+				t.Errorf("UnmarshalJSON() kept extra fields %v, want %v", gotExtra, tt.wantExtra)
+			}
+		})
+	}
+}
+
+func TestSocialPost_MarshalJSON(t *testing.T) {
+	data := `{"id":"7","likes":3,"link":"https://example.com","saves":12,"timestamp":1,"title":"Pin"}`
+	var post SocialPost
+	if err := json.Unmarshal([]byte(data), &post); err != nil {
+		t.Fatalf("UnmarshalJSON() error = %v", err)
+	}
+
+	got, err := json.Marshal(post)
+	if err != nil {
+		t.Fatalf("MarshalJSON() error = %v", err)
+	}
+	if string(got) != data {
+		t.Errorf("MarshalJSON() = %s, want the decoded payload %s", got, data)
+	}
+
+	got, err = json.Marshal(SocialPost{Timestamp: 1, Likes: 3})
+	if err != nil || string(got) != `{"timestamp":1,"likes":3}` {
+		t.Errorf("MarshalJSON() without extra fields = %s, %v", got, err)
+	}
+}
+
+func TestSocialPost_Number(t *testing.T) {
+	var post SocialPost
+	json.Unmarshal([]byte(`{"id":9,"timestamp":100,"likes":3,"views":1500.5,"text":"hello"}`), &post)
+
+	tests := []struct {
+		name   string
+		field  string
+		want   float64
+		wantOk bool
+	}{
+		{name: "Typed Metric", field: "likes", want: 3, wantOk: true},
+		{name: "Timestamp", field: "timestamp", want: 100, wantOk: true},
+		{name: "Missing Typed Metric", field: "retweets", want: 0, wantOk: true},
+		{name: "Extra Number", field: "views", want: 1500.5, wantOk: true},
+		{name: "Extra Text", field: "text", wantOk: false},
+		{name: "Identifier", field: "id", wantOk: false},
+		{name: "Missing", field: "shares", wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := post.Number(tt.field)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("Number(%q) = %v, %v, want %v, %v", tt.field, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+
+	if extra := post.ExtraNumbers(); len(extra) != 1 || extra["views"] != 1500.5 {
+		t.Errorf("ExtraNumbers() = %v, want only views", extra)
+	}
+}
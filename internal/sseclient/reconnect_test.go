@@ -0,0 +1,243 @@
+package sseclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSSEClient_ReadStreamReconnects(t *testing.T) {
+	var connections atomic.Int32
+	var mu sync.Mutex
+	var lastEventIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := connections.Add(1)
+		mu.Lock()
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		mu.Unlock()
+
+		switch n {
+		case 1:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("retry: 10\nid: 1\ndata: {\"tweet\":{\"timestamp\":1234567890,\"likes\":1}}\n\n"))
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("id: 2\ndata: {\"tweet\":{\"timestamp\":1234567891,\"likes\":2}}\n\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	client := New(server.URL)
+	client.minBackoff = 10 * time.Millisecond
+
+	var got []Post
+	for post := range client.ReadStream(context.Background(), 500*time.Millisecond) {
+		got = append(got, post)
+	}
+
+	if len(got) != 2 || got[0].Data.Likes != 1 || got[1].Data.Likes != 2 {
+		t.Errorf("ReadStream() got %v, want the posts of both connections", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"", "", "1"}
+	if len(lastEventIDs) != len(want) {
+		t.Fatalf("server saw %d connections, want %d", len(lastEventIDs), len(want))
+	}
+	for i := range want {
+		if lastEventIDs[i] != want[i] {
+			t.Errorf("connection %d sent Last-Event-ID %q, want %q", i+1, lastEventIDs[i], want[i])
+		}
+	}
+}
+
+func TestSSEClient_ReadStreamResumesTruncatedEvent(t *testing.T) {
+	var connections atomic.Int32
+	var mu sync.Mutex
+	var lastEventIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := connections.Add(1)
+		mu.Lock()
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		if n == 1 {
+			// The stream is cut before the blank line terminating event 2.
+			w.Write([]byte("id: 1\ndata: {\"tweet\":{\"timestamp\":1234567890,\"likes\":1}}\n\n" +
+				"id: 2\ndata: {\"tweet\":{\"timestamp\":1234567891,\"likes\":2}}\n"))
+			return
+		}
+		w.Write([]byte("id: 2\ndata: {\"tweet\":{\"timestamp\":1234567891,\"likes\":2}}\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := New(server.URL)
+	client.minBackoff = 10 * time.Millisecond
+
+	var got []Post
+	for post := range client.ReadStream(context.Background(), 300*time.Millisecond) {
+		got = append(got, post)
+	}
+
+	if len(got) != 2 || got[0].Data.Likes != 1 || got[1].Data.Likes != 2 {
+		t.Errorf("ReadStream() got %v, want event 2 to be delivered once on the second connection", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(lastEventIDs) != 2 || lastEventIDs[1] != "1" {
+		t.Errorf("connections sent Last-Event-ID %q, want the second one to resume from \"1\"", lastEventIDs)
+	}
+}
+
+func TestSSEClient_backoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		retry   time.Duration
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{
+			name:    "First Attempt",
+			attempt: 0,
+			wantMin: 500 * time.Millisecond,
+			wantMax: time.Second,
+		},
+		{
+			name:    "Exponential Growth",
+			attempt: 3,
+			wantMin: 4 * time.Second,
+			wantMax: 8 * time.Second,
+		},
+		{
+			name:    "Capped",
+			attempt: 20,
+			wantMin: 15 * time.Second,
+			wantMax: 30 * time.Second,
+		},
+		{
+			name:    "Server Retry",
+			attempt: 1,
+			retry:   100 * time.Millisecond,
+			wantMin: 100 * time.Millisecond,
+			wantMax: 200 * time.Millisecond,
+		},
+		{
+			name:    "Server Retry Above Cap",
+			attempt: 2,
+			retry:   time.Minute,
+			wantMin: 30 * time.Second,
+			wantMax: time.Minute,
+		},
+	}
+
+	client := New("")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				got := client.backoff(tt.attempt, tt.retry)
+				if got < tt.wantMin || got > tt.wantMax {
+					t.Fatalf("backoff() = %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+				}
+			}
+		})
+	}
+}
+
+func TestSSEClient_ReadStreamFailsOver(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusBadGateway)
+	}))
+	defer broken.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"tweet\":{\"timestamp\":1234567890,\"likes\":1}}\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer healthy.Close()
+
+	client := New(broken.URL, healthy.URL)
+	client.minBackoff = 10 * time.Millisecond
+
+	var got []Post
+	for post := range client.ReadStream(context.Background(), 300*time.Millisecond) {
+		got = append(got, post)
+	}
+
+	if len(got) != 1 || got[0].Type != "tweet" {
+		t.Errorf("ReadStream() got %v, want the post of the fallback URL", got)
+	}
+}
+
+func TestSSEClient_ReadStreamReturnsToPrimary(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string // requests are the URL and Last-Event-ID of each connection, in order.
+	record := func(name string, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, name+":"+r.Header.Get("Last-Event-ID"))
+	}
+
+	var primaryConnections atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record("primary", r)
+		switch primaryConnections.Add(1) {
+		case 1:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("id: p1\ndata: {\"tweet\":{\"timestamp\":1234567890,\"likes\":1}}\n\n"))
+		case 2:
+			http.Error(w, "unavailable", http.StatusBadGateway)
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("id: p2\ndata: {\"tweet\":{\"timestamp\":1234567892,\"likes\":3}}\n\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record("fallback", r)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("id: f1\ndata: {\"tweet\":{\"timestamp\":1234567891,\"likes\":2}}\n\n"))
+	}))
+	defer fallback.Close()
+
+	client := New(primary.URL, fallback.URL)
+	client.minBackoff = 10 * time.Millisecond
+
+	var got []Post
+	for post := range client.ReadStream(context.Background(), 500*time.Millisecond) {
+		got = append(got, post)
+	}
+
+	if len(got) != 3 || got[0].Data.Likes != 1 || got[1].Data.Likes != 2 || got[2].Data.Likes != 3 {
+		t.Errorf("ReadStream() got %v, want the posts of the primary, the fallback, then the primary", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The event IDs of a server are never sent to another one.
+	want := []string{"primary:", "primary:p1", "fallback:", "primary:"}
+	if len(requests) != len(want) {
+		t.Fatalf("servers saw connections %q, want %q", requests, want)
+	}
+	for i := range want {
+		if requests[i] != want[i] {
+			t.Errorf("connection %d was %q, want %q", i+1, requests[i], want[i])
+		}
+	}
+}
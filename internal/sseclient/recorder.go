@@ -0,0 +1,197 @@
+package sseclient
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// recordMagic starts every recording, so that other files are not replayed by mistake.
+const recordMagic = "UPFCCREC1\n"
+
+// maxRecordField bounds the size of a field read from a recording, so that a
+// corrupted length does not make the reader allocate without limit.
+const maxRecordField = maxLineSize
+
+// ErrNotRecording is returned when a file does not start like a recording.
+var ErrNotRecording = errors.New("sseclient: not an SSE recording")
+
+// Record is an event of the SSE stream with the time it arrived at.
+type Record struct {
+	At    time.Time // At is the arrival time of the event.
+	Event Event     // Event is the raw event, as parsed from the stream.
+}
+
+// Recorder writes the events of an SSE stream to an append-only recording.
+//
+// A recording starts with recordMagic and holds one record per event: the
+// arrival time in Unix nanoseconds as a varint, then the type, the ID and the
+// data of the event, each as a uvarint length followed by the bytes. Every
+// record is written at once, so that a recording cut short by a crash loses at
+// most its last record.
+type Recorder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+// NewRecorder creates a Recorder writing to w, starting with the recording header.
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	if _, err := io.WriteString(w, recordMagic); err != nil {
+		return nil, err
+	}
+	return &Recorder{w: w}, nil
+}
+
+// OpenRecorder opens the recording at path for appending, creating it if needed.
+// The header is written only when the file is empty, so that a service can
+// resume recording to the same file after a restart. An incomplete last record,
+// left by a crash, is cut off before new records are appended, while a corrupted
+// record elsewhere is reported as an error, leaving the file untouched.
+//
+// Parameters:
+//   - path: The path of the recording.
+//
+// Returns:
+//   - The Recorder, whose Close method closes the file.
+//   - An error if the file cannot be opened, is not a recording or is corrupted.
+func OpenRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r, err := openRecorder(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// openRecorder prepares an open recording for appending.
+func openRecorder(f *os.File) (*Recorder, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return NewRecorder(f)
+	}
+
+	reader, err := NewRecordReader(f)
+	if err != nil {
+		return nil, err
+	}
+	end := int64(len(recordMagic))
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		// Only a record cut short by the end of the file can be left by a crash.
+		// Other errors mean the recording is corrupted, and cutting it off there
+		// would silently discard the records after it.
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			if err := f.Truncate(end); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("corrupted record at offset %d: %w", end, err)
+		}
+		end += int64(len(appendRecord(nil, record.At, record.Event)))
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &Recorder{w: f}, nil
+}
+
+// Record appends an event to the recording.
+func (r *Recorder) Record(at time.Time, event Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buf = appendRecord(r.buf[:0], at, event)
+	_, err := r.w.Write(r.buf)
+	return err
+}
+
+// appendRecord appends the encoding of a record to buf.
+func appendRecord(buf []byte, at time.Time, event Event) []byte {
+	buf = binary.AppendVarint(buf, at.UnixNano())
+	for _, field := range []string{event.Type, event.ID, event.Data} {
+		buf = binary.AppendUvarint(buf, uint64(len(field)))
+		buf = append(buf, field...)
+	}
+	return buf
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if closer, ok := r.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// RecordReader reads the records of a recording in order.
+type RecordReader struct {
+	r *bufio.Reader
+}
+
+// NewRecordReader creates a RecordReader reading from r, after checking the recording header.
+func NewRecordReader(r io.Reader) (*RecordReader, error) {
+	header := make([]byte, len(recordMagic))
+	if _, err := io.ReadFull(r, header); err != nil || string(header) != recordMagic {
+		return nil, ErrNotRecording
+	}
+	return &RecordReader{r: bufio.NewReader(r)}, nil
+}
+
+// Next returns the next record of the recording. It returns io.EOF at the end
+// of the recording, and io.ErrUnexpectedEOF if the last record is incomplete.
+func (r *RecordReader) Next() (Record, error) {
+	nanos, err := binary.ReadVarint(r.r)
+	if err != nil {
+		return Record{}, err
+	}
+
+	var fields [3]string
+	for i := range fields {
+		if fields[i], err = r.readField(); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return Record{}, err
+		}
+	}
+	return Record{
+		At:    time.Unix(0, nanos),
+		Event: Event{Type: fields[0], ID: fields[1], Data: fields[2]},
+	}, nil
+}
+
+// readField reads a length-prefixed field of a record.
+func (r *RecordReader) readField() (string, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return "", err
+	}
+	if n > maxRecordField {
+		return "", fmt.Errorf("sseclient: record field of %d bytes exceeds the maximum of %d", n, maxRecordField)
+	}
+	field := make([]byte, n)
+	if _, err := io.ReadFull(r.r, field); err != nil {
+		return "", err
+	}
+	return string(field), nil
+}
@@ -0,0 +1,217 @@
+package sseclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecorder_RoundTrip(t *testing.T) {
+	start := time.Unix(1700000000, 123456789)
+	want := []Record{
+		{At: start, Event: Event{Type: "message", ID: "1", Data: "{\"tweet\":{\"likes\":1}}"}},
+		{At: start.Add(time.Millisecond), Event: Event{Type: "message", Data: "line 1\nline 2"}},
+		{At: start.Add(time.Hour), Event: Event{Type: "ping"}},
+	}
+
+	var buf bytes.Buffer
+	recorder, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+	for _, record := range want {
+		if err := recorder.Record(record.At, record.Event); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	got := readRecords(t, &buf)
+	if len(got) != len(want) {
+		t.Fatalf("read %d records, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !got[i].At.Equal(want[i].At) || got[i].Event != want[i].Event {
+			t.Errorf("record %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestOpenRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.rec")
+	at := time.Unix(1700000000, 0)
+
+	for i, data := range []string{"first", "second"} {
+		recorder, err := OpenRecorder(path)
+		if err != nil {
+			t.Fatalf("OpenRecorder() #%d error = %v", i+1, err)
+		}
+		recorder.Record(at, Event{Type: "message", Data: data})
+		if err := recorder.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	}
+
+	// Simulate a crash in the middle of a record.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(appendRecord(nil, at, Event{Type: "message", Data: "lost"})[:5])
+	f.Close()
+
+	recorder, err := OpenRecorder(path)
+	if err != nil {
+		t.Fatalf("OpenRecorder() after a crash error = %v", err)
+	}
+	recorder.Record(at, Event{Type: "message", Data: "third"})
+	recorder.Close()
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []string
+	for _, record := range readRecords(t, f) {
+		got = append(got, record.Event.Data)
+	}
+	if len(got) != 3 || got[0] != "first" || got[1] != "second" || got[2] != "third" {
+		t.Errorf("recording holds %q, want the first, second and third events", got)
+	}
+}
+
+func TestOpenRecorder_NotRecording(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"listen_addr": ":8080"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenRecorder(path); !errors.Is(err, ErrNotRecording) {
+		t.Errorf("OpenRecorder() error = %v, want ErrNotRecording", err)
+	}
+}
+
+func TestOpenRecorder_Corrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.rec")
+	at := time.Unix(1700000000, 0)
+
+	// A record whose type claims more bytes than a field can hold, followed by a valid record.
+	content := []byte(recordMagic)
+	content = appendRecord(content, at, Event{Type: "message", Data: "first"})
+	content = binary.AppendVarint(content, at.UnixNano())
+	content = binary.AppendUvarint(content, maxRecordField+1)
+	content = appendRecord(content, at, Event{Type: "message", Data: "second"})
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenRecorder(path); err == nil || !strings.Contains(err.Error(), "corrupted record") {
+		t.Errorf("OpenRecorder() error = %v, want a corrupted record error", err)
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("OpenRecorder() changed the corrupted recording, want it untouched")
+	}
+}
+
+func TestRecordReader_Truncated(t *testing.T) {
+	var buf bytes.Buffer
+	recorder, _ := NewRecorder(&buf)
+	recorder.Record(time.Now(), Event{Type: "message", Data: "{\"tweet\":{}}"})
+	truncated := buf.Bytes()[:buf.Len()-3]
+
+	reader, err := NewRecordReader(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatalf("NewRecordReader() error = %v", err)
+	}
+	if _, err := reader.Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Next() error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestSSEClient_RecordTo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("id: 1\ndata: {\"tweet\":{\"timestamp\":1234567890,\"likes\":1}}\n\n"))
+		w.Write([]byte("event: ping\ndata: invalid\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	buf := &lockedBuffer{}
+	recorder, _ := NewRecorder(buf)
+	client := New(server.URL)
+	client.RecordTo(recorder)
+
+	before := time.Now()
+	for range client.ReadStream(context.Background(), 200*time.Millisecond) {
+	}
+
+	got := readRecords(t, bytes.NewReader(buf.Bytes()))
+	want := []Event{
+		{Type: "message", ID: "1", Data: "{\"tweet\":{\"timestamp\":1234567890,\"likes\":1}}"},
+		{Type: "ping", ID: "1", Data: "invalid"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("recorded %d events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Event != want[i] {
+			t.Errorf("recorded event %d = %+v, want %+v", i, got[i].Event, want[i])
+		}
+		if got[i].At.Before(before) || got[i].At.After(time.Now()) {
+			t.Errorf("recorded event %d at %v, want the time it arrived", i, got[i].At)
+		}
+	}
+}
+
+/////// Helpers
+
+// readRecords reads all the records of a recording, failing the test on error.
+func readRecords(t *testing.T, r io.Reader) []Record {
+	t.Helper()
+	reader, err := NewRecordReader(r)
+	if err != nil {
+		t.Fatalf("NewRecordReader() error = %v", err)
+	}
+	var records []Record
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		records = append(records, record)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that can be written while it is read.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of the buffer content.
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
@@ -0,0 +1,160 @@
+package sseclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+)
+
+// ReplayInstant is the replay speed that sends the recorded posts without waiting.
+const ReplayInstant = 0
+
+// ReplayClient reads posts from a recording made by a Recorder instead of a
+// live stream, so that analyses can be reproduced on exact historical traffic.
+//
+// Every call to ReadStream replays the recording from its start, and the
+// duration is measured on the timeline of the recording: ReadStream(ctx, 30*time.Second)
+// sends the posts that arrived within 30 seconds of the first recorded event,
+// whatever the replay speed. The same request therefore always yields the same posts.
+type ReplayClient struct {
+	path    string
+	speed   float64   // speed is the replay speed, 1 for real time, ReplayInstant for no wait.
+	created time.Time // created is the time the client was created, reported by Status.
+}
+
+// NewReplayClient creates a ReplayClient for the recording at path.
+//
+// Parameters:
+//   - path: The path of a recording made by a Recorder.
+//   - speed: The replay speed: 1 replays in real time, 10 ten times faster, and ReplayInstant without waiting.
+//
+// Returns:
+//   - The ReplayClient.
+//   - An error if the file cannot be read, is not a recording, or the speed is negative.
+func NewReplayClient(path string, speed float64) (*ReplayClient, error) {
+	if speed < 0 {
+		return nil, errors.New("sseclient: replay speed must not be negative")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := NewRecordReader(f); err != nil {
+		return nil, err
+	}
+	return &ReplayClient{path: path, speed: speed, created: time.Now()}, nil
+}
+
+// ReadStream replays the posts recorded within the given duration of the start
+// of the recording. The returned channel is closed once they have been sent, at
+// the end of the recording, or when the context is done.
+func (c *ReplayClient) ReadStream(ctx context.Context, duration time.Duration) chan Post {
+	postChan := make(chan Post, subscriberBuffer)
+	go func() {
+		defer close(postChan)
+		if err := c.replay(ctx, duration, postChan); err != nil {
+			slog.Error("Replay stopped", "file", c.path, "error", err)
+		}
+	}()
+	return postChan
+}
+
+// Status reports the replay as a connected stream that has just delivered an
+// event, as a recording is always available.
+func (c *ReplayClient) Status() Status {
+	return Status{
+		State:       StateConnected,
+		Since:       c.created,
+		LastEventAt: time.Now(),
+		Endpoint:    c.path,
+	}
+}
+
+// replay sends the posts recorded within duration of the first record,
+// waiting between them according to the replay speed.
+func (c *ReplayClient) replay(ctx context.Context, duration time.Duration, postChan chan<- Post) error {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader, err := NewRecordReader(f)
+	if err != nil {
+		return err
+	}
+
+	var first time.Time
+	started := time.Now()
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if first.IsZero() {
+			first = record.At
+		}
+		offset := record.At.Sub(first)
+		if offset >= duration {
+			// Like a live stream, the channel is closed when the duration has elapsed.
+			c.wait(ctx, started, duration)
+			return nil
+		}
+		if err := c.wait(ctx, started, offset); err != nil {
+			return nil
+		}
+
+		for _, post := range replayedPosts(record.Event.Data) {
+			select {
+			case postChan <- post:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// wait waits until the given offset of the recording is reached, at the replay
+// speed. It returns ctx.Err() if the context is done first.
+func (c *ReplayClient) wait(ctx context.Context, started time.Time, offset time.Duration) error {
+	if c.speed == ReplayInstant {
+		return ctx.Err()
+	}
+	delay := time.Until(started.Add(time.Duration(float64(offset) / c.speed)))
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// replayedPosts returns the posts carried by the data of a recorded event,
+// sorted by type so that replays are deterministic.
+func replayedPosts(data string) []Post {
+	var event map[string]SocialPost
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return nil
+	}
+	posts := make([]Post, 0, len(event))
+	for eventType, post := range event {
+		posts = append(posts, Post{Type: eventType, Data: post})
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].Type < posts[j].Type })
+	return posts
+}
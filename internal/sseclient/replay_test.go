@@ -0,0 +1,159 @@
+package sseclient
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReplayClient_ReadStream(t *testing.T) {
+	path := writeRecording(t, map[time.Duration]string{
+		0:                       `{"tweet":{"timestamp":1,"likes":1}}`,
+		time.Second:             `{"youtube_video":{"timestamp":2,"likes":2},"instagram_media":{"timestamp":2,"likes":3}}`,
+		2 * time.Second:         `invalid`,
+		2500 * time.Millisecond: `{"tweet":{"timestamp":3,"likes":4}}`,
+		5 * time.Second:         `{"tweet":{"timestamp":5,"likes":5}}`,
+	})
+
+	tests := []struct {
+		name       string
+		speed      float64
+		duration   time.Duration
+		wantLikes  []int
+		minElapsed time.Duration
+		maxElapsed time.Duration
+	}{
+		{
+			name:       "Instant",
+			speed:      ReplayInstant,
+			duration:   3 * time.Second,
+			wantLikes:  []int{1, 3, 2, 4},
+			maxElapsed: 100 * time.Millisecond,
+		},
+		{
+			name:       "Faster Than Real Time",
+			speed:      20,
+			duration:   3 * time.Second,
+			wantLikes:  []int{1, 3, 2, 4},
+			minElapsed: 150 * time.Millisecond,
+			maxElapsed: time.Second,
+		},
+		{
+			name:       "Whole Recording",
+			speed:      ReplayInstant,
+			duration:   time.Hour,
+			wantLikes:  []int{1, 3, 2, 4, 5},
+			maxElapsed: 100 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewReplayClient(path, tt.speed)
+			if err != nil {
+				t.Fatalf("NewReplayClient() error = %v", err)
+			}
+
+			for run := 0; run < 2; run++ {
+				start := time.Now()
+				var got []int
+				for post := range client.ReadStream(context.Background(), tt.duration) {
+					got = append(got, post.Data.Likes)
+				}
+				elapsed := time.Since(start)
+
+				if fmt.Sprint(got) != fmt.Sprint(tt.wantLikes) {
+					t.Errorf("replay %d got likes %v, want %v", run+1, got, tt.wantLikes)
+				}
+				if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+					t.Errorf("replay %d took %v, want between %v and %v", run+1, elapsed, tt.minElapsed, tt.maxElapsed)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayClient_ReadStreamContextCancelled(t *testing.T) {
+	path := writeRecording(t, map[time.Duration]string{
+		0:         `{"tweet":{"timestamp":1,"likes":1}}`,
+		time.Hour: `{"tweet":{"timestamp":2,"likes":2}}`,
+	})
+	client, err := NewReplayClient(path, 1)
+	if err != nil {
+		t.Fatalf("NewReplayClient() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	postChan := client.ReadStream(ctx, 2*time.Hour)
+	if post := <-postChan; post.Data.Likes != 1 {
+		t.Fatalf("first post = %v, want the first recorded post", post)
+	}
+	cancel()
+
+	select {
+	case post, ok := <-postChan:
+		if ok {
+			t.Errorf("got post %v after the context was cancelled", post)
+		}
+	case <-time.After(time.Second):
+		t.Error("the channel was not closed after the context was cancelled")
+	}
+}
+
+func TestNewReplayClient_Errors(t *testing.T) {
+	notRecording := filepath.Join(t.TempDir(), "posts.json")
+	if err := os.WriteFile(notRecording, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	recording := writeRecording(t, nil)
+
+	tests := []struct {
+		name  string
+		path  string
+		speed float64
+	}{
+		{name: "Missing File", path: filepath.Join(t.TempDir(), "missing.rec"), speed: 1},
+		{name: "Not A Recording", path: notRecording, speed: 1},
+		{name: "Negative Speed", path: recording, speed: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewReplayClient(tt.path, tt.speed); err == nil {
+				t.Error("NewReplayClient() error = nil, want an error")
+			}
+		})
+	}
+}
+
+/////// Helpers
+
+// writeRecording writes a recording holding the given event data at the given
+// offsets from an arbitrary start time, and returns its path.
+func writeRecording(t *testing.T, events map[time.Duration]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stream.rec")
+	recorder, err := OpenRecorder(path)
+	if err != nil {
+		t.Fatalf("OpenRecorder() error = %v", err)
+	}
+	defer recorder.Close()
+
+	offsets := make([]time.Duration, 0, len(events))
+	for offset := range events {
+		offsets = append(offsets, offset)
+	}
+	slices.Sort(offsets)
+
+	start := time.Unix(1700000000, 0)
+	for _, offset := range offsets {
+		if err := recorder.Record(start.Add(offset), Event{Type: "message", Data: events[offset]}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+	return path
+}
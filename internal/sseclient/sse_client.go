@@ -3,110 +3,243 @@
 package sseclient
 
 import (
-	"upfcc/internal/types"
-
-	"bufio"
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
-	"strings"
+	"sync"
 	"time"
 )
 
-// SocialPost represents a social media post with various metrics such as likes,
-// comments, favorites, and retweets.
-type SocialPost struct {
-	Timestamp int64 `json:"timestamp"`
-	Likes     int   `json:"likes,omitempty"`
-	Comments  int   `json:"comments,omitempty"`
-	Favorites int   `json:"favorites,omitempty"`
-	Retweets  int   `json:"retweets,omitempty"`
-}
+// SSEClient represents a client that connects to an SSE stream and shares the
+// events it reads between all of its subscribers.
+type SSEClient struct {
+	url        string        // url is the endpoint of the SSE stream.
+	fallbacks  []string      // fallbacks are the endpoints tried in turn when url cannot be read.
+	minBackoff time.Duration // minBackoff is the delay before the first reconnection attempt.
+	maxBackoff time.Duration // maxBackoff caps the delay between reconnection attempts.
 
-// Post represents a structured event containing a type and associated social post data.
-type Post struct {
-	Type string     `json:"type"`
-	Data SocialPost `json:"data"`
+	mu          sync.Mutex
+	stream      *stream                  // stream is the running upstream connection, nil when idle.
+	subscribers map[*subscriber]struct{} // subscribers are the consumers of the shared stream.
+	holds       int                      // holds is the number of KeepConnected calls still in effect.
+	recorder    *Recorder                // recorder records the events read, nil when not recording.
+
+	status statusTracker // status records the health of the upstream connection.
 }
 
-// GetValue returns the value of a specific dimension (Likes, Comments, Favorites, Retweets)
-// from the SocialPost. If the dimension is not recognized, it returns 0.
-func (p *SocialPost) GetValue(dimension types.Dimension) int {
-	switch dimension {
-	case types.Likes:
-		return p.Likes
-	case types.Comments:
-		return p.Comments
-	case types.Favorites:
-		return p.Favorites
-	case types.Retweets:
-		return p.Retweets
-	default:
-		return 0
+// New creates a new instance of SSEClient with the specified URL. When fallback
+// URLs are given, a connection that fails is retried on the next URL in turn,
+// and the primary URL is tried again once a fallback has served events.
+func New(url string, fallbacks ...string) *SSEClient {
+	return &SSEClient{
+		url:         url,
+		fallbacks:   fallbacks,
+		minBackoff:  time.Second,
+		maxBackoff:  30 * time.Second,
+		subscribers: make(map[*subscriber]struct{}),
 	}
 }
 
-// SSEClient represents a client that connects to an SSE stream and reads events.
-type SSEClient struct {
-	url string // url is the endpoint of the SSE stream.
+// cursor holds the state of an SSE stream that must survive a reconnection.
+type cursor struct {
+	lastEventID string        // lastEventID is the value of the last "id:" field of a terminated block.
+	retry       time.Duration // retry is the reconnection delay requested by the server, if any.
+	received    bool          // received reports whether an event was read since the last connection.
+	endpoint    int           // endpoint is the index of the URL to connect to, 0 being the primary URL.
 }
 
-// New creates a new instance of SSEClient with the specified URL.
-func New(url string) *SSEClient {
-	return &SSEClient{url: url}
+// switchTo points the cursor to another endpoint. The event IDs and the retry
+// delay of a server mean nothing to the others, so they are forgotten.
+func (cur *cursor) switchTo(endpoint int) {
+	if endpoint != cur.endpoint {
+		cur.endpoint = endpoint
+		cur.lastEventID = ""
+		cur.retry = 0
+	}
 }
 
-// ReadStream starts reading the SSE stream from the specified URL for a given duration.
-// It returns a channel of Post structs that can be consumed by the caller.
-func (c *SSEClient) ReadStream(duration time.Duration) chan Post {
-	postChan := make(chan Post)
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), duration)
-		defer cancel()
+// connect reads the SSE stream and sends the parsed posts to the channel until
+// the context is cancelled. Whenever the connection fails or the stream ends,
+// it reconnects with exponential backoff and resumes from the last event ID.
+// A connection that fails is retried on the next URL in turn, and once a
+// fallback URL has served events and ended, the primary URL is tried again.
+// The channel is closed when connect returns.
+func (c *SSEClient) connect(ctx context.Context, postChan chan<- Post) {
+	defer close(postChan)
 
-		req, err := http.NewRequestWithContext(ctx, "GET", c.url, nil)
-		if err != nil {
-			close(postChan)
+	cur := &cursor{}
+	attempt := 0
+	for n := 0; ; n++ {
+		if n > 0 {
+			reconnects.Inc()
+		} else {
+			c.setState(ctx, StateConnecting, c.endpoint(cur))
+		}
+		cur.received = false
+		connectionAttempts.Inc()
+		err := c.readOnce(ctx, cur, postChan)
+		if ctx.Err() != nil {
 			return
 		}
+		c.setState(ctx, StateReconnecting, c.endpoint(cur))
+		if cur.received {
+			attempt = 0
+			// The fallback served the stream for a while, the preferred URL may be back.
+			cur.switchTo(0)
+		} else if len(c.fallbacks) > 0 {
+			cur.switchTo((cur.endpoint + 1) % (len(c.fallbacks) + 1))
+		}
 
-		resp, err := http.DefaultClient.Do(req)
+		wait := c.backoff(attempt, cur.retry)
+		attempt++
 		if err != nil {
-			close(postChan)
+			connectionFailures.Inc()
+			c.status.failure()
+			slog.Warn("SSE stream error, reconnecting", "error", err, "wait", wait)
+		} else {
+			slog.Info("SSE stream ended, reconnecting", "wait", wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
 			return
+		case <-timer.C:
 		}
-		defer resp.Body.Close()
+	}
+}
+
+// endpoint returns the URL the cursor points to.
+func (c *SSEClient) endpoint(cur *cursor) string {
+	if cur.endpoint > 0 {
+		return c.fallbacks[cur.endpoint-1]
+	}
+	return c.url
+}
 
-		c.scanResponse(resp, postChan)
-	}()
-	return postChan
+// setState records the state of the connection, unless ctx is done as the
+// connection is then being closed on purpose.
+func (c *SSEClient) setState(ctx context.Context, state ConnState, endpoint string) {
+	if ctx.Err() == nil {
+		c.status.set(state, endpoint)
+	}
 }
 
-// scanResponse reads the response body line by line and sends parsed events to the channel.
-func (c *SSEClient) scanResponse(resp *http.Response, postChan chan<- Post) {
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			c.processDataLine(data, postChan)
+// readOnce opens a single connection to the SSE stream and reads it until it ends.
+func (c *SSEClient) readOnce(ctx context.Context, cur *cursor, postChan chan<- Post) error {
+	url := c.endpoint(cur)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if cur.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", cur.lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	c.setState(ctx, StateConnected, url)
+	slog.Debug("Connected to the SSE stream", "endpoint", url, "last_event_id", cur.lastEventID)
+	return c.scanResponse(resp, cur, postChan)
+}
+
+// backoff returns the delay before the given reconnection attempt. The delay
+// starts at the server-provided retry value, or minBackoff if there is none,
+// doubles with every attempt up to maxBackoff, and is jittered so that many
+// clients do not reconnect at the same time.
+func (c *SSEClient) backoff(attempt int, retry time.Duration) time.Duration {
+	base := c.minBackoff
+	if retry > 0 {
+		base = retry
+	}
+
+	wait := base
+	for i := 0; i < attempt && wait < c.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > c.maxBackoff {
+		wait = max(c.maxBackoff, base)
+	}
+
+	// Jitter uniformly in [wait/2, wait].
+	half := wait / 2
+	return half + rand.N(wait-half+1)
+}
+
+// scanResponse parses the response body as an event stream and sends the posts
+// carried by each event to the channel. The last event ID and the retry delay
+// set by the stream are recorded in the cursor for the next reconnection. The
+// parser only commits the ID of a terminated block, so when the stream is cut in
+// the middle of an event, the next connection resumes from the event before it.
+func (c *SSEClient) scanResponse(resp *http.Response, cur *cursor, postChan chan<- Post) error {
+	parser := NewParser(resp.Body)
+	parser.lastEventID = cur.lastEventID
+	for {
+		event, err := parser.Next()
+		cur.lastEventID = parser.LastEventID()
+		if retry := parser.Retry(); retry > 0 {
+			cur.retry = retry
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
 		}
+		if err != nil {
+			return err
+		}
+
+		cur.received = true
+		c.status.event()
+		c.record(event)
+		c.processDataLine(event.Data, postChan)
 	}
+}
+
+// RecordTo records every event read from the upstream stream, with its arrival
+// time, to the given Recorder. The recording can be replayed with a ReplayClient.
+func (c *SSEClient) RecordTo(recorder *Recorder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recorder = recorder
+}
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading SSE stream: %v", err)
+// record writes an event to the recorder, if any. Recording errors are logged
+// but do not interrupt the stream.
+func (c *SSEClient) record(event Event) {
+	c.mu.Lock()
+	recorder := c.recorder
+	c.mu.Unlock()
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Record(time.Now(), event); err != nil {
+		slog.Error("Failed to record an SSE event", "error", err)
 	}
-	close(postChan)
 }
 
-// processDataLine parses a data line and sends the resulting events to the channel.
+// processDataLine parses the data of an event and sends the resulting posts to the channel.
 func (c *SSEClient) processDataLine(data string, postChan chan<- Post) {
 	var event map[string]SocialPost
 	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		parseErrors.Inc()
+		slog.Debug("Ignoring an SSE event whose data is not valid JSON", "error", err)
 		return
 	}
 	for eventType, post := range event {
+		postsReceived.WithLabelValues(postTypeLabel(eventType)).Inc()
 		postChan <- Post{Type: eventType, Data: post}
 	}
 }
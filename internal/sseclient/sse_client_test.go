@@ -1,8 +1,8 @@
 package sseclient
 
 import (
+	"context"
 	"io"
-	"upfcc/internal/types"
 
 	"bytes"
 	"net/http"
@@ -11,54 +11,6 @@ import (
 	"time"
 )
 
-func TestSocialPost_GetValue(t *testing.T) {
-	tests := []struct {
-		name      string
-		post      SocialPost
-		dimension types.Dimension
-		expected  int
-	}{
-		{
-			name:      "Get Likes",
-			post:      SocialPost{Likes: 10},
-			dimension: types.Likes,
-			expected:  10,
-		},
-		{
-			name:      "Get Comments",
-			post:      SocialPost{Comments: 5},
-			dimension: types.Comments,
-			expected:  5,
-		},
-		{
-			name:      "Get Favorites",
-			post:      SocialPost{Favorites: 7},
-			dimension: types.Favorites,
-			expected:  7,
-		},
-		{
-			name:      "Get Retweets",
-			post:      SocialPost{Retweets: 3},
-			dimension: types.Retweets,
-			expected:  3,
-		},
-		{
-			name:      "Invalid Dimension",
-			post:      SocialPost{Likes: 10},
-			dimension: types.Dimension("invalid"),
-			expected:  0,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.post.GetValue(tt.dimension); got != tt.expected {
-				t.Errorf("GetValue() = %d, want %d", got, tt.expected)
-			}
-		})
-	}
-}
-
 func TestNew(t *testing.T) {
 	url := "http://example.com"
 	client := New(url)
@@ -83,8 +35,10 @@ func TestSSEClient_ReadStream(t *testing.T) {
 			server: httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("Content-Type", "text/event-stream")
 				w.Write([]byte("data: {\"post\":{\"timestamp\":1234567890,\"likes\":10}}\n\n"))
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
 			})),
-			duration: 5 * time.Second,
+			duration: 200 * time.Millisecond,
 			expected: []Post{
 				{
 					Type: "post",
@@ -97,8 +51,10 @@ func TestSSEClient_ReadStream(t *testing.T) {
 			server: httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("Content-Type", "text/event-stream")
 				w.Write([]byte("invalid data\n\n"))
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
 			})),
-			duration: 5 * time.Second,
+			duration: 200 * time.Millisecond,
 			expected: []Post{},
 		},
 	}
@@ -108,7 +64,7 @@ func TestSSEClient_ReadStream(t *testing.T) {
 			defer tt.server.Close()
 
 			client := New(tt.server.URL)
-			posts := client.ReadStream(tt.duration)
+			posts := client.ReadStream(context.Background(), tt.duration)
 
 			var got []Post
 			for post := range posts {
@@ -144,6 +100,26 @@ func TestSSEClient_scanResponse(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:     "Data Without Space And Comments",
+			response: ": keep-alive\nid: 3\ndata:{\"post\":{\"timestamp\":1234567890,\"likes\":10}}\n\n",
+			expected: []Post{
+				{
+					Type: "post",
+					Data: SocialPost{Timestamp: 1234567890, Likes: 10},
+				},
+			},
+		},
+		{
+			name:     "Multi Line Data",
+			response: "data: {\"post\":\ndata: {\"timestamp\":1234567890,\"likes\":10}}\r\n\r\n",
+			expected: []Post{
+				{
+					Type: "post",
+					Data: SocialPost{Timestamp: 1234567890, Likes: 10},
+				},
+			},
+		},
 		{
 			name:     "Invalid Response",
 			response: "invalid data\n\n",
@@ -159,7 +135,12 @@ func TestSSEClient_scanResponse(t *testing.T) {
 			}
 
 			postChan := make(chan Post)
-			go client.scanResponse(resp, postChan)
+			go func() {
+				if err := client.scanResponse(resp, &cursor{}, postChan); err != nil {
+					t.Errorf("scanResponse() error = %v", err)
+				}
+				close(postChan)
+			}()
 
 			var got []Post
 			for post := range postChan {
@@ -181,9 +162,11 @@ func TestSSEClient_scanResponse(t *testing.T) {
 
 func TestSSEClient_processDataLine(t *testing.T) {
 	tests := []struct {
-		name     string
-		data     string
-		expected []Post
+		name            string
+		data            string
+		expected        []Post
+		wantParseErrors float64
+		wantLabel       string
 	}{
 		{
 			name: "Valid Data Line",
@@ -196,15 +179,43 @@ func TestSSEClient_processDataLine(t *testing.T) {
 			},
 		},
 		{
-			name:     "Invalid Data Line",
-			data:     "invalid data",
-			expected: []Post{},
+			name: "Known Post Type",
+			data: "{\"tweet\":{\"timestamp\":1234567890,\"likes\":10}}",
+			expected: []Post{
+				{
+					Type: "tweet",
+					Data: SocialPost{Timestamp: 1234567890, Likes: 10},
+				},
+			},
+			wantLabel: "tweet",
+		},
+		{
+			name: "Unknown Post Type",
+			data: "{\"unknown_type\":{\"timestamp\":1234567890,\"likes\":10}}",
+			expected: []Post{
+				{
+					Type: "unknown_type",
+					Data: SocialPost{Timestamp: 1234567890, Likes: 10},
+				},
+			},
+			wantLabel: "other",
+		},
+		{
+			name:            "Invalid Data Line",
+			data:            "invalid data",
+			expected:        []Post{},
+			wantParseErrors: 1,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			client := New("")
+			parseErrorsBefore := parseErrors.Value()
+			var receivedBefore float64
+			if tt.wantLabel != "" {
+				receivedBefore = postsReceived.WithLabelValues(tt.wantLabel).Value()
+			}
 			postChan := make(chan Post)
 			go func() {
 				client.processDataLine(tt.data, postChan)
@@ -219,6 +230,14 @@ func TestSSEClient_processDataLine(t *testing.T) {
 			if len(got) != len(tt.expected) {
 				t.Errorf("processDataLine() got %d posts, want %d", len(got), len(tt.expected))
 			}
+			if n := parseErrors.Value() - parseErrorsBefore; n != tt.wantParseErrors {
+				t.Errorf("processDataLine() counted %v parse errors, want %v", n, tt.wantParseErrors)
+			}
+			if tt.wantLabel != "" {
+				if n := postsReceived.WithLabelValues(tt.wantLabel).Value() - receivedBefore; n != float64(len(tt.expected)) {
+					t.Errorf("processDataLine() counted %v posts of type %q, want %d", n, tt.wantLabel, len(tt.expected))
+				}
+			}
 
 			for i, post := range got {
 				if post.Type != tt.expected[i].Type || post.Data.Timestamp != tt.expected[i].Data.Timestamp || post.Data.Likes != tt.expected[i].Data.Likes {
@@ -0,0 +1,101 @@
+package sseclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ConnState is the state of the upstream connection of an SSEClient.
+type ConnState string
+
+const (
+	StateIdle         ConnState = "idle"         // StateIdle means no connection is open, as nobody reads the stream.
+	StateConnecting   ConnState = "connecting"   // StateConnecting means the first connection attempt is in progress.
+	StateConnected    ConnState = "connected"    // StateConnected means the stream is being read.
+	StateReconnecting ConnState = "reconnecting" // StateReconnecting means the connection was lost and is being re-established.
+)
+
+// Status describes the health of the upstream connection.
+type Status struct {
+	State       ConnState // State is the current state of the connection.
+	Since       time.Time // Since is the time the connection entered its current state.
+	LastEventAt time.Time // LastEventAt is the arrival time of the last event, zero if none was received.
+	Failures    int       // Failures is the number of consecutive connection attempts that failed.
+	Endpoint    string    // Endpoint is the URL currently used, empty when idle.
+}
+
+// statusTracker records the status of the upstream connection. It has its own
+// lock, so that reading the status never waits for a broadcast.
+type statusTracker struct {
+	mu     sync.Mutex
+	status Status
+}
+
+// set moves the connection to the given state.
+func (t *statusTracker) set(state ConnState, endpoint string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.status.State != state {
+		t.status.Since = time.Now()
+	}
+	t.status.State = state
+	t.status.Endpoint = endpoint
+	if state == StateConnected || state == StateIdle {
+		t.status.Failures = 0
+	}
+}
+
+// event records the arrival of an event.
+func (t *statusTracker) event() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.LastEventAt = time.Now()
+}
+
+// failure records a failed connection attempt.
+func (t *statusTracker) failure() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.Failures++
+}
+
+// get returns a copy of the status.
+func (t *statusTracker) get() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := t.status
+	if status.State == "" {
+		status.State = StateIdle
+	}
+	return status
+}
+
+// Status returns the health of the upstream connection.
+func (c *SSEClient) Status() Status {
+	return c.status.get()
+}
+
+// KeepConnected keeps the upstream connection open until ctx is done, even
+// when no analysis is running, so that Status reflects the health of the
+// upstream stream at all times. The posts read meanwhile are discarded unless
+// an analysis subscribes to the stream.
+func (c *SSEClient) KeepConnected(ctx context.Context) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.holds++
+	if c.stream == nil {
+		c.start()
+	}
+	context.AfterFunc(ctx, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.holds--
+		c.stopIfUnused()
+	})
+}
@@ -0,0 +1,72 @@
+package sseclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSSEClient_StatusConnected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"tweet\":{\"timestamp\":1234567890,\"likes\":1}}\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := New(server.URL)
+	if got := client.Status(); got.State != StateIdle {
+		t.Fatalf("Status() before connecting = %+v, want idle", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client.KeepConnected(ctx)
+
+	got := waitForStatus(t, client, func(s Status) bool { return s.State == StateConnected && !s.LastEventAt.IsZero() })
+	if got.Endpoint != server.URL || got.Failures != 0 {
+		t.Errorf("Status() = %+v, want connected to %s without failures", got, server.URL)
+	}
+
+	cancel()
+	waitForStatus(t, client, func(s Status) bool { return s.State == StateIdle })
+}
+
+func TestSSEClient_StatusReconnecting(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := New(server.URL)
+	client.minBackoff = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client.KeepConnected(ctx)
+
+	got := waitForStatus(t, client, func(s Status) bool { return s.Failures >= 2 })
+	if got.State != StateReconnecting || !got.LastEventAt.IsZero() {
+		t.Errorf("Status() = %+v, want reconnecting without events", got)
+	}
+}
+
+/////// Helpers
+
+// waitForStatus polls the status of the client until done returns true, and fails the test after a second.
+func waitForStatus(t *testing.T, client *SSEClient, done func(Status) bool) Status {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		status := client.Status()
+		if done(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Status() = %+v, timed out waiting for the expected status", status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,67 @@
+package stats
+
+import "math"
+
+// Moments computes the count, mean, variance, minimum and maximum of a stream
+// of values in constant memory, using Welford's online algorithm to keep the
+// variance numerically stable. The zero value is ready to use.
+type Moments struct {
+	count int
+	sum   float64
+	mean  float64 // mean is the running mean used by Welford's algorithm.
+	m2    float64 // m2 is the sum of squared differences from the current mean.
+	min   float64
+	max   float64
+}
+
+// Add adds a value to the moments.
+func (m *Moments) Add(x float64) {
+	if m.count == 0 {
+		m.min, m.max = x, x
+	}
+	m.count++
+	m.sum += x
+	delta := x - m.mean
+	m.mean += delta / float64(m.count)
+	m.m2 += delta * (x - m.mean)
+	m.min = math.Min(m.min, x)
+	m.max = math.Max(m.max, x)
+}
+
+// Count returns the number of values added.
+func (m *Moments) Count() int {
+	return m.count
+}
+
+// Mean returns the mean of the values added, or 0 if there are none.
+// It is computed from the exact sum, so that the mean of integers matches
+// a plain division.
+func (m *Moments) Mean() float64 {
+	if m.count == 0 {
+		return 0
+	}
+	return m.sum / float64(m.count)
+}
+
+// Variance returns the population variance of the values added, or 0 if there are none.
+func (m *Moments) Variance() float64 {
+	if m.count == 0 {
+		return 0
+	}
+	return m.m2 / float64(m.count)
+}
+
+// StdDev returns the population standard deviation of the values added.
+func (m *Moments) StdDev() float64 {
+	return math.Sqrt(m.Variance())
+}
+
+// Min returns the smallest value added, or 0 if there are none.
+func (m *Moments) Min() float64 {
+	return m.min
+}
+
+// Max returns the largest value added, or 0 if there are none.
+func (m *Moments) Max() float64 {
+	return m.max
+}
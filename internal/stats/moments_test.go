@@ -0,0 +1,72 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMoments(t *testing.T) {
+	tests := []struct {
+		name         string
+		values       []float64
+		wantMean     float64
+		wantVariance float64
+		wantMin      float64
+		wantMax      float64
+	}{
+		{
+			name: "Empty",
+		},
+		{
+			name:     "Single Value",
+			values:   []float64{5},
+			wantMean: 5,
+			wantMin:  5,
+			wantMax:  5,
+		},
+		{
+			name:         "Several Values",
+			values:       []float64{2, 4, 4, 4, 5, 5, 7, 9},
+			wantMean:     5,
+			wantVariance: 4,
+			wantMin:      2,
+			wantMax:      9,
+		},
+		{
+			name:         "Negative Values",
+			values:       []float64{-3, 3},
+			wantMean:     0,
+			wantVariance: 9,
+			wantMin:      -3,
+			wantMax:      3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Moments
+			for _, v := range tt.values {
+				m.Add(v)
+			}
+
+			if m.Count() != len(tt.values) {
+				t.Errorf("Count() = %d, want %d", m.Count(), len(tt.values))
+			}
+			if m.Mean() != tt.wantMean {
+				t.Errorf("Mean() = %f, want %f", m.Mean(), tt.wantMean)
+			}
+			if m.Variance() != tt.wantVariance {
+				t.Errorf("Variance() = %f, want %f", m.Variance(), tt.wantVariance)
+			}
+			if m.StdDev() != math.Sqrt(tt.wantVariance) {
+				t.Errorf("StdDev() = %f, want %f", m.StdDev(), math.Sqrt(tt.wantVariance))
+			}
+			if m.Min() != tt.wantMin {
+				t.Errorf("Min() = %f, want %f", m.Min(), tt.wantMin)
+			}
+			if m.Max() != tt.wantMax {
+				t.Errorf("Max() = %f, want %f", m.Max(), tt.wantMax)
+			}
+		})
+	}
+}
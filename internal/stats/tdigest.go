@@ -0,0 +1,154 @@
+// Package stats provides streaming estimators that summarize a sequence of
+// values in bounded memory, such as running moments and quantile sketches.
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultCompression is the compression used by the aggregator's digests.
+//
+// With this compression a digest holds at most a few hundred centroids whatever
+// the number of values added. The t-digest has no worst-case guarantee, but its
+// rank error is empirically below 0.5% for median quantiles and shrinks towards
+// the tails (around 0.05% at p99), because the scale function keeps centroids
+// near q=0 and q=1 small. Sequences shorter than about 50 values are not merged
+// at all, so their quantiles are exact up to linear interpolation.
+const DefaultCompression = 100
+
+// centroid is a cluster of values summarized by their mean and count.
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// TDigest is a merging t-digest (Dunning & Ertl, "Computing Extremely Accurate
+// Quantiles Using t-Digests") that estimates quantiles of a stream of values
+// using memory proportional to its compression, not to the number of values.
+// It is not safe for concurrent use.
+type TDigest struct {
+	compression float64
+	centroids   []centroid // centroids are the merged clusters, sorted by mean.
+	buffer      []centroid // buffer holds the values added since the last merge.
+	count       float64
+	min         float64
+	max         float64
+}
+
+// NewTDigest creates an empty TDigest with the given compression. Higher
+// compressions are more accurate and use more memory.
+func NewTDigest(compression float64) *TDigest {
+	return &TDigest{
+		compression: compression,
+		buffer:      make([]centroid, 0, bufferSize(compression)),
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// bufferSize returns the number of values buffered between two merges.
+func bufferSize(compression float64) int {
+	return int(5 * compression)
+}
+
+// Add adds a value to the digest.
+func (d *TDigest) Add(x float64) {
+	if math.IsNaN(x) {
+		return
+	}
+	d.buffer = append(d.buffer, centroid{mean: x, weight: 1})
+	d.count++
+	d.min = math.Min(d.min, x)
+	d.max = math.Max(d.max, x)
+	if len(d.buffer) == cap(d.buffer) {
+		d.merge()
+	}
+}
+
+// Count returns the number of values added to the digest.
+func (d *TDigest) Count() int {
+	return int(d.count)
+}
+
+// Quantile returns an estimate of the q-quantile of the values added, for q
+// between 0 and 1. It returns 0 if the digest is empty.
+func (d *TDigest) Quantile(q float64) float64 {
+	d.merge()
+	if len(d.centroids) == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return d.min
+	}
+	if q >= 1 {
+		return d.max
+	}
+
+	// Each centroid is considered to sit at the middle of its weight; values
+	// between two centroids are linearly interpolated, and values outside the
+	// first and last centroids are interpolated towards the minimum and maximum.
+	target := q * d.count
+	first := d.centroids[0]
+	if target < first.weight/2 {
+		return d.min + (first.mean-d.min)*target/(first.weight/2)
+	}
+
+	cumulative := 0.0
+	for i := 0; i < len(d.centroids)-1; i++ {
+		left, right := d.centroids[i], d.centroids[i+1]
+		leftCenter := cumulative + left.weight/2
+		rightCenter := cumulative + left.weight + right.weight/2
+		if target < rightCenter {
+			return left.mean + (right.mean-left.mean)*(target-leftCenter)/(rightCenter-leftCenter)
+		}
+		cumulative += left.weight
+	}
+
+	last := d.centroids[len(d.centroids)-1]
+	lastCenter := d.count - last.weight/2
+	return last.mean + (d.max-last.mean)*(target-lastCenter)/(last.weight/2)
+}
+
+// merge folds the buffered values into the centroids, combining adjacent
+// centroids as long as the result respects the size limit of the k1 scale
+// function.
+func (d *TDigest) merge() {
+	if len(d.buffer) == 0 {
+		return
+	}
+
+	all := append(d.buffer, d.centroids...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	merged := make([]centroid, 0, len(d.centroids)+1)
+	current := all[0]
+	weightSoFar := 0.0
+	limit := d.qLimit(0)
+	for _, c := range all[1:] {
+		if (weightSoFar+current.weight+c.weight)/d.count <= limit {
+			current.weight += c.weight
+			current.mean += (c.mean - current.mean) * c.weight / current.weight
+			continue
+		}
+		weightSoFar += current.weight
+		merged = append(merged, current)
+		limit = d.qLimit(weightSoFar / d.count)
+		current = c
+	}
+	merged = append(merged, current)
+
+	d.centroids = merged
+	d.buffer = d.buffer[:0]
+}
+
+// qLimit returns the largest quantile that a centroid starting at quantile q
+// may extend to, using the k1 scale function k(q) = δ/2π·asin(2q-1).
+func (d *TDigest) qLimit(q float64) float64 {
+	k := d.compression / (2 * math.Pi) * math.Asin(2*q-1)
+	k++
+	if k >= d.compression/4 {
+		return 1
+	}
+	return (math.Sin(2*math.Pi*k/d.compression) + 1) / 2
+}
@@ -0,0 +1,101 @@
+package stats
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"testing"
+)
+
+func TestTDigest_QuantileSmall(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float64
+		q        float64
+		expected float64
+	}{
+		{
+			name:     "Empty",
+			q:        0.5,
+			expected: 0,
+		},
+		{
+			name:     "Single Value",
+			values:   []float64{7},
+			q:        0.5,
+			expected: 7,
+		},
+		{
+			name:     "Median Of Two",
+			values:   []float64{10, 20},
+			q:        0.5,
+			expected: 15,
+		},
+		{
+			name:     "Median Of Odd Count",
+			values:   []float64{3, 1, 2},
+			q:        0.5,
+			expected: 2,
+		},
+		{
+			name:     "Minimum",
+			values:   []float64{3, 1, 2},
+			q:        0,
+			expected: 1,
+		},
+		{
+			name:     "Maximum",
+			values:   []float64{3, 1, 2},
+			q:        1,
+			expected: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewTDigest(DefaultCompression)
+			for _, v := range tt.values {
+				d.Add(v)
+			}
+			if got := d.Quantile(tt.q); got != tt.expected {
+				t.Errorf("Quantile(%v) = %v, want %v", tt.q, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTDigest_QuantileAccuracy(t *testing.T) {
+	distributions := map[string]func(r *rand.Rand) float64{
+		"Uniform":     func(r *rand.Rand) float64 { return r.Float64() * 1000 },
+		"Exponential": func(r *rand.Rand) float64 { return r.ExpFloat64() * 100 },
+		"Pareto":      func(r *rand.Rand) float64 { return math.Floor(10 / math.Pow(1-r.Float64(), 1/1.2)) },
+	}
+	quantiles := []float64{0.5, 0.9, 0.95, 0.99}
+
+	for name, sample := range distributions {
+		t.Run(name, func(t *testing.T) {
+			r := rand.New(rand.NewPCG(1, 2))
+			d := NewTDigest(DefaultCompression)
+			values := make([]float64, 100000)
+			for i := range values {
+				values[i] = sample(r)
+				d.Add(values[i])
+			}
+			sort.Float64s(values)
+
+			if d.Count() != len(values) {
+				t.Errorf("Count() = %d, want %d", d.Count(), len(values))
+			}
+			for _, q := range quantiles {
+				estimate := d.Quantile(q)
+				rank := float64(sort.SearchFloat64s(values, estimate)) / float64(len(values))
+				if math.Abs(rank-q) > 0.005 {
+					t.Errorf("Quantile(%v) = %v has rank %v, want within 0.005", q, estimate, rank)
+				}
+			}
+			if len(d.centroids) > 10*DefaultCompression {
+				t.Errorf("digest holds %d centroids, want a bounded number", len(d.centroids))
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"upfcc/internal/sseclient"
+
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// logMagic starts every store file, so that other files are not loaded by mistake.
+const logMagic = "UPFCCSTORE1\n"
+
+// maxLogField bounds the size of a field read from a store file, so that a
+// corrupted length does not make the reader allocate without limit.
+const maxLogField = 1 << 20
+
+// ErrNotStore is returned when a file does not start like a store file.
+var ErrNotStore = errors.New("store: not a post store file")
+
+// postLog is the append-only file of a store.
+//
+// A store file starts with logMagic and holds one record per post: the arrival
+// time in Unix nanoseconds as a varint, then the type of the post and its data
+// encoded as JSON, each as a uvarint length followed by the bytes.
+type postLog struct {
+	path string
+	f    *os.File
+	w    *bufio.Writer
+	buf  []byte
+}
+
+// createLog writes the given entries to a new store file at path, replacing
+// the existing one atomically, and opens it for appending.
+func createLog(path string, entries []Entry) (*postLog, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	l := &postLog{path: path, f: f, w: bufio.NewWriter(f)}
+
+	err = l.writeAll(entries)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	return l, nil
+}
+
+// writeAll writes the header and the entries, and flushes them to the file.
+func (l *postLog) writeAll(entries []Entry) error {
+	l.w.WriteString(logMagic)
+	for _, entry := range entries {
+		if err := l.write(entry); err != nil {
+			return err
+		}
+	}
+	return l.w.Flush()
+}
+
+// write encodes an entry to the buffered writer.
+func (l *postLog) write(entry Entry) error {
+	data, err := json.Marshal(entry.Post.Data)
+	if err != nil {
+		return err
+	}
+	l.buf = binary.AppendVarint(l.buf[:0], entry.At.UnixNano())
+	l.buf = binary.AppendUvarint(l.buf, uint64(len(entry.Post.Type)))
+	l.buf = append(l.buf, entry.Post.Type...)
+	l.buf = binary.AppendUvarint(l.buf, uint64(len(data)))
+	l.buf = append(l.buf, data...)
+	_, err = l.w.Write(l.buf)
+	return err
+}
+
+// compact rewrites the file with the retained entries only.
+func (l *postLog) compact(entries []Entry) error {
+	compacted, err := createLog(l.path, entries)
+	if err != nil {
+		return err
+	}
+	l.f.Close()
+	*l = *compacted
+	return nil
+}
+
+// close closes the file.
+func (l *postLog) close() error {
+	if err := l.w.Flush(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+// readLog reads the entries of the store file at path. A missing file holds no
+// entries, and an incomplete last record, left by a crash, is ignored.
+func readLog(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, len(logMagic))
+	if _, err := io.ReadFull(r, header); err != nil || string(header) != logMagic {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotStore)
+	}
+
+	var entries []Entry
+	for {
+		entry, err := readEntry(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// readEntry reads a record of a store file.
+func readEntry(r *bufio.Reader) (Entry, error) {
+	nanos, err := binary.ReadVarint(r)
+	if err != nil {
+		return Entry{}, err
+	}
+	postType, err := readField(r)
+	if err != nil {
+		return Entry{}, err
+	}
+	data, err := readField(r)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{At: time.Unix(0, nanos), Post: sseclient.Post{Type: string(postType)}}
+	if err := json.Unmarshal(data, &entry.Post.Data); err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+// readField reads a length-prefixed field of a record. A field cut short is
+// reported as io.ErrUnexpectedEOF.
+func readField(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if errors.Is(err, io.EOF) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n > maxLogField {
+		return nil, fmt.Errorf("record field of %d bytes exceeds the maximum of %d", n, maxLogField)
+	}
+	field := make([]byte, n)
+	if _, err := io.ReadFull(r, field); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return field, nil
+}
@@ -0,0 +1,7 @@
+package store
+
+import "upfcc/internal/metrics"
+
+// storedPosts is the number of posts in the store, exposed on /metrics.
+var storedPosts = metrics.Default.NewGauge("upfcc_store_posts",
+	"Number of posts kept in the history store.")
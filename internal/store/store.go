@@ -0,0 +1,190 @@
+// Package store provides an embedded, time-indexed store of the posts read from
+// the SSE stream, so that analyses can be answered from history instead of
+// waiting for new posts.
+//
+// Posts are indexed by their arrival time and kept in memory within retention
+// limits. When a file is configured, they are also appended to it, so that the
+// history survives restarts. The file is written by a goroutine of its own, so
+// that ingestion never waits for the disk.
+package store
+
+import (
+	"upfcc/internal/sseclient"
+
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ingestWindow is the duration of each subscription of Ingest to the stream.
+// Ingest subscribes again when it elapses, so it only bounds a single ReadStream call.
+const ingestWindow = 24 * time.Hour
+
+// Entry is a post with the time it arrived at.
+type Entry struct {
+	At   time.Time      // At is the arrival time of the post.
+	Post sseclient.Post // Post is the post as read from the stream.
+}
+
+// Options configures a Store.
+type Options struct {
+	MaxAge   time.Duration // MaxAge is how long posts are kept, 0 for no age limit.
+	MaxPosts int           // MaxPosts is the maximum number of posts kept, the oldest being dropped first, 0 for no limit.
+	Path     string        // Path is the file the posts are persisted to, empty to keep them in memory only.
+}
+
+// StreamReader is the interface of the SSE client the store ingests posts from.
+type StreamReader interface {
+	ReadStream(ctx context.Context, duration time.Duration) chan sseclient.Post
+}
+
+// Store keeps the posts of the last MaxAge, up to MaxPosts, ordered by arrival time.
+//
+// Entries are only ever appended or dropped from the front, never modified in
+// place, so the slices returned by Range remain valid while new posts arrive.
+type Store struct {
+	opts Options
+	now  func() time.Time
+
+	mu      sync.RWMutex
+	entries []Entry
+	writer  *logWriter // writer persists the posts, nil when the store is in memory only.
+	dropped int        // dropped is the number of posts of the file that are no longer retained.
+}
+
+// Open creates a Store with the given options. When a file is configured, the
+// posts it holds are loaded, within the retention limits, before new posts are
+// appended to it.
+//
+// Parameters:
+//   - opts: The retention limits and the optional file of the store.
+//
+// Returns:
+//   - The Store, to be closed with Close.
+//   - An error if the file cannot be read or written.
+func Open(opts Options) (*Store, error) {
+	s := &Store{opts: opts, now: time.Now}
+	if opts.Path == "" {
+		return s, nil
+	}
+
+	entries, err := readLog(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	s.entries = entries
+	s.prune(s.now())
+
+	// The file is rewritten with the retained posts only, then appended to.
+	l, err := createLog(opts.Path, s.entries)
+	if err != nil {
+		return nil, err
+	}
+	s.writer = newLogWriter(l)
+	storedPosts.Set(float64(len(s.entries)))
+	return s, nil
+}
+
+// Add stores a post that arrived at the given time. Arrival times never go
+// backwards: a post arriving before the last one, after a clock adjustment,
+// is stored at the time of the last one.
+func (s *Store) Add(at time.Time, post sseclient.Post) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n := len(s.entries); n > 0 && at.Before(s.entries[n-1].At) {
+		at = s.entries[n-1].At
+	}
+	entry := Entry{At: at, Post: post}
+	s.entries = append(s.entries, entry)
+	if s.writer != nil {
+		s.writer.append(entry)
+	}
+
+	s.prune(at)
+	storedPosts.Set(float64(len(s.entries)))
+}
+
+// prune drops the posts beyond the retention limits. The file, if any, is
+// compacted by the writer once it holds more dropped posts than retained ones.
+// It must be called with s.mu held.
+func (s *Store) prune(now time.Time) {
+	dropped := 0
+	if s.opts.MaxAge > 0 {
+		cutoff := now.Add(-s.opts.MaxAge)
+		dropped = sort.Search(len(s.entries), func(i int) bool { return !s.entries[i].At.Before(cutoff) })
+	}
+	if s.opts.MaxPosts > 0 && len(s.entries)-dropped > s.opts.MaxPosts {
+		dropped = len(s.entries) - s.opts.MaxPosts
+	}
+	if dropped == 0 {
+		return
+	}
+
+	// The dropped entries are not cleared, as Range may have returned them; their
+	// memory is released when append moves the entries to a new backing array.
+	s.entries = s.entries[dropped:]
+
+	if s.writer != nil {
+		s.dropped += dropped
+		if s.dropped > len(s.entries) {
+			// The entries are never modified in place, so the writer can rewrite
+			// the file from them after the lock is released.
+			s.writer.compact(s.entries[:len(s.entries):len(s.entries)])
+			s.dropped = 0
+		}
+	}
+}
+
+// Range returns the posts that arrived in [from, to), ordered by arrival time.
+// The returned slice is shared with the store and must not be modified.
+func (s *Store) Range(from, to time.Time) []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start := sort.Search(len(s.entries), func(i int) bool { return !s.entries[i].At.Before(from) })
+	end := sort.Search(len(s.entries), func(i int) bool { return !s.entries[i].At.Before(to) })
+	if start >= end {
+		return nil
+	}
+	return s.entries[start:end:end]
+}
+
+// Len returns the number of posts in the store.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries)
+}
+
+// Ingest reads the stream and stores its posts, with their arrival time, until
+// ctx is done.
+func (s *Store) Ingest(ctx context.Context, client StreamReader) {
+	for {
+		for post := range client.ReadStream(ctx, ingestWindow) {
+			s.Add(s.now(), post)
+		}
+
+		// Wait before subscribing again, in case the stream ended early.
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Close writes the posts not yet persisted and closes the file of the store, if
+// any. Posts added afterwards are kept in memory only.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	writer := s.writer
+	s.writer = nil
+	s.mu.Unlock()
+
+	if writer == nil {
+		return nil
+	}
+	return writer.close()
+}
@@ -0,0 +1,295 @@
+package store
+
+import (
+	"upfcc/internal/sseclient"
+
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStore_Range(t *testing.T) {
+	s, _ := Open(Options{})
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 5; i++ {
+		s.Add(start.Add(time.Duration(i)*time.Second), post(i))
+	}
+
+	tests := []struct {
+		name      string
+		from, to  time.Duration
+		wantLikes []int
+	}{
+		{name: "All", from: -time.Hour, to: time.Hour, wantLikes: []int{0, 1, 2, 3, 4}},
+		{name: "Half Open", from: time.Second, to: 3 * time.Second, wantLikes: []int{1, 2}},
+		{name: "Between Posts", from: 1500 * time.Millisecond, to: 2500 * time.Millisecond, wantLikes: []int{2}},
+		{name: "Empty", from: 10 * time.Second, to: time.Hour},
+		{name: "Reversed", from: 3 * time.Second, to: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := likes(s.Range(start.Add(tt.from), start.Add(tt.to)))
+			if !slices.Equal(got, tt.wantLikes) {
+				t.Errorf("Range() got likes %v, want %v", got, tt.wantLikes)
+			}
+		})
+	}
+}
+
+func TestStore_Retention(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      Options
+		wantLikes []int
+	}{
+		{name: "Max Age", opts: Options{MaxAge: 2 * time.Second}, wantLikes: []int{2, 3, 4}},
+		{name: "Max Posts", opts: Options{MaxPosts: 2}, wantLikes: []int{3, 4}},
+		{name: "Both", opts: Options{MaxAge: 3 * time.Second, MaxPosts: 3}, wantLikes: []int{2, 3, 4}},
+		{name: "Unlimited", opts: Options{}, wantLikes: []int{0, 1, 2, 3, 4}},
+	}
+
+	start := time.Unix(1700000000, 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := Open(tt.opts)
+			for i := 0; i < 5; i++ {
+				s.Add(start.Add(time.Duration(i)*time.Second), post(i))
+			}
+
+			got := likes(s.Range(start.Add(-time.Hour), start.Add(time.Hour)))
+			if !slices.Equal(got, tt.wantLikes) || s.Len() != len(tt.wantLikes) {
+				t.Errorf("store holds likes %v, want %v", got, tt.wantLikes)
+			}
+		})
+	}
+}
+
+func TestStore_RangeIsStable(t *testing.T) {
+	s, _ := Open(Options{MaxPosts: 2})
+	start := time.Unix(1700000000, 0)
+	s.Add(start, post(0))
+	s.Add(start.Add(time.Second), post(1))
+
+	entries := s.Range(start, start.Add(time.Hour))
+	for i := 2; i < 100; i++ {
+		s.Add(start.Add(time.Duration(i)*time.Second), post(i))
+	}
+
+	if got := likes(entries); !slices.Equal(got, []int{0, 1}) {
+		t.Errorf("entries returned by Range changed to %v when posts were added", got)
+	}
+}
+
+func TestStore_AddClockGoingBackwards(t *testing.T) {
+	s, _ := Open(Options{})
+	start := time.Unix(1700000000, 0)
+	s.Add(start, post(0))
+	s.Add(start.Add(-time.Minute), post(1))
+
+	entries := s.Range(start, start.Add(time.Second))
+	if len(entries) != 2 || !entries[1].At.Equal(start) {
+		t.Errorf("Range() = %v, want the late post stored at the time of the previous one", entries)
+	}
+}
+
+func TestStore_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "posts.store")
+	now := time.Now()
+
+	s, err := Open(Options{Path: path})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	s.Add(now.Add(-2*time.Hour), post(0))
+	s.Add(now.Add(-time.Minute), post(1))
+	s.Add(now, sseclient.Post{Type: "tweet", Data: sseclient.SocialPost{Timestamp: 42, Likes: 2, Retweets: 3, ID: "t1",
+		Extra: map[string]json.RawMessage{"views": json.RawMessage("10")}}})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Simulate a crash in the middle of a record.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{0x80, 0x80})
+	f.Close()
+
+	s, err = Open(Options{Path: path, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("Open() of an existing file error = %v", err)
+	}
+	defer s.Close()
+
+	entries := s.Range(now.Add(-3*time.Hour), now.Add(time.Second))
+	if got := likes(entries); !slices.Equal(got, []int{1, 2}) {
+		t.Fatalf("reopened store holds likes %v, want the posts within the retention", got)
+	}
+	views, _ := entries[1].Post.Data.Number("views")
+	if entries[1].Post.Type != "tweet" || entries[1].Post.Data.Retweets != 3 || entries[1].Post.Data.ID != "t1" || views != 10 || !entries[1].At.Equal(now) {
+		t.Errorf("reopened store holds %+v, want the stored post", entries[1])
+	}
+}
+
+func TestStore_Compaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "posts.store")
+	s, err := Open(Options{Path: path, MaxPosts: 10})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 1000; i++ {
+		s.Add(start.Add(time.Duration(i)*time.Second), post(i))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	entries, err := readLog(path)
+	if err != nil {
+		t.Fatalf("readLog() error = %v", err)
+	}
+	if len(entries) > 21 {
+		t.Errorf("store file holds %d posts, want it compacted to at most twice the 10 retained", len(entries))
+	}
+	if last := entries[len(entries)-1].Post.Data.Likes; last != 999 {
+		t.Errorf("last post of the store file has %d likes, want 999", last)
+	}
+}
+
+func TestStore_AddDuringCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "posts.store")
+	s, err := Open(Options{Path: path, MaxPosts: 100})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	// The first compaction is held until the posts below are added.
+	compacting := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	s.writer.compacting = func() {
+		once.Do(func() {
+			close(compacting)
+			<-release
+		})
+	}
+
+	start := time.Unix(1700000000, 0)
+	i := 0
+	for ; i < 201; i++ {
+		s.Add(start.Add(time.Duration(i)*time.Second), post(i))
+	}
+	select {
+	case <-compacting:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the store file was not compacted")
+	}
+
+	added := make(chan struct{})
+	go func() {
+		for ; i < 1000; i++ {
+			s.Add(start.Add(time.Duration(i)*time.Second), post(i))
+			s.Range(start, start.Add(time.Hour))
+		}
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Add() and Range() waited for the compaction")
+	}
+	close(release)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if got := likes(s.Range(start, start.Add(time.Hour))); len(got) != 100 || got[0] != 900 || got[99] != 999 {
+		t.Errorf("store holds likes %v, want the last 100 posts", got)
+	}
+	entries, err := readLog(path)
+	if err != nil {
+		t.Fatalf("readLog() error = %v", err)
+	}
+	got := likes(entries)
+	if len(got) < 100 || got[len(got)-1] != 999 {
+		t.Fatalf("store file holds likes %v, want at least the last 100 posts", got)
+	}
+	for j := 1; j < len(got); j++ {
+		if got[j] != got[j-1]+1 {
+			t.Fatalf("store file lost the posts between %d and %d likes", got[j-1], got[j])
+		}
+	}
+}
+
+func TestOpen_NotStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte("{}"), 0o644)
+
+	if _, err := Open(Options{Path: path}); !errors.Is(err, ErrNotStore) {
+		t.Errorf("Open() error = %v, want ErrNotStore", err)
+	}
+}
+
+func TestStore_Ingest(t *testing.T) {
+	s, _ := Open(Options{})
+	client := &MockStreamReader{posts: []sseclient.Post{post(1), post(2)}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Ingest(ctx, client)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for s.Len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	got := likes(s.Range(time.Now().Add(-time.Minute), time.Now().Add(time.Second)))
+	if !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("Ingest() stored likes %v, want the posts of the stream", got)
+	}
+}
+
+/////// Helpers
+
+// MockStreamReader sends its posts, then waits for the context to be done.
+type MockStreamReader struct {
+	posts []sseclient.Post
+}
+
+func (m *MockStreamReader) ReadStream(ctx context.Context, duration time.Duration) chan sseclient.Post {
+	postChan := make(chan sseclient.Post)
+	go func() {
+		defer close(postChan)
+		for _, post := range m.posts {
+			postChan <- post
+		}
+		<-ctx.Done()
+	}()
+	return postChan
+}
+
+// post returns a tweet with the given number of likes.
+func post(likes int) sseclient.Post {
+	return sseclient.Post{Type: "tweet", Data: sseclient.SocialPost{Timestamp: int64(likes), Likes: likes}}
+}
+
+// likes returns the likes of the posts of the entries.
+func likes(entries []Entry) []int {
+	var got []int
+	for _, entry := range entries {
+		got = append(got, entry.Post.Data.Likes)
+	}
+	return got
+}
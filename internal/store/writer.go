@@ -0,0 +1,104 @@
+package store
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// logWriter writes the posts of a store to its file in a goroutine of its own,
+// so that neither the writes nor the compactions hold the lock of the store:
+// ingestion and Range never wait for the disk.
+type logWriter struct {
+	log        *postLog
+	compacting func() // compacting is called before each compaction, so that tests can hold it.
+
+	mu          sync.Mutex
+	pending     []Entry // pending are the entries queued to be appended to the file.
+	snapshot    []Entry // snapshot are the entries to rewrite the file with, nil if no compaction is queued.
+	snapshotted int     // snapshotted is the number of pending entries the snapshot holds.
+	closed      bool
+	wake        chan struct{}
+	done        chan error
+}
+
+// newLogWriter starts writing to the given file, until close is called.
+func newLogWriter(l *postLog) *logWriter {
+	w := &logWriter{
+		log:        l,
+		compacting: func() {},
+		wake:       make(chan struct{}, 1),
+		done:       make(chan error, 1),
+	}
+	go w.run()
+	return w
+}
+
+// append queues an entry to be appended to the file.
+func (w *logWriter) append(entry Entry) {
+	w.mu.Lock()
+	w.pending = append(w.pending, entry)
+	w.mu.Unlock()
+	w.signal()
+}
+
+// compact queues a rewrite of the file with the given entries, which must hold
+// every entry queued so far. The entries are not copied, so they must not be
+// modified afterwards.
+func (w *logWriter) compact(entries []Entry) {
+	w.mu.Lock()
+	w.snapshot = entries
+	w.snapshotted = len(w.pending)
+	w.mu.Unlock()
+	w.signal()
+}
+
+// close writes the queued entries, stops the writer and closes the file.
+func (w *logWriter) close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	w.signal()
+	return <-w.done
+}
+
+// signal wakes the writer up, unless it is already due to wake up.
+func (w *logWriter) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run writes the queued entries in batches, each flushed at once, so that a
+// crash loses at most the batch being written.
+func (w *logWriter) run() {
+	for range w.wake {
+		w.mu.Lock()
+		pending, snapshot, snapshotted, closed := w.pending, w.snapshot, w.snapshotted, w.closed
+		w.pending, w.snapshot, w.snapshotted = nil, nil, 0
+		w.mu.Unlock()
+
+		if snapshot != nil {
+			w.compacting()
+			if err := w.log.compact(snapshot); err != nil {
+				// The entries held by the snapshot are appended to the old file instead.
+				slog.Error("Failed to compact the post store", "error", err)
+			} else {
+				pending = pending[snapshotted:]
+			}
+		}
+		for _, entry := range pending {
+			if err := w.log.write(entry); err != nil {
+				slog.Error("Failed to persist a post", "error", err)
+			}
+		}
+		if err := w.log.w.Flush(); err != nil {
+			slog.Error("Failed to persist posts", "error", err)
+		}
+
+		if closed {
+			w.done <- w.log.close()
+			return
+		}
+	}
+}
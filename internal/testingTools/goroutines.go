@@ -0,0 +1,36 @@
+package testingTools
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// CheckNoGoroutineLeak records the current number of goroutines and returns a
+// function that fails the test if that number has not gone back to the
+// recorded one within a second. It is typically used as:
+//
+//	defer testingTools.CheckNoGoroutineLeak(t)()
+func CheckNoGoroutineLeak(t testing.TB) func() {
+	t.Helper()
+	baseline := runtime.NumGoroutine()
+
+	return func() {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			n := runtime.NumGoroutine()
+			if n <= baseline {
+				return
+			}
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				stacks := string(buf[:runtime.Stack(buf, true)])
+				t.Errorf("%d goroutines leaked, want %d or less:\n%s", n-baseline, baseline, strings.TrimSpace(stacks))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
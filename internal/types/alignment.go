@@ -0,0 +1,19 @@
+package types
+
+// Alignment defines the time the buckets of a time-bucketed analysis are based on.
+type Alignment string
+
+const (
+	AlignWallClock Alignment = "wall_clock" // AlignWallClock buckets posts by the time they were received.
+	AlignTimestamp Alignment = "timestamp"  // AlignTimestamp buckets posts by their own Timestamp.
+)
+
+// IsValidAlignment verifies if the given alignment is valid.
+func IsValidAlignment(alignment Alignment) bool {
+	switch alignment {
+	case AlignWallClock, AlignTimestamp:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,23 +1,16 @@
 // package types defines the types that are globally used in the application.
 package types
 
-// ValidDimensions defines the valid dimensions for social media interactions.
-// A dimension is a type of social media interaction, such as likes, comments, favorites, or retweets.
+// Dimension is the name of a dimension of social media interactions, such as likes,
+// comments, favorites, or retweets. The dimensions that can be analyzed are
+// registered in a dimension.Registry.
 type Dimension string
 
+// The names of the built-in dimensions.
 const (
-	Likes Dimension = "likes"
-	Comments Dimension = "comments"
-	Favorites Dimension = "favorites"
-	Retweets Dimension = "retweets"
+	Likes        Dimension = "likes"
+	Comments     Dimension = "comments"
+	Favorites    Dimension = "favorites"
+	Retweets     Dimension = "retweets"
+	Interactions Dimension = "interactions"
 )
-
-// IsValidDimension verifies if the given dimension is valid.
-func IsValidDimension(dimension Dimension) bool {
-	switch dimension {
-	case Likes, Comments, Favorites, Retweets:
-		return true
-	default:
-		return false
-	}
-}
\ No newline at end of file
@@ -0,0 +1,18 @@
+package types
+
+// GroupBy defines a criterion by which the results of an analysis can be broken down.
+type GroupBy string
+
+const (
+	GroupByType GroupBy = "type" // GroupByType breaks results down by post type (e.g., tweet, pin).
+)
+
+// IsValidGroupBy verifies if the given group by criterion is valid.
+func IsValidGroupBy(groupBy GroupBy) bool {
+	switch groupBy {
+	case GroupByType:
+		return true
+	default:
+		return false
+	}
+}
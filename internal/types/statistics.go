@@ -0,0 +1,43 @@
+package types
+
+// Statistic defines a statistic that can be computed over the values of a dimension,
+// in addition to the average that is always returned.
+type Statistic string
+
+const (
+	P50      Statistic = "p50"
+	P90      Statistic = "p90"
+	P95      Statistic = "p95"
+	P99      Statistic = "p99"
+	Min      Statistic = "min"
+	Max      Statistic = "max"
+	Variance Statistic = "variance"
+	StdDev   Statistic = "stddev"
+)
+
+// IsValidStatistic verifies if the given statistic is valid.
+func IsValidStatistic(statistic Statistic) bool {
+	switch statistic {
+	case P50, P90, P95, P99, Min, Max, Variance, StdDev:
+		return true
+	default:
+		return false
+	}
+}
+
+// Quantile returns the quantile, between 0 and 1, estimated by the statistic.
+// The second return value is false if the statistic is not a quantile.
+func (s Statistic) Quantile() (float64, bool) {
+	switch s {
+	case P50:
+		return 0.5, true
+	case P90:
+		return 0.9, true
+	case P95:
+		return 0.95, true
+	case P99:
+		return 0.99, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenHost is returned, wrapped, for the callback URLs whose host the
+// results cannot be delivered to.
+var ErrForbiddenHost = errors.New("host is not allowed")
+
+// blockedPrefixes are the special-purpose ranges, on top of the loopback,
+// private, link-local and multicast ones, that results are not delivered to
+// unless their host is allowed explicitly.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "This" network.
+	netip.MustParsePrefix("100.64.0.0/10"), // Shared address space of carrier-grade NATs.
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments.
+	netip.MustParsePrefix("198.18.0.0/15"), // Benchmarking.
+	netip.MustParsePrefix("240.0.0.0/4"),   // Reserved, and the broadcast address.
+}
+
+// CheckURL checks that results can be delivered to a callback URL: its host
+// must be one of AllowedHosts if they are set, and must not be an IP address
+// that is not public otherwise. Host names are checked again once resolved,
+// when connecting, so that they cannot point at internal services either.
+//
+// Parameters:
+//   - u: The callback URL.
+//
+// Returns:
+//   - An error wrapping ErrForbiddenHost if the host is not allowed.
+func (d *Dispatcher) CheckURL(u *url.URL) error {
+	return d.checkHost(u.Hostname())
+}
+
+// checkHost checks that results can be delivered to a host, as described in CheckURL.
+func (d *Dispatcher) checkHost(host string) error {
+	if len(d.opts.AllowedHosts) > 0 {
+		if slices.ContainsFunc(d.opts.AllowedHosts, func(allowed string) bool { return strings.EqualFold(allowed, host) }) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s is not one of the allowed hosts", ErrForbiddenHost, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !publicAddr(addr) {
+		return fmt.Errorf("%w: %s is not a public address", ErrForbiddenHost, host)
+	}
+	return nil
+}
+
+// publicAddr reports whether an address is public, so that results can be
+// delivered to it when no host is allowed explicitly.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// newClient returns the HTTP client delivering the results. It does not follow
+// redirects, which could lead to any host, nor use a proxy. Unless hosts are
+// allowed explicitly, it only connects to public addresses, checked once host
+// names are resolved, so that DNS cannot point callbacks at internal services.
+func newClient(allowedHosts []string) *http.Client {
+	dialer := &net.Dialer{Timeout: defaultTimeout, KeepAlive: 30 * time.Second}
+	if len(allowedHosts) == 0 {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s is not a public address", ErrForbiddenHost, addrPort.Addr())
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   defaultTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,343 @@
+// Package webhook delivers the results of analyses to callback URLs.
+//
+// A result is sent as the JSON body of a POST request, signed with HMAC-SHA256
+// so that receivers can verify that it comes from the server and is recent.
+// The signature covers the timestamp and the body, joined by a dot:
+//
+//	X-Upfcc-Timestamp: 1714557600
+//	X-Upfcc-Signature: sha256=hex(HMAC-SHA256(secret, "1714557600." + body))
+//
+// Failed deliveries are retried with an exponential backoff, and every attempt
+// is recorded, so that deliveries can be inspected. Results are only delivered
+// to public addresses, or to the hosts allowed explicitly, and redirects are
+// not followed, so that callbacks cannot reach internal services. On shutdown, pending
+// deliveries are given a grace period to be delivered, then given up.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers set on the requests of deliveries.
+const (
+	TimestampHeader = "X-Upfcc-Timestamp" // TimestampHeader holds the Unix time the request was signed at.
+	SignatureHeader = "X-Upfcc-Signature" // SignatureHeader holds "sha256=" and the hex encoded signature.
+	DeliveryHeader  = "X-Upfcc-Delivery"  // DeliveryHeader holds the ID of the delivery, the same for every attempt.
+)
+
+const (
+	// maxDeliveries is the number of deliveries kept for inspection, the oldest being forgotten first.
+	maxDeliveries = 1000
+	// maxBackoff bounds the delay between two attempts.
+	maxBackoff = 5 * time.Minute
+	// defaultTimeout bounds the time of an attempt when no HTTP client is configured.
+	defaultTimeout = 10 * time.Second
+)
+
+// Sign returns the signature of a body sent at the given time.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify verifies the signature of a delivery received by a callback URL.
+//
+// Parameters:
+//   - secret: The secret shared with the server.
+//   - header: The headers of the request.
+//   - body: The body of the request.
+//   - tolerance: The maximum age of the signature, to reject replayed requests.
+//   - now: The current time.
+//
+// Returns:
+//   - An error if the signature is missing, invalid or too old.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	seconds, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %w", TimestampHeader, err)
+	}
+	timestamp := time.Unix(seconds, 0)
+	if now.Sub(timestamp).Abs() > tolerance {
+		return fmt.Errorf("signature timestamp %v is not within %v of now", timestamp, tolerance)
+	}
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(Sign(secret, timestamp, body))) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// Status is the status of a delivery.
+type Status string
+
+const (
+	Pending   Status = "pending"   // Pending deliveries are being attempted, or waiting for a retry.
+	Delivered Status = "delivered" // Delivered deliveries were accepted by the callback URL.
+	Failed    Status = "failed"    // Failed deliveries were given up.
+)
+
+// Attempt is an attempt to deliver a result.
+type Attempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"` // StatusCode is the status code of the response, if any.
+	Error      string    `json:"error,omitempty"`       // Error describes why the attempt failed, if it did.
+}
+
+// Delivery is the delivery of the result of an analysis to a callback URL.
+type Delivery struct {
+	ID            string     `json:"id"`
+	JobID         string     `json:"job_id,omitempty"` // JobID is the ID of the analysis job the result comes from.
+	URL           string     `json:"url"`
+	Status        Status     `json:"status"`
+	CreatedAt     time.Time  `json:"created_at"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"` // NextAttemptAt is the time of the next retry, if one is scheduled.
+	Attempts      []Attempt  `json:"attempts"`
+}
+
+// ErrShutdown is returned by Deliver once the dispatcher is shutting down.
+var ErrShutdown = errors.New("webhook: dispatcher is shutting down")
+
+// Options configures a Dispatcher.
+type Options struct {
+	Secret      string        // Secret is the key of the HMAC-SHA256 signatures.
+	MaxAttempts int           // MaxAttempts is the number of attempts before a delivery is given up.
+	Backoff     time.Duration // Backoff is the delay before the first retry, doubled after every retry.
+	// AllowedHosts are the only hosts results are delivered to, whatever their
+	// addresses. When empty, results are delivered to any host with a public address.
+	AllowedHosts []string
+	// Client sends the requests, used as is if set. By default, an http.Client
+	// with a 10s timeout, which checks the addresses it connects to and does not
+	// follow redirects.
+	Client *http.Client
+}
+
+// Dispatcher delivers results to callback URLs in the background, and keeps
+// the last deliveries for inspection. It is safe for concurrent use.
+type Dispatcher struct {
+	opts  Options
+	now   func() time.Time
+	ctx   context.Context    // ctx is done once pending deliveries are given up, and aborts their requests.
+	abort context.CancelFunc // abort gives up the pending deliveries.
+
+	mu         sync.Mutex
+	deliveries []*Delivery    // deliveries are the last deliveries, oldest first.
+	shutdown   bool           // shutdown is set once Shutdown is called, so that no delivery starts afterwards.
+	pending    sync.WaitGroup // pending counts the deliveries being attempted or waiting for a retry.
+}
+
+// NewDispatcher creates a Dispatcher with the given options.
+func NewDispatcher(opts Options) *Dispatcher {
+	if opts.Client == nil {
+		opts.Client = newClient(opts.AllowedHosts)
+	}
+	ctx, abort := context.WithCancel(context.Background())
+	return &Dispatcher{opts: opts, now: time.Now, ctx: ctx, abort: abort}
+}
+
+// Deliver sends a payload to a callback URL in the background, retrying until
+// it is accepted or MaxAttempts attempts have failed.
+//
+// Parameters:
+//   - jobID: The ID of the analysis job the payload comes from.
+//   - url: The callback URL.
+//   - payload: The value sent as the JSON body of the requests.
+//
+// Returns:
+//   - The pending delivery.
+//   - An error if the payload cannot be encoded, or ErrShutdown if the dispatcher is shutting down.
+func (d *Dispatcher) Deliver(jobID, url string, payload any) (Delivery, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Delivery{}, err
+	}
+	id, err := newID()
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.shutdown {
+		return Delivery{}, ErrShutdown
+	}
+	delivery := &Delivery{ID: id, JobID: jobID, URL: url, Status: Pending, CreatedAt: d.now(), Attempts: []Attempt{}}
+	if len(d.deliveries) == maxDeliveries {
+		d.deliveries = slices.Delete(d.deliveries, 0, 1)
+	}
+	d.deliveries = append(d.deliveries, delivery)
+
+	d.pending.Add(1)
+	go d.run(delivery, body)
+	return delivery.copy(), nil
+}
+
+// List returns the last deliveries, newest first.
+//
+// Parameters:
+//   - jobID: The ID of the job whose deliveries are listed, or empty for all of them.
+func (d *Dispatcher) List(jobID string) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	deliveries := []Delivery{}
+	for i := len(d.deliveries) - 1; i >= 0; i-- {
+		if jobID == "" || d.deliveries[i].JobID == jobID {
+			deliveries = append(deliveries, d.deliveries[i].copy())
+		}
+	}
+	return deliveries
+}
+
+// Shutdown stops the dispatcher: no delivery can be started afterwards, and the
+// pending deliveries are given until ctx is done to be delivered, retries
+// included. The deliveries still pending then are given up, and recorded as
+// failed.
+//
+// Parameters:
+//   - ctx: A context that ends the grace period of the pending deliveries when done.
+//
+// Returns:
+//   - nil if every delivery was delivered or failed in time, or an error
+//     wrapping the error of ctx, with the number of deliveries given up.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	d.shutdown = true
+	d.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+	}
+
+	d.mu.Lock()
+	givenUp := 0
+	for _, delivery := range d.deliveries {
+		if delivery.Status == Pending {
+			givenUp++
+		}
+	}
+	d.mu.Unlock()
+	d.abort()
+	<-finished
+	return fmt.Errorf("gave up %d pending deliveries: %w", givenUp, ctx.Err())
+}
+
+// run attempts a delivery until it succeeds, fails permanently, runs out of
+// attempts or is given up by Shutdown.
+func (d *Dispatcher) run(delivery *Delivery, body []byte) {
+	defer d.pending.Done()
+	backoff := d.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		result, retry := d.attempt(delivery, body)
+
+		d.mu.Lock()
+		delivery.Attempts = append(delivery.Attempts, result)
+		delivery.NextAttemptAt = nil
+		switch {
+		case result.Error == "":
+			delivery.Status = Delivered
+		case !retry || attempt >= d.opts.MaxAttempts:
+			delivery.Status = Failed
+		default:
+			next := d.now().Add(backoff)
+			delivery.NextAttemptAt = &next
+		}
+		done := delivery.Status != Pending
+		d.mu.Unlock()
+
+		if done {
+			return
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-d.ctx.Done():
+			timer.Stop()
+			d.mu.Lock()
+			delivery.Status = Failed
+			delivery.NextAttemptAt = nil
+			d.mu.Unlock()
+			slog.Warn("Gave up a callback delivery on shutdown", "delivery", delivery.ID, "job", delivery.JobID, "url", delivery.URL)
+			return
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// attempt sends the request of a delivery once. The second return value
+// reports whether a failed attempt may succeed when retried: requests to hosts
+// that are not allowed, redirected, or rejected with a 4xx status code other
+// than 408 and 429 are not retried.
+func (d *Dispatcher) attempt(delivery *Delivery, body []byte) (Attempt, bool) {
+	now := d.now()
+	result := Attempt{At: now}
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		result.Error = err.Error()
+		return result, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(d.opts.Secret, now, body))
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	if err := d.checkHost(req.URL.Hostname()); err != nil {
+		result.Error = err.Error()
+		return result, false
+	}
+
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result, !errors.Is(err, ErrForbiddenHost)
+	}
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return result, false
+	}
+	result.Error = "unexpected status " + resp.Status
+	permanent := resp.StatusCode >= 300 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests
+	return result, !permanent
+}
+
+// copy returns a copy of the delivery that is not modified by later attempts.
+func (d *Delivery) copy() Delivery {
+	c := *d
+	c.Attempts = slices.Clone(d.Attempts)
+	return c
+}
+
+// newID returns a random delivery ID.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,297 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "s3cret"
+
+// testHosts allow the deliveries to the test servers, which listen on the loopback address.
+var testHosts = []string{"127.0.0.1"}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int // statuses are the status codes of the receiver, the last one being repeated.
+		maxAttempts  int
+		wantStatus   Status
+		wantAttempts int
+	}{
+		{name: "Delivered", statuses: []int{http.StatusOK}, maxAttempts: 3, wantStatus: Delivered, wantAttempts: 1},
+		{name: "Retried", statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusNoContent}, maxAttempts: 3, wantStatus: Delivered, wantAttempts: 3},
+		{name: "Out Of Attempts", statuses: []int{http.StatusBadGateway}, maxAttempts: 3, wantStatus: Failed, wantAttempts: 3},
+		{name: "Rejected", statuses: []int{http.StatusBadRequest}, maxAttempts: 3, wantStatus: Failed, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &testReceiver{t: t, statuses: tt.statuses}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			d := NewDispatcher(Options{Secret: testSecret, AllowedHosts: testHosts, MaxAttempts: tt.maxAttempts, Backoff: time.Millisecond})
+			delivery, err := d.Deliver("job-1", server.URL, map[string]int{"total_posts": 3})
+			if err != nil {
+				t.Fatalf("Deliver() error = %v", err)
+			}
+			if delivery.Status != Pending || delivery.JobID != "job-1" || delivery.URL != server.URL {
+				t.Errorf("Deliver() = %+v, want a pending delivery", delivery)
+			}
+
+			got := waitDone(t, d, delivery.ID)
+			if got.Status != tt.wantStatus || len(got.Attempts) != tt.wantAttempts || got.NextAttemptAt != nil {
+				t.Errorf("delivery = %+v, want %s after %d attempts", got, tt.wantStatus, tt.wantAttempts)
+			}
+			last := got.Attempts[len(got.Attempts)-1]
+			if (last.Error == "") != (tt.wantStatus == Delivered) || last.StatusCode == 0 {
+				t.Errorf("last attempt = %+v, want its status code and an error only if it failed", last)
+			}
+
+			bodies := receiver.received()
+			if len(bodies) != tt.wantAttempts {
+				t.Fatalf("receiver got %d requests, want %d", len(bodies), tt.wantAttempts)
+			}
+			for _, body := range bodies {
+				if body != `{"total_posts":3}` {
+					t.Errorf("receiver got body %s, want the JSON payload", body)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatcher_List(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	d := NewDispatcher(Options{Secret: testSecret, AllowedHosts: testHosts, MaxAttempts: 1})
+	first, _ := d.Deliver("job-1", server.URL, 1)
+	second, _ := d.Deliver("job-2", server.URL, 2)
+
+	if got := d.List(""); len(got) != 2 || got[0].ID != second.ID || got[1].ID != first.ID {
+		t.Errorf("List() = %+v, want both deliveries, newest first", got)
+	}
+	if got := d.List("job-1"); len(got) != 1 || got[0].ID != first.ID {
+		t.Errorf("List(job-1) = %+v, want the delivery of job-1", got)
+	}
+	if got := d.List("job-3"); got == nil || len(got) != 0 {
+		t.Errorf("List(job-3) = %#v, want an empty list", got)
+	}
+}
+
+func TestDispatcher_CheckURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		allowedHosts []string
+		url          string
+		wantErr      bool
+	}{
+		{name: "Public Host", url: "https://example.com/hook"},
+		{name: "Public Address", url: "http://93.184.216.34/hook"},
+		{name: "Loopback", url: "http://127.0.0.1:8080/hook", wantErr: true},
+		{name: "Private", url: "http://10.0.0.1/hook", wantErr: true},
+		{name: "Metadata Service", url: "http://169.254.169.254/latest/meta-data", wantErr: true},
+		{name: "IPv6 Loopback", url: "http://[::1]/hook", wantErr: true},
+		{name: "IPv4 Mapped Loopback", url: "http://[::ffff:127.0.0.1]/hook", wantErr: true},
+		{name: "Carrier-Grade NAT", url: "http://100.64.0.1/hook", wantErr: true},
+		{name: "Allowed Host", allowedHosts: []string{"hooks.example.com"}, url: "https://HOOKS.example.com/hook"},
+		{name: "Allowed Private Address", allowedHosts: []string{"10.0.0.1"}, url: "http://10.0.0.1/hook"},
+		{name: "Host Not Allowed", allowedHosts: []string{"hooks.example.com"}, url: "https://example.com/hook", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDispatcher(Options{Secret: testSecret, MaxAttempts: 1, AllowedHosts: tt.allowedHosts})
+			u, _ := url.Parse(tt.url)
+			if err := d.CheckURL(u); (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrForbiddenHost)) {
+				t.Errorf("CheckURL(%s) error = %v, want error %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDispatcher_DeliverForbidden(t *testing.T) {
+	receiver := &testReceiver{t: t, statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(server.URL, http.StatusFound))
+	defer redirect.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	tests := []struct {
+		name         string
+		allowedHosts []string
+		url          string
+		wantErr      string
+	}{
+		// The host name is only found to be the loopback address once resolved.
+		{name: "Resolved To Loopback", url: "http://localhost:" + port, wantErr: "not a public address"},
+		{name: "Redirect", allowedHosts: testHosts, url: redirect.URL, wantErr: "302 Found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDispatcher(Options{Secret: testSecret, MaxAttempts: 3, Backoff: time.Millisecond, AllowedHosts: tt.allowedHosts})
+			delivery, _ := d.Deliver("job-1", tt.url, 1)
+
+			got := waitDone(t, d, delivery.ID)
+			if got.Status != Failed || len(got.Attempts) != 1 || !strings.Contains(got.Attempts[0].Error, tt.wantErr) {
+				t.Errorf("delivery = %+v, want it given up at once with an error containing %q", got, tt.wantErr)
+			}
+			if bodies := receiver.received(); len(bodies) != 0 {
+				t.Errorf("receiver got %d requests, want none", len(bodies))
+			}
+		})
+	}
+}
+
+func TestDispatcher_Shutdown(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		wantErr    bool
+		wantStatus Status
+	}{
+		{name: "Drained", statuses: []int{http.StatusInternalServerError, http.StatusOK}, wantStatus: Delivered},
+		{name: "Given Up", statuses: []int{http.StatusServiceUnavailable}, wantErr: true, wantStatus: Failed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &testReceiver{t: t, statuses: tt.statuses}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			d := NewDispatcher(Options{Secret: testSecret, AllowedHosts: testHosts, MaxAttempts: 3, Backoff: 20 * time.Millisecond})
+			if tt.wantErr {
+				// The retry would only happen long after the grace period.
+				d.opts.Backoff = time.Hour
+			}
+			delivery, _ := d.Deliver("job-1", server.URL, 1)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err := d.Shutdown(ctx)
+			if (err != nil) != tt.wantErr || time.Since(start) > time.Second {
+				t.Errorf("Shutdown() error = %v after %v, want error %v within the grace period", err, time.Since(start), tt.wantErr)
+			}
+
+			got := d.List("")[0]
+			if got.Status != tt.wantStatus || got.NextAttemptAt != nil {
+				t.Errorf("delivery = %+v, want %s", got, tt.wantStatus)
+			}
+			if _, err := d.Deliver("job-2", server.URL, 2); !errors.Is(err, ErrShutdown) {
+				t.Errorf("Deliver() error = %v after Shutdown, want ErrShutdown", err)
+			}
+			if got.ID != delivery.ID {
+				t.Errorf("List() = %+v, want only the delivery started before Shutdown", d.List(""))
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1714557600, 0)
+	body := []byte(`{"total_posts":3}`)
+	header := http.Header{}
+	header.Set(TimestampHeader, "1714557600")
+	header.Set(SignatureHeader, Sign(testSecret, now, body))
+
+	tests := []struct {
+		name    string
+		secret  string
+		body    string
+		now     time.Time
+		header  func(h http.Header)
+		wantErr string
+	}{
+		{name: "Valid", secret: testSecret, body: string(body), now: now.Add(time.Minute)},
+		{name: "Wrong Secret", secret: "other", body: string(body), now: now, wantErr: "invalid signature"},
+		{name: "Tampered Body", secret: testSecret, body: `{"total_posts":4}`, now: now, wantErr: "invalid signature"},
+		{name: "Too Old", secret: testSecret, body: string(body), now: now.Add(time.Hour), wantErr: "is not within 5m0s of now"},
+		{name: "Replayed Timestamp", secret: testSecret, body: string(body), now: now,
+			header: func(h http.Header) { h.Set(TimestampHeader, "1714557601") }, wantErr: "invalid signature"},
+		{name: "Missing Timestamp", secret: testSecret, body: string(body), now: now,
+			header: func(h http.Header) { h.Del(TimestampHeader) }, wantErr: "invalid X-Upfcc-Timestamp header"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := header.Clone()
+			if tt.header != nil {
+				tt.header(h)
+			}
+			err := Verify(tt.secret, h, []byte(tt.body), 5*time.Minute, tt.now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Verify() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Verify() error = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+/////// Helpers
+
+// testReceiver is a callback URL that verifies the signature of the deliveries
+// and responds with the given status codes in turn.
+type testReceiver struct {
+	t        *testing.T
+	statuses []int
+
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (rcv *testReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := Verify(testSecret, r.Header, body, time.Minute, time.Now()); err != nil {
+		rcv.t.Errorf("receiver got an invalid signature: %v", err)
+	}
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.Header.Get(DeliveryHeader) == "" {
+		rcv.t.Errorf("receiver got %s with headers %v, want a JSON POST with the delivery ID", r.Method, r.Header)
+	}
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.bodies = append(rcv.bodies, string(body))
+	w.WriteHeader(rcv.statuses[min(len(rcv.bodies), len(rcv.statuses))-1])
+}
+
+// received returns the bodies of the requests received so far.
+func (rcv *testReceiver) received() []string {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return rcv.bodies
+}
+
+// waitDone waits for the delivery to be delivered or given up, and returns it.
+func waitDone(t *testing.T, d *Dispatcher, id string) Delivery {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, delivery := range d.List("") {
+			if delivery.ID == id && delivery.Status != Pending {
+				return delivery
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("delivery %s is still pending", id)
+	return Delivery{}
+}